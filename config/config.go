@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+/*
+config is a package used to read lsshell specific settings.
+
+The settings are written in the same file as lssh (~/.lssh.conf).
+lssh ignores the keys it does not know, so both can read one file.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/blacknon/lssh/common"
+)
+
+// Config is Struct that stores lsshell settings in the configuration file.
+type Config struct {
+	Shell  ShellConfig             `toml:"shell"`
+	Server map[string]ServerConfig `toml:"server"`
+	Group  map[string]GroupConfig  `toml:"group"`
+	Guard  GuardConfig             `toml:"guard"`
+}
+
+// Read load configuration file and return Config structure.
+func Read(confPath string) (c Config) {
+	if !common.IsExist(confPath) {
+		return
+	}
+
+	_, err := toml.DecodeFile(confPath, &c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "err: Read config file error: %s\n", err)
+		os.Exit(1)
+	}
+
+	return
+}
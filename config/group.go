@@ -0,0 +1,253 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Host expression.
+// It is used by `-H` option, target host prefix (`@expr:`) and `%add` in shell.
+//   - name        ... server name
+//   - web*        ... glob pattern of server name
+//   - /^web\d+$/  ... regular expression of server name
+//   - tag:TAG     ... servers with tag (ex. `tag:role=web`)
+//   - group:NAME  ... servers in group
+
+// maxGroupDepth is max depth of group in group.
+const maxGroupDepth = 10
+
+// ServerConfig store lsshell settings in `[server.<name>]`, in addition to lssh conf.ServerConfig.
+type ServerConfig struct {
+	// Tags is tags of server. `key=value` or any string.
+	// ex.) ["role=web", "env=prod"]
+	Tags []string `toml:"tags"`
+}
+
+// GroupConfig store host group in `[group.<name>]`.
+// Servers matching one of Hosts, and servers with all of Tags are in the group.
+type GroupConfig struct {
+	// Hosts is list of host expression.
+	// ex.) ["web*", "group:db"]
+	Hosts []string `toml:"hosts"`
+
+	// Tags is list of tags. Servers with all of them are in the group.
+	// ex.) ["role=web", "env=prod"]
+	Tags []string `toml:"tags"`
+}
+
+// IsHostPattern return true if term is host expression that can match several servers.
+func IsHostPattern(term string) bool {
+	switch {
+	case strings.HasPrefix(term, "tag:"), strings.HasPrefix(term, "group:"):
+		return true
+	case len(term) > 1 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/"):
+		return true
+	}
+
+	return strings.ContainsAny(term, "*?[")
+}
+
+// HasTags return true if server has all of tags.
+func (c Config) HasTags(server string, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range c.Server[server].Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchHosts return names matching one of host expression terms, in order of names.
+// It returns error if any term matches nothing.
+func (c Config) MatchHosts(names []string, terms []string) (result []string, err error) {
+	matched := map[string]bool{}
+	for _, term := range terms {
+		hosts, err := c.matchTerm(names, term, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("no host matched `%s`", term)
+		}
+
+		for _, h := range hosts {
+			matched[h] = true
+		}
+	}
+
+	for _, name := range names {
+		if matched[name] {
+			result = append(result, name)
+		}
+	}
+
+	return
+}
+
+// SelectHosts return names selected by `-H` (hosts), `-g` (groups) and `--tag` (tags) options.
+// Servers matching hosts or groups are selected (all servers if both are empty), and then
+// they are filtered by tags.
+func (c Config) SelectHosts(names []string, hosts, groups, tags []string) (result []string, err error) {
+	terms := append([]string{}, hosts...)
+	for _, g := range groups {
+		terms = append(terms, "group:"+g)
+	}
+
+	selected := names
+	if len(terms) > 0 {
+		selected, err = c.MatchHosts(names, terms)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, name := range selected {
+		if c.HasTags(name, tags) {
+			result = append(result, name)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no host matched tag `%s`", strings.Join(tags, ","))
+	}
+
+	return
+}
+
+// matchTerm return names matching host expression term.
+func (c Config) matchTerm(names []string, term string, depth int) (result []string, err error) {
+	switch {
+	// tag
+	case strings.HasPrefix(term, "tag:"):
+		tag := strings.TrimPrefix(term, "tag:")
+		for _, name := range names {
+			if c.HasTags(name, []string{tag}) {
+				result = append(result, name)
+			}
+		}
+
+	// group
+	case strings.HasPrefix(term, "group:"):
+		return c.matchGroup(names, strings.TrimPrefix(term, "group:"), depth)
+
+	// regular expression
+	case len(term) > 1 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/"):
+		re, err := regexp.Compile(term[1 : len(term)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid host regexp `%s`: %s", term, err)
+		}
+
+		for _, name := range names {
+			if re.MatchString(name) {
+				result = append(result, name)
+			}
+		}
+
+	// glob
+	case strings.ContainsAny(term, "*?["):
+		if _, err := path.Match(term, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern `%s`: %s", term, err)
+		}
+
+		for _, name := range names {
+			if ok, _ := path.Match(term, name); ok {
+				result = append(result, name)
+			}
+		}
+
+	// server name
+	default:
+		for _, name := range names {
+			if name == term {
+				result = append(result, name)
+			}
+		}
+	}
+
+	return
+}
+
+// matchGroup return names in group.
+func (c Config) matchGroup(names []string, group string, depth int) (result []string, err error) {
+	g, ok := c.Group[group]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("group `%s` is not found in config", group)
+	case depth >= maxGroupDepth:
+		return nil, fmt.Errorf("group `%s` is nested too deeply", group)
+	}
+
+	matched := map[string]bool{}
+	for _, term := range g.Hosts {
+		hosts, err := c.matchTerm(names, term, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, h := range hosts {
+			matched[h] = true
+		}
+	}
+
+	if len(g.Tags) > 0 {
+		for _, name := range names {
+			if c.HasTags(name, g.Tags) {
+				matched[name] = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if matched[name] {
+			result = append(result, name)
+		}
+	}
+
+	return
+}
+
+// GroupsOf return sorted group names which server is in. names is all server names.
+func (c Config) GroupsOf(names []string, server string) (groups []string) {
+	for _, name := range c.GetGroupNames() {
+		hosts, err := c.matchGroup(names, name, 0)
+		if err != nil {
+			continue
+		}
+
+		for _, h := range hosts {
+			if h == server {
+				groups = append(groups, name)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+
+	return
+}
+
+// GetGroupNames return group names in config.
+func (c Config) GetGroupNames() (groups []string) {
+	for name := range c.Group {
+		groups = append(groups, name)
+	}
+
+	return
+}
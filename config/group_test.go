@@ -0,0 +1,251 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testNames = []string{"web01", "web02", "web10", "db01", "db02", "batch"}
+
+// testGroupConfig return config with tags and groups, include nested and cyclic groups.
+func testGroupConfig() Config {
+	return Config{
+		Server: map[string]ServerConfig{
+			"web01": {Tags: []string{"role=web", "env=prod"}},
+			"web02": {Tags: []string{"role=web", "env=stg"}},
+			"web10": {Tags: []string{"role=web", "env=prod"}},
+			"db01":  {Tags: []string{"role=db", "env=prod"}},
+			"db02":  {Tags: []string{"role=db", "env=stg"}},
+		},
+		Group: map[string]GroupConfig{
+			"web":      {Hosts: []string{"web*"}},
+			"db":       {Hosts: []string{"/^db\\d+$/"}},
+			"prod":     {Tags: []string{"env=prod"}},
+			"prodweb":  {Tags: []string{"env=prod", "role=web"}},
+			"mixed":    {Hosts: []string{"batch"}, Tags: []string{"role=db"}},
+			"all":      {Hosts: []string{"group:web", "group:db", "batch"}},
+			"nested":   {Hosts: []string{"group:all"}},
+			"cycle-a":  {Hosts: []string{"group:cycle-b"}},
+			"cycle-b":  {Hosts: []string{"group:cycle-a"}},
+			"self":     {Hosts: []string{"web01", "group:self"}},
+			"unknown":  {Hosts: []string{"group:missing"}},
+			"empty":    {},
+			"badregex": {Hosts: []string{"/(/"}},
+		},
+	}
+}
+
+// TestIsHostPattern check host expression that can match several servers.
+func TestIsHostPattern(t *testing.T) {
+	tests := []struct {
+		term string
+		want bool
+	}{
+		{term: "web01", want: false},
+		{term: "web*", want: true},
+		{term: "web0?", want: true},
+		{term: "web[01]", want: true},
+		{term: "/^web/", want: true},
+		{term: "/", want: false},
+		{term: "/web", want: false},
+		{term: "tag:role=web", want: true},
+		{term: "group:web", want: true},
+		{term: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			if got := IsHostPattern(tt.term); got != tt.want {
+				t.Errorf("IsHostPattern(%q) = %v, want %v", tt.term, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHasTags check that server has all of tags.
+func TestHasTags(t *testing.T) {
+	c := testGroupConfig()
+
+	tests := []struct {
+		name   string
+		server string
+		tags   []string
+		want   bool
+	}{
+		{name: "no tags", server: "web01", want: true},
+		{name: "one tag", server: "web01", tags: []string{"role=web"}, want: true},
+		{name: "all tags", server: "web01", tags: []string{"role=web", "env=prod"}, want: true},
+		{name: "one of tags", server: "web02", tags: []string{"role=web", "env=prod"}, want: false},
+		{name: "server without tags", server: "batch", tags: []string{"role=web"}, want: false},
+		{name: "unknown server", server: "missing", tags: []string{"role=web"}, want: false},
+		{name: "prefix of tag", server: "web01", tags: []string{"role"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.HasTags(tt.server, tt.tags); got != tt.want {
+				t.Errorf("HasTags(%q, %q) = %v, want %v", tt.server, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchHosts check each host expression form, and errors.
+func TestMatchHosts(t *testing.T) {
+	c := testGroupConfig()
+
+	tests := []struct {
+		name    string
+		terms   []string
+		want    []string
+		wantErr string
+	}{
+		{name: "name", terms: []string{"db01"}, want: []string{"db01"}},
+		{name: "glob", terms: []string{"web0*"}, want: []string{"web01", "web02"}},
+		{name: "glob question", terms: []string{"db0?"}, want: []string{"db01", "db02"}},
+		{name: "glob class", terms: []string{"web[1]*"}, want: []string{"web10"}},
+		{name: "regex", terms: []string{"/^web\\d0$/"}, want: []string{"web10"}},
+		{name: "regex partial", terms: []string{"/b0/"}, want: []string{"web01", "web02", "db01", "db02"}},
+		{name: "tag", terms: []string{"tag:env=stg"}, want: []string{"web02", "db02"}},
+		{name: "group hosts", terms: []string{"group:web"}, want: []string{"web01", "web02", "web10"}},
+		{name: "group regex", terms: []string{"group:db"}, want: []string{"db01", "db02"}},
+		{name: "group tags", terms: []string{"group:prod"}, want: []string{"web01", "web10", "db01"}},
+		{name: "group all tags", terms: []string{"group:prodweb"}, want: []string{"web01", "web10"}},
+		{name: "group hosts and tags", terms: []string{"group:mixed"}, want: []string{"db01", "db02", "batch"}},
+		{name: "nested group", terms: []string{"group:nested"}, want: testNames},
+		{name: "multiple terms in order of names", terms: []string{"batch", "db02", "web01"}, want: []string{"web01", "db02", "batch"}},
+		{name: "duplicated match", terms: []string{"web01", "web0*"}, want: []string{"web01", "web02"}},
+		{name: "no match", terms: []string{"app*"}, wantErr: "no host matched `app*`"},
+		{name: "one term no match", terms: []string{"web01", "app01"}, wantErr: "no host matched `app01`"},
+		{name: "empty group", terms: []string{"group:empty"}, wantErr: "no host matched `group:empty`"},
+		{name: "unknown group", terms: []string{"group:missing"}, wantErr: "group `missing` is not found in config"},
+		{name: "unknown nested group", terms: []string{"group:unknown"}, wantErr: "group `missing` is not found in config"},
+		{name: "group cycle", terms: []string{"group:cycle-a"}, wantErr: "is nested too deeply"},
+		{name: "group self reference", terms: []string{"group:self"}, wantErr: "group `self` is nested too deeply"},
+		{name: "invalid regex", terms: []string{"/(/"}, wantErr: "invalid host regexp"},
+		{name: "invalid regex in group", terms: []string{"group:badregex"}, wantErr: "invalid host regexp"},
+		{name: "invalid glob", terms: []string{"web[*"}, wantErr: "invalid host pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.MatchHosts(testNames, tt.terms)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("MatchHosts(%q) err = %v, want %q", tt.terms, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("MatchHosts(%q) err = %v", tt.terms, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchHosts(%q) = %q, want %q", tt.terms, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSelectHosts check selection by hosts, groups and tags options.
+func TestSelectHosts(t *testing.T) {
+	c := testGroupConfig()
+
+	tests := []struct {
+		name    string
+		hosts   []string
+		groups  []string
+		tags    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "all", want: testNames},
+		{name: "hosts", hosts: []string{"web*"}, want: []string{"web01", "web02", "web10"}},
+		{name: "groups", groups: []string{"db"}, want: []string{"db01", "db02"}},
+		{name: "hosts and groups", hosts: []string{"batch"}, groups: []string{"db"}, want: []string{"db01", "db02", "batch"}},
+		{name: "tags", tags: []string{"env=prod"}, want: []string{"web01", "web10", "db01"}},
+		{name: "tags all", tags: []string{"env=prod", "role=db"}, want: []string{"db01"}},
+		{name: "hosts filtered by tags", hosts: []string{"web*"}, tags: []string{"env=stg"}, want: []string{"web02"}},
+		{name: "no tag match", groups: []string{"web"}, tags: []string{"role=db"}, wantErr: "no host matched tag `role=db`"},
+		{name: "unknown group", groups: []string{"missing"}, wantErr: "group `missing` is not found in config"},
+		{name: "group cycle", groups: []string{"cycle-b"}, wantErr: "is nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.SelectHosts(testNames, tt.hosts, tt.groups, tt.tags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SelectHosts() err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("SelectHosts() err = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectHosts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchGroup check group expansion with depth limit.
+func TestMatchGroup(t *testing.T) {
+	c := testGroupConfig()
+
+	tests := []struct {
+		name    string
+		group   string
+		depth   int
+		want    []string
+		wantErr string
+	}{
+		{name: "group", group: "web", want: []string{"web01", "web02", "web10"}},
+		{name: "nested", group: "all", want: testNames},
+		{name: "empty", group: "empty", want: nil},
+		{name: "unknown", group: "missing", wantErr: "group `missing` is not found in config"},
+		{name: "max depth", group: "web", depth: maxGroupDepth, wantErr: "group `web` is nested too deeply"},
+		{name: "nested over max depth", group: "nested", depth: maxGroupDepth - 2, wantErr: "group `web` is nested too deeply"},
+		{name: "cycle", group: "cycle-a", wantErr: "is nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.matchGroup(testNames, tt.group, tt.depth)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("matchGroup(%q) err = %v, want %q", tt.group, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("matchGroup(%q) err = %v", tt.group, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchGroup(%q) = %q, want %q", tt.group, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGroupsOf check groups of server. Groups with error (unknown, cycle) are skipped.
+func TestGroupsOf(t *testing.T) {
+	c := testGroupConfig()
+
+	got := c.GroupsOf(testNames, "db01")
+	want := []string{"all", "db", "mixed", "nested", "prod"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupsOf(db01) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+// GuardConfig store command guard settings in `[guard]`.
+// Remote command matching Commands, or run on servers with one of Tags, requires typed confirmation.
+type GuardConfig struct {
+	// Commands is list of regex of dangerous command.
+	// ex.) ['rm\s+-rf\s+/', '\breboot\b', '\bshutdown\b', '(?i)drop\s+table']
+	Commands []string `toml:"commands"`
+
+	// Tags is list of server tags. Any command run on servers with one of them requires confirmation.
+	// ex.) ["env=prod"]
+	Tags []string `toml:"tags"`
+}
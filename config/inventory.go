@@ -0,0 +1,444 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/blacknon/lssh/common"
+	"github.com/blacknon/lssh/conf"
+	"github.com/kevinburke/ssh_config"
+)
+
+// Inventory.
+// `--inventory [type:]path` load servers from external file, in addition to lssh config.
+//   - ansible (ini, yaml) ... Ansible inventory. groups and children are preserved as group.
+//   - ssh                 ... OpenSSH config. Host entries without wildcard. Tokens (`%h`, `%p`...) in IdentityFile and ProxyCommand are expanded.
+//   - csv                 ... CSV with header (name,addr,port,user,pass,key,note,groups,tags).
+//   - json                ... JSON array of object with the same keys as csv.
+// If type is omitted, it is detected by the file name.
+
+// inventoryTypes is types of inventory.
+var inventoryTypes = []string{"ansible", "ini", "yaml", "ssh", "csv", "json"}
+
+// Inventory is servers and groups read from inventory file.
+type Inventory struct {
+	Path   string
+	Server map[string]conf.ServerConfig
+	Names  []string            // server names in order of file
+	Tags   map[string][]string // tags of server
+	Group  map[string]GroupConfig
+}
+
+// inventoryServer is server of csv and json inventory.
+type inventoryServer struct {
+	Name   string   `json:"name"`
+	Addr   string   `json:"addr"`
+	Port   string   `json:"port"`
+	User   string   `json:"user"`
+	Pass   string   `json:"pass"`
+	Key    string   `json:"key"`
+	Note   string   `json:"note"`
+	Groups []string `json:"groups"`
+	Tags   []string `json:"tags"`
+}
+
+// newInventory return empty Inventory of path.
+func newInventory(path string) *Inventory {
+	return &Inventory{
+		Path:   path,
+		Server: map[string]conf.ServerConfig{},
+		Tags:   map[string][]string{},
+		Group:  map[string]GroupConfig{},
+	}
+}
+
+// ReadInventory load inventory file of spec (`[type:]path`).
+func ReadInventory(spec string) (inv *Inventory, err error) {
+	invType, path := parseInventorySpec(spec)
+	path = common.GetFullPath(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	inv = newInventory(path)
+	switch invType {
+	case "ansible", "ini":
+		if invType == "ansible" && isYAMLPath(path) {
+			err = inv.readAnsibleYAML(data)
+		} else {
+			err = inv.readAnsibleINI(data)
+		}
+	case "yaml":
+		err = inv.readAnsibleYAML(data)
+	case "ssh":
+		err = inv.readSSHConfig(data)
+	case "csv":
+		err = inv.readCSV(data)
+	case "json":
+		err = inv.readJSON(data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return
+}
+
+// parseInventorySpec return type and path of spec (`[type:]path`).
+func parseInventorySpec(spec string) (invType, path string) {
+	if i := strings.Index(spec, ":"); i > 0 {
+		for _, t := range inventoryTypes {
+			if spec[:i] == t {
+				return t, spec[i+1:]
+			}
+		}
+	}
+
+	path = spec
+	base := strings.ToLower(filepath.Base(path))
+	switch {
+	case isYAMLPath(path):
+		invType = "yaml"
+	case strings.HasSuffix(base, ".csv"):
+		invType = "csv"
+	case strings.HasSuffix(base, ".json"):
+		invType = "json"
+	case base == "config" || strings.Contains(base, "ssh_config"):
+		invType = "ssh"
+	default:
+		invType = "ini"
+	}
+
+	return
+}
+
+// isYAMLPath return true if path is yaml file.
+func isYAMLPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// addServer add server to inventory. Addr is name, if it is empty.
+func (inv *Inventory) addServer(name string, sc conf.ServerConfig, groups, tags []string) {
+	if sc.Addr == "" {
+		sc.Addr = name
+	}
+	if sc.Note == "" {
+		sc.Note = "from:" + inv.Path
+	}
+
+	if _, ok := inv.Server[name]; !ok {
+		inv.Names = append(inv.Names, name)
+	}
+	inv.Server[name] = sc
+
+	for _, g := range groups {
+		inv.addGroupHosts(g, name)
+	}
+	if len(tags) > 0 {
+		inv.Tags[name] = tags
+	}
+}
+
+// addGroupHosts add host expressions to group.
+func (inv *Inventory) addGroupHosts(group string, hosts ...string) {
+	g := inv.Group[group]
+	for _, h := range hosts {
+		if indexOfString(g.Hosts, h) < 0 {
+			g.Hosts = append(g.Hosts, h)
+		}
+	}
+	inv.Group[group] = g
+}
+
+// readSSHConfig read OpenSSH config. Host entries with wildcard and negated host are ignored.
+func (inv *Inventory) readSSHConfig(data []byte) (err error) {
+	cfg, err := ssh_config.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	get := func(host, key string) string {
+		v, _ := cfg.Get(host, key)
+		return v
+	}
+
+	for _, h := range cfg.Hosts {
+		for _, pattern := range h.Patterns {
+			// negated pattern (`!host`) is not server. pattern.String() does not include `!`, so it is checked by Matches.
+			host := pattern.String()
+			if strings.ContainsAny(host, "*?!") || !h.Matches(host) {
+				continue
+			}
+
+			sc := conf.ServerConfig{
+				Addr:         get(host, "HostName"),
+				Port:         get(host, "Port"),
+				User:         get(host, "User"),
+				Key:          get(host, "IdentityFile"),
+				ProxyCommand: get(host, "ProxyCommand"),
+			}
+
+			// expand tokens (`%h`, `%p`...) and `~`, as OpenSSH
+			tokens := sshConfigTokens(host, sc)
+			sc.Key = expandSSHConfigTokens(expandHomeDir(sc.Key, tokens['d']), tokens)
+			sc.ProxyCommand = expandSSHConfigTokens(sc.ProxyCommand, tokens)
+
+			inv.addServer(host, sc, nil, nil)
+		}
+	}
+
+	return
+}
+
+// sshConfigTokens return values of tokens in ssh_config of host.
+//   - %d ... local home directory
+//   - %h ... remote hostname (HostName, or host)
+//   - %n ... host (original name)
+//   - %p ... remote port (default 22)
+//   - %r ... remote user (default local user)
+//   - %u ... local user
+func sshConfigTokens(host string, sc conf.ServerConfig) map[byte]string {
+	tokens := map[byte]string{
+		'h': sc.Addr,
+		'n': host,
+		'p': sc.Port,
+		'r': sc.User,
+	}
+
+	if tokens['h'] == "" {
+		tokens['h'] = host
+	}
+	if tokens['p'] == "" {
+		tokens['p'] = "22"
+	}
+
+	if u, err := user.Current(); err == nil {
+		tokens['d'] = u.HomeDir
+		tokens['u'] = u.Username
+	}
+	if tokens['r'] == "" {
+		tokens['r'] = tokens['u']
+	}
+
+	return tokens
+}
+
+// expandSSHConfigTokens replace tokens (`%h`, `%p`...) in value. `%%` is `%`, and unknown token is kept.
+func expandSSHConfigTokens(value string, tokens map[byte]string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 >= len(value) {
+			buf.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch t, ok := tokens[value[i]]; {
+		case value[i] == '%':
+			buf.WriteByte('%')
+		case ok:
+			buf.WriteString(t)
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(value[i])
+		}
+	}
+
+	return buf.String()
+}
+
+// expandHomeDir replace `~` at the start of path with home.
+func expandHomeDir(path, home string) string {
+	switch {
+	case home == "":
+		return path
+	case path == "~":
+		return home
+	case strings.HasPrefix(path, "~/"):
+		return filepath.Join(home, path[2:])
+	}
+
+	return path
+}
+
+// readCSV read CSV inventory. The first line is header, and the order of columns is free.
+// groups and tags are separated by `;` or space.
+func (inv *Inventory) readCSV(data []byte) (err error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	if indexOfString(header, "name") < 0 {
+		return fmt.Errorf("`name` column is not found in header")
+	}
+
+	split := func(str string) []string {
+		return strings.FieldsFunc(str, func(r rune) bool { return r == ';' || r == ' ' })
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var s inventoryServer
+		for i, v := range record {
+			if i >= len(header) {
+				break
+			}
+
+			v = strings.TrimSpace(v)
+			switch header[i] {
+			case "name":
+				s.Name = v
+			case "addr", "host", "hostname":
+				s.Addr = v
+			case "port":
+				s.Port = v
+			case "user":
+				s.User = v
+			case "pass", "password":
+				s.Pass = v
+			case "key":
+				s.Key = v
+			case "note":
+				s.Note = v
+			case "groups", "group":
+				s.Groups = split(v)
+			case "tags", "tag":
+				s.Tags = split(v)
+			}
+		}
+
+		inv.addInventoryServer(s)
+	}
+
+	return
+}
+
+// readJSON read JSON inventory (array of object).
+func (inv *Inventory) readJSON(data []byte) (err error) {
+	var servers []inventoryServer
+	err = json.Unmarshal(data, &servers)
+	if err != nil {
+		return
+	}
+
+	for _, s := range servers {
+		inv.addInventoryServer(s)
+	}
+
+	return
+}
+
+// addInventoryServer add server of csv and json inventory. Server without name is ignored.
+func (inv *Inventory) addInventoryServer(s inventoryServer) {
+	if s.Name == "" {
+		return
+	}
+
+	sc := conf.ServerConfig{
+		Addr: s.Addr,
+		Port: s.Port,
+		User: s.User,
+		Pass: s.Pass,
+		Key:  s.Key,
+		Note: s.Note,
+	}
+	inv.addServer(s.Name, sc, s.Groups, s.Tags)
+}
+
+// AddInventory add servers of inventory to lssh config data, and tags and groups to c.
+// Servers already defined in lssh config are not overwritten. `[common]` of lssh config is applied to servers,
+// and User is local user if it is still empty.
+func (c *Config) AddInventory(data *conf.Config, inv *Inventory) {
+	if data.Server == nil {
+		data.Server = map[string]conf.ServerConfig{}
+	}
+	if c.Server == nil {
+		c.Server = map[string]ServerConfig{}
+	}
+	if c.Group == nil {
+		c.Group = map[string]GroupConfig{}
+	}
+
+	for _, name := range inv.Names {
+		if _, ok := data.Server[name]; ok {
+			continue
+		}
+
+		sc := reduceServerConfig(data.Common, inv.Server[name])
+		if sc.User == "" {
+			if u, err := user.Current(); err == nil {
+				sc.User = u.Username
+			}
+		}
+		data.Server[name] = sc
+
+		if tags, ok := inv.Tags[name]; ok {
+			ext := c.Server[name]
+			ext.Tags = append(ext.Tags, tags...)
+			c.Server[name] = ext
+		}
+	}
+
+	for name, group := range inv.Group {
+		g := c.Group[name]
+		g.Hosts = append(g.Hosts, group.Hosts...)
+		g.Tags = append(g.Tags, group.Tags...)
+		c.Group[name] = g
+	}
+}
+
+// reduceServerConfig return server config that empty field of child is set by parent. (same as lssh)
+func reduceServerConfig(parent, child conf.ServerConfig) (result conf.ServerConfig) {
+	parentMap, _ := common.StructToMap(&parent)
+	childMap, _ := common.StructToMap(&child)
+
+	resultMap := common.MapReduce(parentMap, childMap)
+	common.MapToStruct(resultMap, &result)
+
+	return
+}
+
+// indexOfString return index of str in list. -1 if not found.
+func indexOfString(list []string, str string) int {
+	for i, l := range list {
+		if l == str {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,459 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blacknon/lssh/conf"
+	"gopkg.in/yaml.v3"
+)
+
+// Ansible inventory (ini, yaml).
+// The following variables are mapped to server config. Variables of `all`, parent groups,
+// child groups and host are applied in this order (the later has priority).
+//   - ansible_host, ansible_ssh_host          ... addr
+//   - ansible_port, ansible_ssh_port          ... port
+//   - ansible_user, ansible_ssh_user          ... user
+//   - ansible_password, ansible_ssh_pass      ... pass
+//   - ansible_ssh_private_key_file            ... key
+// Groups except `all` and `ungrouped` are preserved as group, and children as `group:NAME`.
+
+// ansibleInventory is parsed Ansible inventory, before variables are resolved.
+type ansibleInventory struct {
+	hosts      []string
+	hostVars   map[string]map[string]string
+	groups     map[string]*ansibleGroup
+	groupOrder []string
+}
+
+// ansibleGroup is group of Ansible inventory.
+type ansibleGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+// ansibleYAMLGroup is group of Ansible yaml inventory.
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*ansibleYAMLGroup      `yaml:"children"`
+}
+
+// hostRangeRegexp is regexp of host range of Ansible inventory. ex.) `web[01:10]`, `db-[a:c]`, `app[1:9:2]`
+var hostRangeRegexp = regexp.MustCompile(`\[([0-9]+|[a-z]):([0-9]+|[a-z])(?::([0-9]+))?\]`)
+
+// newAnsibleInventory return empty ansibleInventory.
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostVars: map[string]map[string]string{},
+		groups:   map[string]*ansibleGroup{},
+	}
+}
+
+// group return group of name. It is created if not exist.
+func (a *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := a.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: map[string]string{}}
+		a.groups[name] = g
+		a.groupOrder = append(a.groupOrder, name)
+	}
+
+	return g
+}
+
+// addHost add host (with range) to group, and set variables of host.
+func (a *ansibleInventory) addHost(group, pattern string, vars map[string]string) error {
+	hosts, err := expandHostRange(pattern)
+	if err != nil {
+		return err
+	}
+
+	g := a.group(group)
+	for _, host := range hosts {
+		if _, ok := a.hostVars[host]; !ok {
+			a.hosts = append(a.hosts, host)
+			a.hostVars[host] = map[string]string{}
+		}
+
+		for k, v := range vars {
+			a.hostVars[host][k] = v
+		}
+
+		if indexOfString(g.hosts, host) < 0 {
+			g.hosts = append(g.hosts, host)
+		}
+	}
+
+	return nil
+}
+
+// addChild add child group to group.
+func (a *ansibleInventory) addChild(group, child string) {
+	g := a.group(group)
+	a.group(child)
+
+	if indexOfString(g.children, child) < 0 {
+		g.children = append(g.children, child)
+	}
+}
+
+// readAnsibleINI read Ansible ini inventory.
+func (inv *Inventory) readAnsibleINI(data []byte) (err error) {
+	a := newAnsibleInventory()
+
+	section, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		// section (`[group]`, `[group:vars]`, `[group:children]`)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind = line[1:len(line)-1], "hosts"
+			if i := strings.LastIndex(section, ":"); i >= 0 {
+				switch section[i+1:] {
+				case "vars", "children":
+					section, kind = section[:i], section[i+1:]
+				}
+			}
+			a.group(section)
+			continue
+		}
+
+		fields := splitAnsibleFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch kind {
+		case "hosts":
+			vars := map[string]string{}
+			for _, f := range fields[1:] {
+				kv := strings.SplitN(f, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("line %d: invalid host variable `%s`", n, f)
+				}
+				vars[kv[0]] = kv[1]
+			}
+
+			// host:port
+			host := fields[0]
+			if i := strings.LastIndex(host, ":"); i > 0 && strings.Count(host, ":") == 1 {
+				if _, err := strconv.Atoi(host[i+1:]); err == nil {
+					if _, ok := vars["ansible_port"]; !ok {
+						vars["ansible_port"] = host[i+1:]
+					}
+					host = host[:i]
+				}
+			}
+
+			if err = a.addHost(section, host, vars); err != nil {
+				return fmt.Errorf("line %d: %s", n, err)
+			}
+
+		case "vars":
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("line %d: invalid group variable `%s`", n, line)
+			}
+			a.group(section).vars[strings.TrimSpace(kv[0])] = unquoteAnsible(strings.TrimSpace(kv[1]))
+
+		case "children":
+			a.addChild(section, fields[0])
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	a.resolve(inv)
+	return
+}
+
+// readAnsibleYAML read Ansible yaml inventory.
+func (inv *Inventory) readAnsibleYAML(data []byte) (err error) {
+	var top map[string]*ansibleYAMLGroup
+	err = yaml.Unmarshal(data, &top)
+	if err != nil {
+		return
+	}
+
+	a := newAnsibleInventory()
+	for _, name := range sortedKeys(top) {
+		if err = a.readYAMLGroup(name, top[name]); err != nil {
+			return
+		}
+	}
+
+	a.resolve(inv)
+	return
+}
+
+// readYAMLGroup read group of Ansible yaml inventory, and its children.
+func (a *ansibleInventory) readYAMLGroup(name string, yg *ansibleYAMLGroup) (err error) {
+	g := a.group(name)
+	if yg == nil {
+		return
+	}
+
+	for k, v := range yg.Vars {
+		g.vars[k] = fmt.Sprint(v)
+	}
+
+	for _, host := range sortedKeys(yg.Hosts) {
+		vars := map[string]string{}
+		for k, v := range yg.Hosts[host] {
+			vars[k] = fmt.Sprint(v)
+		}
+
+		if err = a.addHost(name, host, vars); err != nil {
+			return
+		}
+	}
+
+	for _, child := range sortedKeys(yg.Children) {
+		a.addChild(name, child)
+		if err = a.readYAMLGroup(child, yg.Children[child]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// resolve apply variables to hosts, and add servers and groups to inv.
+func (a *ansibleInventory) resolve(inv *Inventory) {
+	// parent groups of group
+	parents := map[string][]string{}
+	for _, name := range a.groupOrder {
+		for _, child := range a.groups[name].children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+
+	// depth of group from top
+	depth := map[string]int{}
+	var getDepth func(name string, n int) int
+	getDepth = func(name string, n int) int {
+		if d, ok := depth[name]; ok {
+			return d
+		}
+
+		d := 0
+		if n < maxGroupDepth {
+			for _, p := range parents[name] {
+				if pd := getDepth(p, n+1) + 1; pd > d {
+					d = pd
+				}
+			}
+		}
+		depth[name] = d
+
+		return d
+	}
+
+	for _, host := range a.hosts {
+		// groups of host, and their ancestors
+		var groups []string
+		var add func(name string, n int)
+		add = func(name string, n int) {
+			if indexOfString(groups, name) >= 0 || n > maxGroupDepth {
+				return
+			}
+			groups = append(groups, name)
+			for _, p := range parents[name] {
+				add(p, n+1)
+			}
+		}
+		for _, name := range a.groupOrder {
+			if indexOfString(a.groups[name].hosts, host) >= 0 {
+				add(name, 0)
+			}
+		}
+		sort.SliceStable(groups, func(i, j int) bool {
+			return getDepth(groups[i], 0) < getDepth(groups[j], 0)
+		})
+
+		// variables
+		vars := map[string]string{}
+		if g, ok := a.groups["all"]; ok {
+			for k, v := range g.vars {
+				vars[k] = v
+			}
+		}
+		for _, name := range groups {
+			for k, v := range a.groups[name].vars {
+				vars[k] = v
+			}
+		}
+		for k, v := range a.hostVars[host] {
+			vars[k] = v
+		}
+
+		inv.addServer(host, ansibleServerConfig(vars), nil, nil)
+	}
+
+	// groups
+	for _, name := range a.groupOrder {
+		if name == "all" || name == "ungrouped" {
+			continue
+		}
+
+		g := a.groups[name]
+		inv.addGroupHosts(name, g.hosts...)
+		for _, child := range g.children {
+			if child != "all" && child != "ungrouped" {
+				inv.addGroupHosts(name, "group:"+child)
+			}
+		}
+	}
+}
+
+// ansibleServerConfig return server config of Ansible variables.
+func ansibleServerConfig(vars map[string]string) conf.ServerConfig {
+	get := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := vars[k]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	return conf.ServerConfig{
+		Addr: get("ansible_host", "ansible_ssh_host"),
+		Port: get("ansible_port", "ansible_ssh_port"),
+		User: get("ansible_user", "ansible_ssh_user"),
+		Pass: get("ansible_password", "ansible_ssh_pass"),
+		Key:  get("ansible_ssh_private_key_file", "ansible_private_key_file"),
+	}
+}
+
+// expandHostRange expand host range of pattern. ex.) `web[01:03]` => web01, web02, web03
+func expandHostRange(pattern string) (hosts []string, err error) {
+	m := hostRangeRegexp.FindStringSubmatchIndex(pattern)
+	if m == nil {
+		return []string{pattern}, nil
+	}
+
+	prefix, suffix := pattern[:m[0]], pattern[m[1]:]
+	start, end := pattern[m[2]:m[3]], pattern[m[4]:m[5]]
+
+	step := 1
+	if m[6] >= 0 {
+		step, _ = strconv.Atoi(pattern[m[6]:m[7]])
+		if step < 1 {
+			return nil, fmt.Errorf("invalid host range `%s`", pattern)
+		}
+	}
+
+	var items []string
+	s, errS := strconv.Atoi(start)
+	e, errE := strconv.Atoi(end)
+	switch {
+	case errS == nil && errE == nil:
+		// numeric range. zero padding of start is kept.
+		format := "%d"
+		if len(start) > 1 && strings.HasPrefix(start, "0") {
+			format = "%0" + strconv.Itoa(len(start)) + "d"
+		}
+		for i := s; i <= e; i += step {
+			items = append(items, fmt.Sprintf(format, i))
+		}
+	case errS != nil && errE != nil:
+		// alphabetic range
+		for c := start[0]; c <= end[0]; c += byte(step) {
+			items = append(items, string(c))
+		}
+	default:
+		return nil, fmt.Errorf("invalid host range `%s`", pattern)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("invalid host range `%s`", pattern)
+	}
+
+	// expand the rest of range
+	for _, item := range items {
+		rest, err := expandHostRange(item + suffix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range rest {
+			hosts = append(hosts, prefix+r)
+		}
+	}
+
+	return
+}
+
+// splitAnsibleFields split line of ini inventory by space. Quoted space is not split, and quotes are removed.
+func splitAnsibleFields(line string) (fields []string) {
+	var buf strings.Builder
+	var quote rune
+	inField := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			buf.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == '#' && !inField:
+			// comment
+			return
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, buf.String())
+				buf.Reset()
+				inField = false
+			}
+		default:
+			buf.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if inField {
+		fields = append(fields, buf.String())
+	}
+
+	return
+}
+
+// unquoteAnsible remove quotes of value.
+func unquoteAnsible(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// sortedKeys return sorted keys of map.
+func sortedKeys[T any](m map[string]T) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/blacknon/lssh/conf"
+)
+
+// wantInventory is expected servers and groups of inventory. Note of server is `from:<path>` if it is empty.
+type wantInventory struct {
+	names  []string
+	server map[string]conf.ServerConfig
+	tags   map[string][]string
+	group  map[string]GroupConfig
+}
+
+// checkInventory compare inv with want.
+func checkInventory(t *testing.T, inv *Inventory, want wantInventory) {
+	t.Helper()
+
+	if !reflect.DeepEqual(inv.Names, want.names) {
+		t.Errorf("Names = %q, want %q", inv.Names, want.names)
+	}
+
+	for name, sc := range want.server {
+		if sc.Note == "" {
+			sc.Note = "from:" + inv.Path
+		}
+
+		if got := inv.Server[name]; !reflect.DeepEqual(got, sc) {
+			t.Errorf("Server[%s] = %+v, want %+v", name, got, sc)
+		}
+	}
+	if len(inv.Server) != len(want.server) {
+		t.Errorf("len(Server) = %d, want %d", len(inv.Server), len(want.server))
+	}
+
+	if want.tags == nil {
+		want.tags = map[string][]string{}
+	}
+	if !reflect.DeepEqual(inv.Tags, want.tags) {
+		t.Errorf("Tags = %q, want %q", inv.Tags, want.tags)
+	}
+
+	if !reflect.DeepEqual(inv.Group, want.group) {
+		t.Errorf("Group = %+v, want %+v", inv.Group, want.group)
+	}
+}
+
+// ansibleServers is expected servers of testdata/hosts.ini and testdata/hosts.yml.
+var ansibleServers = map[string]conf.ServerConfig{
+	"bastion": {Addr: "10.0.0.1", User: "admin"},
+	"web01":   {Addr: "web01", Port: "8022", User: "deploy", Key: "~/.ssh/web key"},
+	"web02":   {Addr: "web02", Port: "8022", User: "deploy", Key: "~/.ssh/web key"},
+	"web03":   {Addr: "web03", Port: "8022", User: "deploy", Key: "~/.ssh/web key"},
+	"web10":   {Addr: "web10", Port: "2222", User: "ops", Key: "~/.ssh/web key"},
+	"db-a":    {Addr: "db.example.com", User: "ops", Pass: "secret pass"},
+	"db-b":    {Addr: "db.example.com", User: "ops", Pass: "secret pass"},
+}
+
+// TestReadInventory check each inventory type with testdata.
+func TestReadInventory(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		want wantInventory
+	}{
+		{
+			name: "ansible ini",
+			spec: "testdata/hosts.ini",
+			want: wantInventory{
+				names:  []string{"bastion", "web01", "web02", "web03", "web10", "db-a", "db-b"},
+				server: ansibleServers,
+				group: map[string]GroupConfig{
+					"web":  {Hosts: []string{"web01", "web02", "web03", "web10"}},
+					"db":   {Hosts: []string{"db-a", "db-b"}},
+					"app":  {Hosts: []string{"group:web", "group:db"}},
+					"prod": {Hosts: []string{"group:app"}},
+				},
+			},
+		},
+		{
+			name: "ansible yaml",
+			spec: "ansible:testdata/hosts.yml",
+			want: wantInventory{
+				names:  []string{"bastion", "db-a", "db-b", "web10", "web01", "web02", "web03"},
+				server: ansibleServers,
+				group: map[string]GroupConfig{
+					"web":  {Hosts: []string{"web10", "web01", "web02", "web03"}},
+					"db":   {Hosts: []string{"db-a", "db-b"}},
+					"app":  {Hosts: []string{"group:db", "group:web"}},
+					"prod": {Hosts: []string{"group:app"}},
+				},
+			},
+		},
+		{
+			name: "csv",
+			spec: "testdata/servers.csv",
+			want: wantInventory{
+				names: []string{"web01", "db01", "app01"},
+				server: map[string]conf.ServerConfig{
+					"web01": {Addr: "192.168.0.1", Port: "22", User: "deploy", Key: "~/.ssh/id_rsa", Note: "web server"},
+					"db01":  {Addr: "192.168.0.2", Pass: "secret"},
+					"app01": {Addr: "app01", Port: "2222", User: "app"},
+				},
+				tags: map[string][]string{
+					"web01": {"role=web", "env=prod"},
+					"db01":  {"role=db"},
+				},
+				group: map[string]GroupConfig{
+					"web":  {Hosts: []string{"web01"}},
+					"prod": {Hosts: []string{"web01"}},
+					"db":   {Hosts: []string{"db01"}},
+				},
+			},
+		},
+		{
+			name: "json",
+			spec: "testdata/servers.json",
+			want: wantInventory{
+				names: []string{"web01", "db01", "app01"},
+				server: map[string]conf.ServerConfig{
+					"web01": {Addr: "192.168.0.1", Port: "22", User: "deploy", Key: "~/.ssh/id_rsa", Note: "web server"},
+					"db01":  {Addr: "192.168.0.2", Pass: "secret"},
+					"app01": {Addr: "app01", Port: "2222", User: "app"},
+				},
+				tags: map[string][]string{
+					"web01": {"role=web", "env=prod"},
+					"db01":  {"role=db"},
+				},
+				group: map[string]GroupConfig{
+					"web":  {Hosts: []string{"web01"}},
+					"prod": {Hosts: []string{"web01"}},
+					"db":   {Hosts: []string{"db01"}},
+				},
+			},
+		},
+		{
+			name: "ssh config",
+			spec: "testdata/ssh_config",
+			want: wantInventory{
+				names: []string{"web01", "web02", "bastion"},
+				server: map[string]conf.ServerConfig{
+					"web01": {
+						Addr:         "web01.example.com",
+						User:         "deploy",
+						Key:          filepath.Join(u.HomeDir, ".ssh/web01.example.com_deploy"),
+						ProxyCommand: "ssh -W web01.example.com:22 bastion",
+					},
+					"web02": {
+						Addr:         "web02",
+						User:         "deploy",
+						Key:          filepath.Join(u.HomeDir, ".ssh/web02_deploy"),
+						ProxyCommand: "ssh -W web02:22 bastion",
+					},
+					"bastion": {
+						Addr:         "10.0.0.1",
+						Port:         "2222",
+						User:         "default",
+						Key:          u.HomeDir + "/.ssh/id_bastion",
+						ProxyCommand: "nc 10.0.0.1 2222 %x %z",
+					},
+				},
+				group: map[string]GroupConfig{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := ReadInventory(tt.spec)
+			if err != nil {
+				t.Fatalf("ReadInventory(%q) err = %v", tt.spec, err)
+			}
+
+			checkInventory(t, inv, tt.want)
+		})
+	}
+}
+
+// TestParseInventorySpec check type detection of inventory spec.
+func TestParseInventorySpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		wantType string
+		wantPath string
+	}{
+		{spec: "hosts", wantType: "ini", wantPath: "hosts"},
+		{spec: "hosts.yml", wantType: "yaml", wantPath: "hosts.yml"},
+		{spec: "inventory/hosts.YAML", wantType: "yaml", wantPath: "inventory/hosts.YAML"},
+		{spec: "servers.csv", wantType: "csv", wantPath: "servers.csv"},
+		{spec: "servers.json", wantType: "json", wantPath: "servers.json"},
+		{spec: "~/.ssh/config", wantType: "ssh", wantPath: "~/.ssh/config"},
+		{spec: "/etc/ssh/ssh_config", wantType: "ssh", wantPath: "/etc/ssh/ssh_config"},
+		{spec: "ssh:hosts.txt", wantType: "ssh", wantPath: "hosts.txt"},
+		{spec: "ansible:hosts.yml", wantType: "ansible", wantPath: "hosts.yml"},
+		{spec: "c:/inventory", wantType: "ini", wantPath: "c:/inventory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			gotType, gotPath := parseInventorySpec(tt.spec)
+			if gotType != tt.wantType || gotPath != tt.wantPath {
+				t.Errorf("parseInventorySpec(%q) = (%q, %q), want (%q, %q)", tt.spec, gotType, gotPath, tt.wantType, tt.wantPath)
+			}
+		})
+	}
+}
+
+// TestExpandSSHConfigTokens check token expansion of ssh_config.
+func TestExpandSSHConfigTokens(t *testing.T) {
+	tokens := map[byte]string{'d': "/home/user", 'h': "web01.example.com", 'n': "web01", 'p': "22", 'r': "deploy", 'u': "user"}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: ""},
+		{value: "ssh -W %h:%p bastion", want: "ssh -W web01.example.com:22 bastion"},
+		{value: "%d/.ssh/id_%n", want: "/home/user/.ssh/id_web01"},
+		{value: "%r@%h as %u", want: "deploy@web01.example.com as user"},
+		{value: "100%%", want: "100%"},
+		{value: "%%h", want: "%h"},
+		{value: "%z unknown", want: "%z unknown"},
+		{value: "trailing %", want: "trailing %"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := expandSSHConfigTokens(tt.value, tokens); got != tt.want {
+				t.Errorf("expandSSHConfigTokens(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpandHomeDir check `~` expansion.
+func TestExpandHomeDir(t *testing.T) {
+	tests := []struct {
+		path string
+		home string
+		want string
+	}{
+		{path: "~", home: "/home/user", want: "/home/user"},
+		{path: "~/.ssh/id_rsa", home: "/home/user", want: "/home/user/.ssh/id_rsa"},
+		{path: "~other/.ssh/id_rsa", home: "/home/user", want: "~other/.ssh/id_rsa"},
+		{path: "/etc/ssh/key", home: "/home/user", want: "/etc/ssh/key"},
+		{path: "~/.ssh/id_rsa", home: "", want: "~/.ssh/id_rsa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := expandHomeDir(tt.path, tt.home); got != tt.want {
+				t.Errorf("expandHomeDir(%q, %q) = %q, want %q", tt.path, tt.home, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpandHostRange check host range of Ansible inventory.
+func TestExpandHostRange(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{pattern: "web01", want: []string{"web01"}},
+		{pattern: "web[1:3]", want: []string{"web1", "web2", "web3"}},
+		{pattern: "web[08:10]", want: []string{"web08", "web09", "web10"}},
+		{pattern: "web[1:5:2].example.com", want: []string{"web1.example.com", "web3.example.com", "web5.example.com"}},
+		{pattern: "db-[a:c]", want: []string{"db-a", "db-b", "db-c"}},
+		{pattern: "[a:b][1:2]", want: []string{"a1", "a2", "b1", "b2"}},
+		{pattern: "web[3:1]", wantErr: true},
+		{pattern: "web[1:c]", wantErr: true},
+		{pattern: "web[1:3:0]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := expandHostRange(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandHostRange(%q) err = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandHostRange(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSplitAnsibleFields check splitting of ini inventory line.
+func TestSplitAnsibleFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "web01", want: []string{"web01"}},
+		{line: "web01  ansible_user=deploy\tansible_port=22", want: []string{"web01", "ansible_user=deploy", "ansible_port=22"}},
+		{line: `web01 ansible_ssh_pass="secret pass"`, want: []string{"web01", "ansible_ssh_pass=secret pass"}},
+		{line: `web01 note='a "b" #c'`, want: []string{"web01", `note=a "b" #c`}},
+		{line: "web01 # comment", want: []string{"web01"}},
+		{line: "web01 key=a#b", want: []string{"web01", "key=a#b"}},
+		{line: `web01 empty=""`, want: []string{"web01", "empty="}},
+		{line: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := splitAnsibleFields(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitAnsibleFields(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnquoteAnsible check removing quotes of value.
+func TestUnquoteAnsible(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: `"a b"`, want: "a b"},
+		{value: `'a b'`, want: "a b"},
+		{value: `"a b'`, want: `"a b'`},
+		{value: `"`, want: `"`},
+		{value: `a"b"`, want: `a"b"`},
+		{value: "plain", want: "plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := unquoteAnsible(tt.value); got != tt.want {
+				t.Errorf("unquoteAnsible(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAddInventory check that inventory is merged to lssh config, without overwriting servers of lssh config.
+func TestAddInventory(t *testing.T) {
+	inv := newInventory("servers.csv")
+	inv.addServer("web01", conf.ServerConfig{User: "inv"}, []string{"web"}, []string{"role=web"})
+	inv.addServer("web02", conf.ServerConfig{Port: "2222"}, []string{"web"}, []string{"role=web"})
+
+	data := &conf.Config{
+		Common: conf.ServerConfig{User: "common", Port: "22", Key: "~/.ssh/id_rsa"},
+		Server: map[string]conf.ServerConfig{
+			"web01": {Addr: "192.168.0.1", User: "lssh"},
+		},
+	}
+	c := &Config{
+		Group: map[string]GroupConfig{"web": {Hosts: []string{"web00"}}},
+	}
+
+	c.AddInventory(data, inv)
+
+	// server of lssh config is not overwritten, and tags of inventory are not added to it
+	if got := data.Server["web01"]; got.Addr != "192.168.0.1" || got.User != "lssh" {
+		t.Errorf("Server[web01] = %+v, want lssh config", got)
+	}
+	if _, ok := c.Server["web01"]; ok {
+		t.Errorf("Tags of web01 is added: %q", c.Server["web01"].Tags)
+	}
+
+	// common is applied to server of inventory
+	want := conf.ServerConfig{Addr: "web02", Port: "2222", User: "common", Key: "~/.ssh/id_rsa", Note: "from:servers.csv"}
+	if got := data.Server["web02"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Server[web02] = %+v, want %+v", got, want)
+	}
+	if got := c.Server["web02"].Tags; !reflect.DeepEqual(got, []string{"role=web"}) {
+		t.Errorf("Tags of web02 = %q, want [role=web]", got)
+	}
+
+	// groups are merged
+	if got := c.Group["web"].Hosts; !reflect.DeepEqual(got, []string{"web00", "web01", "web02"}) {
+		t.Errorf("Group[web] = %q, want [web00 web01 web02]", got)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package config
+
+// ShellConfig store lsshell settings in `[shell]`, in addition to lssh conf.ShellConfig.
+type ShellConfig struct {
+	// HistControl is colon separated list, like bash HISTCONTROL.
+	//   - ignoredups  ... do not save the same command as the previous one
+	//   - ignorespace ... do not save the command starting with space
+	//   - ignoreboth  ... ignoredups and ignorespace
+	HistControl string `toml:"histcontrol"`
+
+	// HistIgnore is list of regex. matched command is not saved to history.
+	// ex.) ["password=", "^%history"]
+	HistIgnore []string `toml:"histignore"`
+
+	// HistSize is max number of lines in history file. 0 is unlimited.
+	HistSize int `toml:"histsize"`
+
+	// HistRotate is number of rotated history files (`histfile.1`, `histfile.2`...).
+	// Lines over HistSize are moved to rotated file. 0 is discard.
+	HistRotate int `toml:"histrotate"`
+
+	// PS2 is continuation prompt of multi-line input (here-document, unclosed quote, etc...).
+	// Variables same as prompt (${COUNT}, ${HOSTNAME}, ${USER}, ${PWD}) can be used.
+	PS2 string `toml:"ps2"`
+
+	// RedirectHeader is whether to add OPROMPT to each line written by local redirect (`%>`, `%>>`).
+	RedirectHeader bool `toml:"redirectheader"`
+
+	// StatusPanel is whether to show host status panel while command is running at startup.
+	// It can be toggled with Ctrl-T.
+	StatusPanel bool `toml:"statuspanel"`
+
+	// Timeout is default timeout of remote command (ex. "30s", "5m"). Empty is no timeout.
+	// It is overwritten by `%timeout` prefix.
+	Timeout string `toml:"timeout"`
+
+	// TimeoutDetach is whether to detach hosts not finished in timeout to background, instead of kill.
+	TimeoutDetach bool `toml:"timeoutdetach"`
+
+	// RollingPause is default pause between batches of `%rolling` (ex. "10s"). Empty is no pause.
+	// It is overwritten by `%rolling -p`.
+	RollingPause string `toml:"rollingpause"`
+
+	// RollingCheck is default health check command run on hosts of batch, between batches of `%rolling`.
+	// If it fails on any host, the following batches are not run. It is overwritten by `%rolling -c`.
+	RollingCheck string `toml:"rollingcheck"`
+}
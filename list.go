@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/blacknon/lssh/conf"
+
+	"github.com/blacknon/lsshell/config"
+)
+
+// listServer is server in `--list` output of json and tsv format.
+type listServer struct {
+	Name   string   `json:"name"`
+	Addr   string   `json:"addr"`
+	Port   string   `json:"port"`
+	User   string   `json:"user"`
+	Note   string   `json:"note"`
+	Tags   []string `json:"tags"`
+	Groups []string `json:"groups"`
+}
+
+// printServerList print servers of names in format (text, json, tsv).
+func printServerList(w io.Writer, format string, names []string, data conf.Config, extConfig config.Config) error {
+	var servers []listServer
+	for _, name := range names {
+		sc := data.Server[name]
+
+		s := listServer{
+			Name:   name,
+			Addr:   sc.Addr,
+			Port:   sc.Port,
+			User:   sc.User,
+			Note:   sc.Note,
+			Tags:   extConfig.Server[name].Tags,
+			Groups: extConfig.GroupsOf(names, name),
+		}
+
+		// empty array instead of null in json
+		if s.Tags == nil {
+			s.Tags = []string{}
+		}
+		if s.Groups == nil {
+			s.Groups = []string{}
+		}
+
+		servers = append(servers, s)
+	}
+
+	switch format {
+	case "", "text":
+		fmt.Fprintf(w, "lssh Server List:\n")
+		for _, s := range servers {
+			fmt.Fprintf(w, "  %s\n", s.Name)
+		}
+
+	case "json":
+		if servers == nil {
+			servers = []listServer{}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(servers)
+
+	case "tsv":
+		// tab and newline in value are replaced to space
+		r := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ")
+
+		fmt.Fprintln(w, "NAME\tADDR\tPORT\tUSER\tNOTE\tTAGS\tGROUPS")
+		for _, s := range servers {
+			fields := []string{s.Name, s.Addr, s.Port, s.User, s.Note, strings.Join(s.Tags, ","), strings.Join(s.Groups, ",")}
+			for i := range fields {
+				fields[i] = r.Replace(fields[i])
+			}
+			fmt.Fprintln(w, strings.Join(fields, "\t"))
+		}
+
+	default:
+		return fmt.Errorf("invalid format `%s`. format is text, json or tsv", format)
+	}
+
+	return nil
+}
@@ -11,13 +11,13 @@ import (
 	"runtime"
 	"sort"
 
-	"github.com/blacknon/lssh/check"
 	"github.com/blacknon/lssh/common"
 	"github.com/blacknon/lssh/conf"
 	"github.com/blacknon/lssh/list"
 	sshcmd "github.com/blacknon/lssh/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 
+	"github.com/blacknon/lsshell/config"
 	"github.com/blacknon/lsshell/shell"
 
 	"github.com/urfave/cli"
@@ -69,16 +69,22 @@ USAGE:
 	// Set options
 	app.Flags = []cli.Flag{
 		// common option
-		cli.StringSliceFlag{Name: "host,H", Usage: "connect `servername`."},
+		cli.StringSliceFlag{Name: "host,H", Usage: "connect `servername`. glob (`web*`), regexp (`/^web[0-9]+$/`), `tag:TAG` and `group:NAME` can be used."},
+		cli.StringSliceFlag{Name: "group,g", Usage: "connect servers in `group` of config."},
+		cli.StringSliceFlag{Name: "tag", Usage: "connect servers with `tag` (ex. role=web). If specified multiple times, servers with all tags are selected."},
 		cli.StringFlag{Name: "file,F", Value: defConf, Usage: "config `filepath`."},
+		cli.StringSliceFlag{Name: "inventory", Usage: "load servers from inventory `[type:]path`. type is ansible, ini, yaml, ssh, csv or json (detected by file name if omitted)."},
 
 		// port forward option
-		cli.StringSliceFlag{Name: "R", Usage: "Remote port forward mode.Specify a `[bind_address:]port:remote_address:port`. If only one port is specified, it will operate as Reverse Dynamic Forward. Only single connection works."},
-		cli.StringFlag{Name: "r", Usage: "HTTP Reverse Dynamic port forward mode. Specify a `port`. Only single connection works."},
+		cli.StringSliceFlag{Name: "L", Usage: "Local port forward mode.Specify a `[bind_address:]port:remote_address:port`. If multiple hosts are connected, the port of N-th host is port + N."},
+		cli.StringSliceFlag{Name: "R", Usage: "Remote port forward mode.Specify a `[bind_address:]port:remote_address:port`. If only one port is specified, it will operate as Reverse Dynamic Forward. Each host listen on the same port."},
+		cli.StringFlag{Name: "D", Usage: "Dynamic port forward mode(Socks5). Specify a `port`. If multiple hosts are connected, the port of N-th host is port + N."},
+		cli.StringFlag{Name: "r", Usage: "HTTP Reverse Dynamic port forward mode. Specify a `port`. Each host listen on the same port."},
 
 		// Other bool
 		cli.BoolFlag{Name: "term,t", Usage: "run specified command at terminal."},
-		cli.BoolFlag{Name: "list,l", Usage: "print server list from config."},
+		cli.BoolFlag{Name: "list,l", Usage: "print server list from config. It can be filtered by -H, -g and --tag."},
+		cli.StringFlag{Name: "format", Value: "text", Usage: "output `format` of server list (text, json, tsv). json and tsv include addr, port, user, note, tags and groups."},
 		cli.BoolFlag{Name: "help,h", Usage: "print this help"},
 	}
 	app.EnableBashCompletion = true
@@ -93,10 +99,23 @@ USAGE:
 		}
 
 		hosts := c.StringSlice("host")
+		groups := c.StringSlice("group")
+		tags := c.StringSlice("tag")
 		confpath := c.String("file")
 
 		// Get config data
 		data := conf.Read(confpath)
+		extConfig := config.Read(confpath)
+
+		// Load inventory files
+		for _, spec := range c.StringSlice("inventory") {
+			inv, err := config.ReadInventory(spec)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "err: Read inventory error: %s\n", err)
+				os.Exit(1)
+			}
+			extConfig.AddInventory(&data, inv)
+		}
 
 		// Set `exec command` or `shell` flag
 		isMulti := true
@@ -106,21 +125,31 @@ USAGE:
 		sort.Strings(names)
 
 		// Check list flag
-		if c.Bool("list") {
-			fmt.Fprintf(os.Stdout, "lssh Server List:\n")
-			for v := range names {
-				fmt.Fprintf(os.Stdout, "  %s\n", names[v])
+		if c.Bool("list") || c.IsSet("format") {
+			list := names
+			if len(hosts) > 0 || len(groups) > 0 || len(tags) > 0 {
+				var err error
+				list, err = extConfig.SelectHosts(names, hosts, groups, tags)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Input Server not found from list: %s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := printServerList(os.Stdout, c.String("format"), list, data, extConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
 			}
 			os.Exit(0)
 		}
 
 		selected := []string{}
-		if len(hosts) > 0 {
-			if !check.ExistServer(hosts, names) {
-				fmt.Fprintln(os.Stderr, "Input Server not found from list.")
+		if len(hosts) > 0 || len(groups) > 0 || len(tags) > 0 {
+			var err error
+			selected, err = extConfig.SelectHosts(names, hosts, groups, tags)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Input Server not found from list: %s\n", err)
 				os.Exit(1)
-			} else {
-				selected = hosts
 			}
 		} else {
 			// View List And Get Select Line
@@ -146,9 +175,22 @@ USAGE:
 		r.IsTerm = c.Bool("term")
 
 		// Set port forwards
-		var err error
 		var forwards []*conf.PortForward
 
+		// Set local port forwarding
+		for _, forwardargs := range c.StringSlice("L") {
+			f := new(conf.PortForward)
+			f.Mode = "L"
+
+			var err error
+			f.Local, f.Remote, err = common.ParseForwardPort(forwardargs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: -L %s: %s\n", forwardargs, err)
+				os.Exit(1)
+			}
+			forwards = append(forwards, f)
+		}
+
 		// Set remote port forwarding
 		for _, forwardargs := range c.StringSlice("R") {
 			f := new(conf.PortForward)
@@ -157,20 +199,36 @@ USAGE:
 			// If only numbers are passed as arguments, treat as Reverse Dynamic Port Forward
 			if regexp.MustCompile(`^[0-9]+$`).Match([]byte(forwardargs)) {
 				r.ReverseDynamicPortForward = forwardargs
-			} else {
-				f.Local, f.Remote, err = common.ParseForwardPort(forwardargs)
-				forwards = append(forwards, f)
+				continue
 			}
-		}
 
-		// if err
-		if err != nil {
-			fmt.Printf("Error: %s \n", err)
+			// listen address is remote side, and connect address is local side.
+			var err error
+			f.Remote, f.Local, err = common.ParseForwardPort(forwardargs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: -R %s: %s\n", forwardargs, err)
+				os.Exit(1)
+			}
+			forwards = append(forwards, f)
 		}
 
 		// Local/Remote port forwarding port
 		r.PortForward = forwards
 
+		// Set dynamic port forwarding (socks5)
+		r.DynamicPortForward = c.String("D")
+
+		// Set http reverse dynamic port forwarding
+		r.HTTPReverseDynamicPortForward = c.String("r")
+
+		// check port of dynamic port forwarding
+		for _, port := range []string{r.DynamicPortForward, r.ReverseDynamicPortForward, r.HTTPReverseDynamicPortForward} {
+			if port != "" && !regexp.MustCompile(`^[0-9]+$`).MatchString(port) {
+				fmt.Fprintf(os.Stderr, "Error: invalid port `%s`\n", port)
+				os.Exit(1)
+			}
+		}
+
 		// Get stdin data(pipe)
 		// TODO(blacknon): os.StdinをReadAllで全部読み込んでから処理する方式だと、ストリームで処理出来ない
 		//                 (全部読み込み終わるまで待ってしまう)ので、Reader/Writerによるストリーム処理に切り替える(v0.7.0)
@@ -185,7 +243,7 @@ USAGE:
 		// create AuthMap
 		r.CreateAuthMethodMap()
 
-		err = shell.Shell(r)
+		err := shell.Shell(r, extConfig)
 		return err
 	}
 	return app
@@ -0,0 +1,454 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	termbox "github.com/nsf/termbox-go"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Interactive mode.
+//   - %attach [host] ... open interactive shell of one host. press Ctrl-] to return to lsshell.
+//   - %sync          ... open interactive shell of all hosts in split panes, and send keystrokes to all hosts.
+//                        press Ctrl-] to return to lsshell.
+
+// detachKey is hotkey to return to lsshell prompt (Ctrl-]).
+const detachKey = 0x1d
+
+// buildin_attach open interactive shell of host.
+func (s *shell) buildin_attach(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		if stdout != os.Stdout {
+			return fmt.Errorf("%%attach: can not be used in pipeline")
+		}
+
+		// get host
+		var c *sConnect
+		switch {
+		case len(args) > 0:
+			connects, err := s.getTargetConnects([]string{args[0]})
+			if err != nil {
+				return fmt.Errorf("%%attach: %s", err)
+			}
+			c = connects[0]
+		case len(s.getActiveConnects()) == 1:
+			c = s.getActiveConnects()[0]
+		default:
+			return fmt.Errorf("%%attach: specify host. usage: %%attach <host>")
+		}
+
+		fmt.Printf("attach to %s. (press Ctrl-] to return to lsshell)\n", c.Name)
+
+		return s.attachShell(c)
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// attachShell run interactive shell of c, until the shell exit or detachKey is pressed.
+func (s *shell) attachShell(c *sConnect) (err error) {
+	session, err := c.CreateSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	// terminal input
+	tty, err := openTTYReader()
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+
+	// Input terminal Make raw
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return
+	}
+	defer term.Restore(fd, state)
+
+	// Request tty
+	err = requestTty(session)
+	if err != nil {
+		return
+	}
+
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	// window size change is forwarded to the session
+	rs := s.foreground.add(c.Name, session, stdin, true)
+	defer s.foreground.finish(rs)
+
+	err = startShell(session, c)
+	if err != nil {
+		return
+	}
+
+	// send input, until detachKey
+	go copyInputUntilDetach(stdin, tty, session)
+
+	session.Wait()
+
+	return
+}
+
+// startShell start login shell on session, at remote working directory of c.
+func startShell(session *ssh.Session, c *sConnect) error {
+	if c.Cwd == "" {
+		return session.Shell()
+	}
+
+	return session.Start("cd -- " + shellQuote(c.Cwd) + " && exec \"${SHELL:-sh}\" -l")
+}
+
+// copyInputUntilDetach copy terminal input to w. If detachKey is pressed, session is closed.
+func copyInputUntilDetach(w io.WriteCloser, r io.Reader, session *ssh.Session) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			if buf[i] == detachKey {
+				w.Write(buf[:i])
+				session.Close()
+				return
+			}
+		}
+
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// syncPane is a pane of host in `%sync`.
+type syncPane struct {
+	con     *sConnect
+	session *ssh.Session
+	stdin   io.WriteCloser
+	screen  *vtScreen
+	exited  bool
+
+	// pane position
+	x, y, width, height int
+}
+
+// buildin_sync open interactive shell of all hosts in split panes, and broadcast keystrokes.
+func (s *shell) buildin_sync(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		if stdout != os.Stdout {
+			return fmt.Errorf("%%sync: can not be used in pipeline")
+		}
+
+		return s.syncShell()
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// syncShell run split pane TUI of all hosts, until all shell exit or detachKey is pressed.
+func (s *shell) syncShell() (err error) {
+	err = termbox.Init()
+	if err != nil {
+		return
+	}
+	defer termbox.Close()
+	termbox.SetInputMode(termbox.InputAlt)
+
+	width, height := termbox.Size()
+
+	// create panes
+	var panes []*syncPane
+	for _, c := range s.getActiveConnects() {
+		panes = append(panes, &syncPane{con: c})
+	}
+	layoutSyncPanes(panes, width, height)
+
+	m := new(sync.Mutex)
+	exit := make(chan bool, len(panes))
+	for _, p := range panes {
+		p.screen = newVTScreen(p.height, p.width)
+
+		err = p.start()
+		if err != nil {
+			p.exited = true
+			fmt.Fprintf(p.screen, "Error: %s\r\n", err)
+			exit <- true
+			continue
+		}
+
+		go func(p *syncPane) {
+			p.session.Wait()
+
+			m.Lock()
+			p.exited = true
+			m.Unlock()
+
+			exit <- true
+		}(p)
+	}
+	defer func() {
+		for _, p := range panes {
+			if p.session != nil {
+				p.session.Close()
+			}
+		}
+	}()
+
+	// keyboard events
+	events := make(chan termbox.Event)
+	done := make(chan bool)
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventInterrupt {
+				return
+			}
+
+			select {
+			case events <- ev:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer termbox.Interrupt()
+	defer close(done)
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	exited := 0
+	for {
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case termbox.EventKey:
+				if ev.Key == termbox.KeyCtrlRsqBracket {
+					return
+				}
+
+				data := termboxKeyToBytes(ev)
+				for _, p := range panes {
+					if p.stdin != nil {
+						p.stdin.Write(data)
+					}
+				}
+
+			case termbox.EventResize:
+				layoutSyncPanes(panes, ev.Width, ev.Height)
+				for _, p := range panes {
+					p.screen.Resize(p.height, p.width)
+					if p.session != nil {
+						p.session.WindowChange(p.height, p.width)
+					}
+				}
+			}
+
+		case <-exit:
+			exited++
+			if exited >= len(panes) {
+				return
+			}
+
+		case <-ticker.C:
+			m.Lock()
+			drawSyncPanes(panes)
+			m.Unlock()
+		}
+	}
+}
+
+// start create session with pty of pane size, and start shell.
+func (p *syncPane) start() (err error) {
+	p.session, err = p.con.CreateSession()
+	if err != nil {
+		return
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	err = p.session.RequestPty("vt100", p.height, p.width, modes)
+	if err != nil {
+		return
+	}
+
+	p.session.Stdout = p.screen
+	p.session.Stderr = p.screen
+	p.stdin, err = p.session.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	return startShell(p.session, p.con)
+}
+
+// layoutSyncPanes set position of panes in grid. Each pane has a title line, and panes are separated by `|`.
+func layoutSyncPanes(panes []*syncPane, width, height int) {
+	if len(panes) == 0 {
+		return
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(panes)))))
+	rows := (len(panes) + cols - 1) / cols
+
+	pw := width / cols
+	ph := height / rows
+
+	for i, p := range panes {
+		p.x = (i % cols) * pw
+		p.y = (i / cols) * ph
+		p.width = pw - 1
+		p.height = ph - 1
+
+		if p.width < 1 {
+			p.width = 1
+		}
+		if p.height < 1 {
+			p.height = 1
+		}
+	}
+}
+
+// drawSyncPanes draw panes to terminal.
+func drawSyncPanes(panes []*syncPane) {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+
+	for _, p := range panes {
+		// title
+		title := " " + p.con.Name + " "
+		if p.exited {
+			title = title + "(exited) "
+		}
+		for i := 0; i < p.width+1; i++ {
+			termbox.SetCell(p.x+i, p.y, ' ', termbox.ColorBlack, termbox.ColorCyan)
+		}
+		for i, r := range []rune(title) {
+			if i > p.width {
+				break
+			}
+			termbox.SetCell(p.x+i, p.y, r, termbox.ColorBlack, termbox.ColorCyan)
+		}
+
+		// screen
+		lines, cx, cy := p.screen.Lines()
+		for y, line := range lines {
+			for x, r := range line {
+				fg := termbox.ColorDefault
+				bg := termbox.ColorDefault
+				if x == cx && y == cy && !p.exited {
+					fg, bg = termbox.ColorBlack, termbox.ColorWhite
+				}
+				termbox.SetCell(p.x+x, p.y+1+y, r, fg, bg)
+			}
+
+			// separator
+			termbox.SetCell(p.x+p.width, p.y+1+y, '|', termbox.ColorDefault, termbox.ColorDefault)
+		}
+	}
+
+	termbox.Flush()
+}
+
+// termboxKeyToBytes convert termbox key event to terminal input bytes.
+func termboxKeyToBytes(ev termbox.Event) []byte {
+	if ev.Ch != 0 {
+		buf := make([]byte, utf8.UTFMax)
+		n := utf8.EncodeRune(buf, ev.Ch)
+		if ev.Mod&termbox.ModAlt != 0 {
+			return append([]byte{0x1b}, buf[:n]...)
+		}
+		return buf[:n]
+	}
+
+	switch ev.Key {
+	case termbox.KeyArrowUp:
+		return []byte("\x1b[A")
+	case termbox.KeyArrowDown:
+		return []byte("\x1b[B")
+	case termbox.KeyArrowRight:
+		return []byte("\x1b[C")
+	case termbox.KeyArrowLeft:
+		return []byte("\x1b[D")
+	case termbox.KeyHome:
+		return []byte("\x1b[H")
+	case termbox.KeyEnd:
+		return []byte("\x1b[F")
+	case termbox.KeyInsert:
+		return []byte("\x1b[2~")
+	case termbox.KeyDelete:
+		return []byte("\x1b[3~")
+	case termbox.KeyPgup:
+		return []byte("\x1b[5~")
+	case termbox.KeyPgdn:
+		return []byte("\x1b[6~")
+	case termbox.KeyF1:
+		return []byte("\x1bOP")
+	case termbox.KeyF2:
+		return []byte("\x1bOQ")
+	case termbox.KeyF3:
+		return []byte("\x1bOR")
+	case termbox.KeyF4:
+		return []byte("\x1bOS")
+	}
+
+	// control keys (KeyCtrlA..KeyBackspace2) are the same as ascii code
+	if ev.Key <= termbox.KeyBackspace2 {
+		return []byte{byte(ev.Key)}
+	}
+
+	return nil
+}
@@ -19,7 +19,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/blacknon/go-sshlib"
 	"github.com/blacknon/lssh/output"
 	"golang.org/x/crypto/ssh"
 )
@@ -34,7 +33,6 @@ var (
 )
 
 // TODO(blacknon): 以下のBuild-in Commandを追加する
-//     - %cd <PATH>         ... リモートのディレクトリを変更する(事前のチェックにsftpを使用か？)
 //     - %lcd <PATH>        ... ローカルのディレクトリを変更する
 //     - %save <num> <PATH> ... 指定したnumの履歴をPATHに記録する (v0.6.11)
 //     - %set <args..>      ... 指定されたオプションを設定する(Optionsにて管理) (v0.6.11)
@@ -57,6 +55,17 @@ func checkBuildInCommand(cmd string) (isBuildInCmd bool) {
 	case
 		"%history",
 		"%out", "%outlist", "%outexec",
+		"%rehash",
+		"%cd",
+		"%stdin",
+		"%attach", "%sync",
+		"%status",
+		"%timeout",
+		"%jobs", "%fg", "%wait", "%kill",
+		"%hosts", "%add", "%remove", "%disable", "%enable", "%select",
+		"%forward",
+		"%dryrun",
+		"%canary", "%rolling", "%serial",
 		"%save",
 		"%set": // parsent build-in command.
 		isBuildInCmd = true
@@ -137,11 +146,109 @@ func (s *shell) run(pline pipeLine, in *io.PipeReader, out *io.PipeWriter, ch ch
 	case "%outexec":
 		s.buildin_outexec(pline, in, out, ch, kill)
 		return
+
+	// %rehash
+	case "%rehash":
+		s.buildin_rehash(out, ch)
+		return
+
+	// %cd [path]
+	case "%cd":
+		s.buildin_cd(pline.Args[1:], out, ch)
+		return
+
+	// %attach [host]
+	case "%attach":
+		s.buildin_attach(pline.Args[1:], out, ch)
+		return
+
+	// %sync
+	case "%sync":
+		s.buildin_sync(out, ch)
+		return
+
+	// %status
+	case "%status":
+		s.buildin_status(out, ch)
+		return
+
+	// %jobs
+	case "%jobs":
+		s.buildin_jobs(out, ch)
+		return
+
+	// %fg [N]
+	case "%fg":
+		s.buildin_fg(pline.Args[1:], out, ch, kill)
+		return
+
+	// %wait [N]
+	case "%wait":
+		s.buildin_wait(pline.Args[1:], out, ch, kill)
+		return
+
+	// %kill [-SIGNAL] [N]
+	case "%kill":
+		s.buildin_kill(pline.Args[1:], out, ch)
+		return
+
+	// %hosts
+	case "%hosts":
+		s.buildin_hosts(out, ch)
+		return
+
+	// %add host...
+	case "%add":
+		s.buildin_add(pline.Args[1:], out, ch)
+		return
+
+	// %remove host...
+	case "%remove":
+		s.buildin_remove(pline.Args[1:], out, ch)
+		return
+
+	// %disable host...
+	case "%disable":
+		s.buildin_disable(pline.Args[1:], true, out, ch)
+		return
+
+	// %enable host...
+	case "%enable":
+		s.buildin_disable(pline.Args[1:], false, out, ch)
+		return
+
+	// %select
+	case "%select":
+		s.buildin_select(out, ch)
+		return
+
+	// %forward add|list|del
+	case "%forward":
+		s.buildin_forward(pline.Args[1:], out, ch)
+		return
+
+	// %canary, %rolling, %serial (without command)
+	case "%canary", "%rolling", "%serial":
+		s.buildin_strategy(command, out, ch)
+		return
+
+	// %dryrun [on|off]
+	case "%dryrun":
+		s.buildin_dryrun(pline.Args[1:], out, ch)
+		return
+
+	// %stdin <mode> command... (without command)
+	case "%stdin":
+		s.buildin_stdin(out, ch)
+		return
 	}
 
 	// check and exec local command
 	buildinRegex := regexp.MustCompile(`^!.*`)
 	switch {
+	case pline.Compound:
+		// exec remote machine as it is (for, if, `( ... )`, etc...)
+		s.executeRemotePipeLine(pline, in, out, ch, kill)
 	case buildinRegex.MatchString(command):
 		// exec local machine
 		s.executeLocalPipeLine(pline, in, out, ch, kill, os.Environ())
@@ -153,6 +260,78 @@ func (s *shell) run(pline pipeLine, in *io.PipeReader, out *io.PipeWriter, ch ch
 	return
 }
 
+// buildin_cd is change remote working directory.
+// The directory is checked on each host, and kept per host as an absolute path.
+// example:
+//   - %cd         ... reset to login directory
+//   - %cd <PATH>
+func (s *shell) buildin_cd(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	m := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	for _, c := range s.getActiveConnects() {
+		wg.Add(1)
+		go func(c *sConnect) {
+			defer wg.Done()
+
+			// reset
+			if path == "" {
+				m.Lock()
+				c.Cwd = ""
+				m.Unlock()
+				return
+			}
+
+			quoted, ok := quotePathWord(path)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "%s: %%cd: %s: invalid path\n", c.Name, path)
+				return
+			}
+
+			command := "cd -- " + quoted + " && pwd"
+			if c.Cwd != "" {
+				command = "cd -- " + shellQuote(c.Cwd) + " && " + command
+			}
+
+			data, err := getRemoteOutput(c, command, s.Options.CompleteTimeout)
+			dir := strings.TrimSpace(string(data))
+			if err != nil || dir == "" {
+				fmt.Fprintf(os.Stderr, "%s: %%cd: %s: No such directory\n", c.Name, path)
+				return
+			}
+
+			m.Lock()
+			c.Cwd = dir
+			m.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	// path complete data is depend on cwd
+	s.pathCompleteKey = ""
+
+	if path != "" {
+		for _, c := range s.getActiveConnects() {
+			fmt.Fprintf(stdout, "%s: %s\n", c.Name, c.Cwd)
+		}
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
 // localCmd_set is set pshll option.
 // TODO(blacknon): Optionsの値などについて、あとから変更できるようにする。
 // func (s *shell) buildin_set(args []string, out *io.PipeWriter, ch chan<- bool) {
@@ -164,19 +343,14 @@ func (s *shell) run(pline pipeLine, in *io.PipeReader, out *io.PipeWriter, ch ch
 // func (s *shell) buildin_save(args []string, out *io.PipeWriter, ch chan<- bool) {
 // }
 
-// localCmd_history is printout history (shell history)
+// localCmd_history is printout history (shell history).
+// history read from file at startup, and commands run in this session.
 func (s *shell) buildin_history(out *io.PipeWriter, ch chan<- bool) {
 	stdout := setOutput(out)
 
-	// read history file
-	data, err := s.GetHistoryFromFile()
-	if err != nil {
-		return
-	}
-
-	// print out history
-	for _, h := range data {
-		fmt.Fprintf(stdout, "%s: %s\n", h.Timestamp, h.Command)
+	// print out history (number is used by `%!N`)
+	for i, h := range s.getCommandHistory() {
+		fmt.Fprintf(stdout, "%5d  %s: %s\n", i+1, strings.TrimSpace(h.Timestamp), h.Command)
 	}
 
 	// close out
@@ -194,7 +368,7 @@ func (s *shell) buildin_outlist(out *io.PipeWriter, ch chan<- bool) {
 	stdout := setOutput(out)
 
 	for i := 0; i < len(s.History); i++ {
-		h := s.History[i]
+		h := s.getHistoryResult(i)
 		for _, hh := range h {
 			fmt.Fprintf(stdout, "%3d : %s\n", i, hh.Command)
 			break
@@ -217,7 +391,7 @@ func (s *shell) buildin_outlist(out *io.PipeWriter, ch chan<- bool) {
 //   - %out <num>
 func (s *shell) buildin_out(num int, out *io.PipeWriter, ch chan<- bool) {
 	stdout := setOutput(out)
-	histories := s.History[num]
+	histories := s.getHistoryResult(num)
 
 	// get key
 	keys := []string{}
@@ -226,7 +400,13 @@ func (s *shell) buildin_out(num int, out *io.PipeWriter, ch chan<- bool) {
 	}
 	sort.Strings(keys)
 
+	// order of batch (`%canary`, `%rolling`, `%serial`)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return getBatchNo(histories[keys[i]]) < getBatchNo(histories[keys[j]])
+	})
+
 	i := 0
+	var batch string
 	for _, k := range keys {
 		h := histories[k]
 
@@ -236,6 +416,12 @@ func (s *shell) buildin_out(num int, out *io.PipeWriter, ch chan<- bool) {
 		}
 		i += 1
 
+		// print out batch, if changed
+		if h.Batch != nil && h.Batch.String() != batch {
+			batch = h.Batch.String()
+			fmt.Fprintf(os.Stderr, "[Batch:%s ]\n", batch)
+		}
+
 		// print out result
 		if len(histories) > 1 && stdout == os.Stdout && h.Output != nil {
 			// set Output.Count
@@ -269,84 +455,212 @@ func (s *shell) buildin_out(num int, out *io.PipeWriter, ch chan<- bool) {
 // executePipeLineRemote is exec command in remote machine.
 // Didn't know how to send data from Writer to Channel, so switch the function if * io.PipeWriter is Nil.
 func (s *shell) executeRemotePipeLine(pline pipeLine, in *io.PipeReader, out *io.PipeWriter, ch chan<- bool, kill chan bool) {
-	// join command
-	command := strings.Join(pline.Args, " ")
+	// get command line
+	command := pline.Command()
 
 	// set stdin/stdout
-	stdin := setInput(in)
+	var stdin io.Reader
+	stdin = setInput(in)
 	stdout := setOutput(out)
 
+	// background job does not read terminal
+	if pline.Job != nil && in == nil {
+		stdin = strings.NewReader("")
+	}
+
+	// history number of output
+	count := s.Count
+	if pline.Job != nil {
+		count = pline.Job.Count
+	}
+
+	// get target connects (`@host:`)
+	connects, err := s.getTargetConnects(pline.Targets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+
+		// discard input, and close out
+		if in != nil {
+			go io.Copy(io.Discard, in)
+		}
+		if out != nil {
+			out.CloseWithError(io.ErrClosedPipe)
+		}
+
+		ch <- false
+		return
+	}
+
 	// create channels
-	exit := make(chan bool)
 	exitInput := make(chan bool) // Input finish channel
-	exitOutput := make(chan bool)
 
 	// create []io.WriteCloser
 	var writers []io.WriteCloser
 
 	// create []ssh.Session
 	var sessions []*ssh.Session
+	var commands []string
+	var names []string
+	var outputs []*switchWriter
+	var statuses []*hostStatus
+	var runs []int
+	var running []*runningSession
+
+	// local redirect (`%>`, `%>>`)
+	var redirect *localRedirect
+	if pline.RedirectFile != "" && stdout == os.Stdout {
+		redirect = newLocalRedirect(pline.RedirectAppend)
+		defer redirect.Close()
+	}
+
+	// Request tty (Only when input is os.Stdin and output is os.Stdout).
+	tty := stdin == os.Stdin && stdout == os.Stdout && redirect == nil
+
+	// transfer counter of host-to-host pipeline
+	var counter *transferCounter
+	if len(pline.Targets) > 0 && out != nil {
+		counter = newTransferCounter(pline.Targets, !s.statusPanel)
+		defer counter.Stop()
+	}
 
 	// create session and writers
-	m := new(sync.Mutex)
-	for _, c := range s.Connects {
+	for _, c := range connects {
 		// create session
 		session, err := c.CreateSession()
 		if err != nil {
 			continue
 		}
 
-		// Request tty (Only when input is os.Stdin and output is os.Stdout).
-		if stdin == os.Stdin && stdout == os.Stdout {
-			sshlib.RequestTty(session)
+		// create local redirect writer
+		var rw *io.PipeWriter
+		if redirect != nil {
+			rw, err = redirect.NewWriter(s.getRedirectPath(c, pline.RedirectFile), s.getRedirectHeader(c))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				session.Close()
+				continue
+			}
+			defer rw.CloseWithError(io.ErrClosedPipe)
+		}
+
+		// Request tty
+		if tty {
+			requestTty(session)
 		}
 
+		// run at remote working directory
+		commands = append(commands, c.remoteCommand(command))
+
 		// set stdout
 		var ow io.Writer
 		ow = stdout
 		if ow == os.Stdout {
 			// create Output Writer
-			c.Output.Count = s.Count
-			w := c.Output.NewWriter()
-			defer w.CloseWithError(io.ErrClosedPipe)
+			c.Output.Count = count
+			var w io.Writer
+			if rw != nil {
+				w = rw
+			} else {
+				pw := c.Output.NewWriter()
+				defer pw.CloseWithError(io.ErrClosedPipe)
+				w = pw
+
+				// output of background job is shown only while `%fg`
+				if pline.Job != nil {
+					w = pline.Job.addOutput(pw)
+				}
+			}
 
 			// create pShellHistory Writer
-			hw := s.NewHistoryWriter(c.Output.Server, c.Output, m)
+			hw := s.NewHistoryWriter(count, c.Output.Server, c.Output, pline.Batch)
 			defer hw.CloseWithError(io.ErrClosedPipe)
 
 			ow = io.MultiWriter(w, hw)
 		}
-		session.Stdout = ow
+		if counter != nil {
+			ow = io.MultiWriter(ow, counter)
+		}
+
+		// count output bytes to host status.
+		// background job has own status of host (`%jobs`), so it does not change the status of foreground command.
+		var status *hostStatus
+		if pline.Job != nil {
+			status = pline.Job.addHost(c.Name, session)
+		} else {
+			status = s.getHostStatus(c.Name)
+		}
+		run := status.start()
+		ow = io.MultiWriter(ow, status.newWriter(run))
+		statuses = append(statuses, status)
+		runs = append(runs, run)
+
+		// output can be detached at timeout
+		sw := newSwitchWriter(ow)
+		session.Stdout = sw
+		outputs = append(outputs, sw)
 
 		// get and append stdin writer
 		w, _ := session.StdinPipe()
 		writers = append(writers, w)
 
+		// foreground session can be interrupted per host
+		var rs *runningSession
+		if pline.Job == nil {
+			rs = s.foreground.add(c.Name, session, w, tty)
+		}
+		running = append(running, rs)
+
 		// append sessions
 		sessions = append(sessions, session)
+		names = append(names, c.Name)
 	}
 
 	// multi input-writer
-	go output.PushInput(exitInput, writers, stdin)
+	// `%stdin` split mode is used only when input is from pipe (not terminal).
+	var pump *inputPump
+	switch {
+	case stdin != os.Stdin && pline.StdinMode != "" && pline.StdinMode != stdinModeBroadcast:
+		go pushInputSplit(pline.StdinMode, writers, stdin)
+	case stdin != os.Stdin:
+		go pushInputBroadcast(writers, stdin)
+	default:
+		pump, err = newInputPump(writers)
+		if err != nil {
+			go output.PushInput(exitInput, writers, stdin)
+			break
+		}
+		s.foreground.setPump(pump)
+		go pump.Run()
+	}
 
 	// run command
-	for _, s := range sessions {
-		session := s
+	exit := make(chan sessionResult, len(sessions)) // exit status of each session
+	for i, session := range sessions {
+		i := i
+		session := session
+		command := commands[i]
+		status := statuses[i]
+		run := runs[i]
+		rs := running[i]
 		go func() {
-			session.Run(command)
-			session.Close()
-			exit <- true
-			if stdout == os.Stdout {
-				exitOutput <- true
+			err := session.Run(command)
+			status.finish(run, err)
+			if rs != nil {
+				s.foreground.finish(rs)
 			}
+			session.Close()
+			exit <- sessionResult{index: i, success: err == nil}
 		}()
 	}
 
-	// kill
+	// kill (except detached sessions)
 	go func() {
 		select {
 		case <-kill:
-			for _, s := range sessions {
+			for i, s := range sessions {
+				if outputs[i].IsDetached() {
+					continue
+				}
 				s.Signal(ssh.SIGINT)
 				s.Close()
 			}
@@ -354,16 +668,21 @@ func (s *shell) executeRemotePipeLine(pline pipeLine, in *io.PipeReader, out *io
 	}()
 
 	// wait
-	s.wait(len(sessions), exit)
+	// (success only if command is successful on all hosts)
+	timeout, detach := s.getTimeout(pline)
+	status := s.waitSessions(sessions, names, statuses, outputs, exit, timeout, detach)
 
 	// wait time (0.050 sec)
 	time.Sleep(500 * time.Millisecond)
 
 	// send exit
-	ch <- true
+	ch <- status
 
 	// exit input.
-	if stdin == os.Stdin {
+	switch {
+	case pump != nil:
+		pump.Close()
+	case stdin == os.Stdin:
 		exitInput <- true
 	}
 
@@ -389,9 +708,8 @@ func (s *shell) executeLocalPipeLine(pline pipeLine, in *io.PipeReader, out *io.
 	// set HistoryResult
 	var stdoutw io.Writer
 	stdoutw = stdout
-	m := new(sync.Mutex)
 	if stdout == os.Stdout {
-		pw := s.NewHistoryWriter("localhost", nil, m)
+		pw := s.NewHistoryWriter(s.Count, "localhost", nil, pline.Batch)
 		defer pw.CloseWithError(io.ErrClosedPipe)
 		stdoutw = io.MultiWriter(pw, stdout)
 	} else {
@@ -399,11 +717,7 @@ func (s *shell) executeLocalPipeLine(pline pipeLine, in *io.PipeReader, out *io.
 	}
 
 	// delete command prefix(`!`)
-	rep := regexp.MustCompile(`^!`)
-	pline.Args[0] = rep.ReplaceAllString(pline.Args[0], "")
-
-	// join command
-	command := strings.Join(pline.Args, " ")
+	command := pline.LocalCommand()
 
 	// execute command
 	var cmd *exec.Cmd
@@ -415,6 +729,9 @@ func (s *shell) executeLocalPipeLine(pline pipeLine, in *io.PipeReader, out *io.
 
 	// set stdin, stdout, stderr
 	cmd.Stdin = stdin
+	if in != nil {
+		cmd.Stdin = pipeEOFReader{in}
+	}
 	if s.Options.LocalCommandNotRecordResult {
 		cmd.Stdout = stdout
 	} else { // default
@@ -427,18 +744,21 @@ func (s *shell) executeLocalPipeLine(pline pipeLine, in *io.PipeReader, out *io.
 
 	// run command
 	err = cmd.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	} else {
+		// get signal and kill
+		p := cmd.Process
+		go func() {
+			select {
+			case <-kill:
+				p.Kill()
+			}
+		}()
 
-	// get signal and kill
-	p := cmd.Process
-	go func() {
-		select {
-		case <-kill:
-			p.Kill()
-		}
-	}()
-
-	// wait command
-	cmd.Wait()
+		// wait command
+		err = cmd.Wait()
+	}
 
 	// close out, or write pShellHistory
 	switch stdout.(type) {
@@ -447,16 +767,43 @@ func (s *shell) executeLocalPipeLine(pline pipeLine, in *io.PipeReader, out *io.
 	}
 
 	// send exit
-	ch <- true
+	ch <- err == nil
+
+	return
+}
+
+// pipeEOFReader is reader of pipe between elements of pipeline.
+// The pipe is closed with io.ErrClosedPipe, and it is read as io.EOF (not error of local command).
+type pipeEOFReader struct {
+	r io.Reader
+}
+
+// Read read from r. io.ErrClosedPipe is returned as io.EOF.
+func (p pipeEOFReader) Read(b []byte) (n int, err error) {
+	n, err = p.r.Read(b)
+	if err == io.ErrClosedPipe {
+		err = io.EOF
+	}
 
 	return
 }
 
 // s.wait
-func (s *shell) wait(num int, ch <-chan bool) {
+// It returns true if all received status is true (success).
+func (s *shell) wait(num int, ch <-chan bool) (status bool) {
+	status = true
 	for i := 0; i < num; i++ {
-		<-ch
+		if !<-ch {
+			status = false
+		}
 	}
+
+	return
+}
+
+// shellQuote quote string with single quote, for use in remote shell command.
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
 }
 
 // setInput
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blacknon/lssh/common"
+)
+
+// Port forwarding management.
+//   - %forward add <-L|-R|-D|-r> spec... [@host[,host...]] ... start port forwarding on hosts (default is all hosts).
+//   - %forward list                                      ... print port forwardings, with connections and bytes.
+//   - %forward del N...                                  ... stop port forwardings.
+// Options of add are the same as startup options. Port of local listener is shifted by index of host.
+//   ex.) %forward add -L 8080:localhost:80 @web01
+
+// buildin_forward manage port forwardings.
+func (s *shell) buildin_forward(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		sub := "list"
+		if len(args) > 0 {
+			sub, args = args[0], args[1:]
+		}
+
+		switch sub {
+		case "add":
+			return s.forwardAdd(args, stdout)
+
+		case "list", "ls":
+			printPortForwards(stdout, s.getPortForwards(), true)
+
+		case "del", "delete", "rm":
+			if len(args) == 0 {
+				return fmt.Errorf("%%forward: specify forward id. usage: %%forward del N...")
+			}
+
+			for _, arg := range args {
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("%%forward: invalid forward id `%s`", arg)
+				}
+
+				fw, err := s.deletePortForward(id)
+				if err != nil {
+					return fmt.Errorf("%%forward: %s", err)
+				}
+
+				listen, connect := fw.Addrs()
+				fmt.Fprintf(stdout, "delete forward %d (%s %s => %s)\n", fw.ID, fw.Mode, listen, connect)
+			}
+
+		default:
+			return fmt.Errorf("%%forward: unknown subcommand `%s`. usage: %%forward add|list|del", sub)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// forwardAdd start port forwardings of args on target hosts, and print them.
+func (s *shell) forwardAdd(args []string, stdout io.Writer) error {
+	var specs []forwardSpec
+	var targets []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// target host
+		if strings.HasPrefix(arg, "@") {
+			targets = append(targets, parseTargetPrefix(arg)...)
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return fmt.Errorf("%%forward: invalid argument `%s`. usage: %%forward add <-L|-R|-D|-r> spec... [@host]", arg)
+		}
+		i++
+
+		spec, err := parseForwardSpec(arg, args[i])
+		if err != nil {
+			return fmt.Errorf("%%forward: %s", err)
+		}
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return fmt.Errorf("%%forward: specify forward. usage: %%forward add <-L|-R|-D|-r> spec... [@host]")
+	}
+
+	connects, err := s.getTargetConnects(targets)
+	if err != nil {
+		return fmt.Errorf("%%forward: %s", err)
+	}
+
+	var started []*portForward
+	ok := true
+	for i, c := range connects {
+		forwards, o := openPortForwards(c, specs, i)
+		started = append(started, forwards...)
+		ok = ok && o
+	}
+
+	s.registerPortForwards(started)
+	printPortForwards(stdout, started, false)
+
+	if !ok {
+		return fmt.Errorf("%%forward: some forwards could not be started")
+	}
+
+	return nil
+}
+
+// parseForwardSpec return forwardSpec of option (`-L`, `-R`, `-D`, `-r`) and value.
+func parseForwardSpec(option, value string) (spec forwardSpec, err error) {
+	isPort := regexp.MustCompile(`^[0-9]+$`).MatchString(value)
+
+	switch option {
+	case "-L":
+		spec.Mode = forwardLocal
+		spec.Local, spec.Remote, err = common.ParseForwardPort(value)
+
+	case "-R":
+		// If only port is specified, it is reverse dynamic forward.
+		if isPort {
+			spec.Mode = forwardReverseDynamic
+			spec.Remote = net.JoinHostPort("localhost", value)
+			return
+		}
+
+		// listen address is remote side, and connect address is local side.
+		spec.Mode = forwardRemote
+		spec.Remote, spec.Local, err = common.ParseForwardPort(value)
+
+	case "-D", "-r":
+		if !isPort {
+			return spec, fmt.Errorf("invalid port `%s`", value)
+		}
+
+		if option == "-D" {
+			spec.Mode = forwardDynamic
+			spec.Local = net.JoinHostPort("localhost", value)
+		} else {
+			spec.Mode = forwardHTTPReverseDynamic
+			spec.Remote = net.JoinHostPort("localhost", value)
+		}
+
+	default:
+		return spec, fmt.Errorf("unknown option `%s`", option)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%s %s: %s", option, value, err)
+	}
+
+	return
+}
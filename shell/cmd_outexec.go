@@ -79,7 +79,7 @@ func (s *shell) buildin_outexec(pline pipeLine, in *io.PipeReader, out *io.PipeW
 
 		hnum, aerr := strconv.Atoi(c.String("n"))
 
-		histories := s.History[hnum]
+		histories := s.getHistoryResult(hnum)
 
 		// get key
 		keys := []string{}
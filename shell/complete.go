@@ -7,9 +7,9 @@ package shell
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"os/exec"
-	libpath "path"
-	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -19,7 +19,6 @@ import (
 	"github.com/c-bata/go-prompt"
 )
 
-// TODO(blacknon): `!!`や"`:$`についても実装を行う
 // TODO(blacknon): `!command`だとまとめてパイプ経由でデータを渡すことになっているが、`!!command`で個別のローカルコマンドにデータを渡すように実装する
 
 // Completer parallel-shell complete function
@@ -64,16 +63,48 @@ func (s *shell) Completer(t prompt.Document) []prompt.Suggest {
 				{Text: "quit", Description: "exit lssh shell"},
 				{Text: "clear", Description: "clear screen"},
 				{Text: "%history", Description: "show history"},
+				{Text: "%!!", Description: "history expansion. previous command"},
+				{Text: "%!$", Description: "history expansion. last argument of previous command"},
 				{Text: "%out", Description: "%out [num], show history result."},
 				{Text: "%outlist", Description: "%outlist, show history result list."},
 				{Text: "%outexec", Description: "%outexec <-n num> command..., exec local command with output result. result is in env variable."},
+				{Text: "%rehash", Description: "%rehash, refresh command complete data from all hosts."},
+				{Text: "%cd", Description: "%cd [path], change remote working directory."},
+				{Text: "%attach", Description: "%attach [host], open interactive shell of host. Ctrl-] to return."},
+				{Text: "%sync", Description: "%sync, open interactive shell of all hosts in split panes, and send keystrokes to all. Ctrl-] to return."},
+				{Text: "%status", Description: "%status, show status of all hosts. (Ctrl-T toggles status panel while running)"},
+				{Text: "%timeout", Description: "%timeout [-d] <duration> command..., set timeout of command. -d detach hosts not finished to background."},
+				{Text: "%jobs", Description: "%jobs, show running background jobs (command &)."},
+				{Text: "%fg", Description: "%fg [N], show output of background job until it finish. Ctrl-C to return."},
+				{Text: "%wait", Description: "%wait [N], wait for background job (default: all jobs) to finish."},
+				{Text: "%kill", Description: "%kill [-SIGNAL] [N], send signal to background job (default: TERM)."},
+				{Text: "%hosts", Description: "%hosts, show connected hosts with state."},
+				{Text: "%add", Description: "%add host..., connect to hosts in config and add them."},
+				{Text: "%remove", Description: "%remove host..., disconnect and remove hosts."},
+				{Text: "%disable", Description: "%disable host..., exclude hosts from command without disconnecting."},
+				{Text: "%enable", Description: "%enable host..., include disabled hosts again."},
+				{Text: "%select", Description: "%select, re-open host selector with current hosts (Ctrl-O)."},
+				{Text: "%forward", Description: "%forward add|list|del, manage port forwardings."},
+				{Text: "%dryrun", Description: "%dryrun [on|off] or %dryrun command..., print what would run on each host without execution."},
+				{Text: "%canary", Description: "%canary N command..., run command on N hosts first, and ask before continuing on the rest."},
+				{Text: "%rolling", Description: "%rolling [-p pause] [-c check] N command..., run command in batches of N hosts, with pause and check command between batches."},
+				{Text: "%serial", Description: "%serial command..., run command on one host at a time, abort on the first failure."},
+				{Text: "%stdin", Description: "%stdin <mode> command..., set stdin fan-out mode (broadcast, rr, chunk, key[:N])."},
 				// outの出力でdiffをするためのローカルコマンド。すべての出力と比較するのはあまりに辛いと思われるため、最初の出力との比較、といった方式で対応するのが良いか？？
 				// {Text: "%diff", Description: "%diff [num], show history result list."},
 			}
 			c = append(c, buildin...)
 
+			// target host prefix (`@host:`)
+			for _, con := range s.getActiveConnects() {
+				c = append(c, prompt.Suggest{Text: "@" + con.Name + ":", Description: "run command only on " + con.Name})
+			}
+			for _, g := range s.getGroupNames() {
+				c = append(c, prompt.Suggest{Text: "@group:" + g + ":", Description: "run command only on group " + g})
+			}
+
 			// get remote and local command complete data
-			c = append(c, s.CmdComplete...)
+			c = append(c, s.getCmdComplete()...)
 
 			// return
 			return prompt.FilterHasPrefix(c, t.GetWordBeforeCursor(), false)
@@ -85,7 +116,7 @@ func (s *shell) Completer(t prompt.Document) []prompt.Suggest {
 			case "%out":
 				for i := 0; i < len(s.History); i++ {
 					var cmd string
-					for _, h := range s.History[i] {
+					for _, h := range s.getHistoryResult(i) {
 						cmd = h.Command
 					}
 
@@ -110,7 +141,7 @@ func (s *shell) Completer(t prompt.Document) []prompt.Suggest {
 				case "-n " == t.GetWordBeforeCursorWithSpace():
 					for i := 0; i < len(s.History); i++ {
 						var cmd string
-						for _, h := range s.History[i] {
+						for _, h := range s.getHistoryResult(i) {
 							cmd = h.Command
 						}
 
@@ -125,26 +156,113 @@ func (s *shell) Completer(t prompt.Document) []prompt.Suggest {
 					suggest = s.GetLocalhostCommandComplete()
 				}
 
+			// %attach [host]
+			case "%attach":
+				if num == 1 || (num == 2 && char != " ") {
+					for _, con := range s.getActiveConnects() {
+						suggest = append(suggest, prompt.Suggest{Text: con.Name, Description: "host"})
+					}
+				}
+
+			// %add host...
+			case "%add":
+				for _, name := range s.getAddableHosts() {
+					suggest = append(suggest, prompt.Suggest{Text: name, Description: "host in config"})
+				}
+				for _, g := range s.getGroupNames() {
+					suggest = append(suggest, prompt.Suggest{Text: "group:" + g, Description: "group in config"})
+				}
+
+			// %remove, %disable, %enable host...
+			case "%remove", "%disable", "%enable":
+				for _, con := range s.getConnects() {
+					if (c == "%disable" && con.Disabled) || (c == "%enable" && !con.Disabled) {
+						continue
+					}
+					suggest = append(suggest, prompt.Suggest{Text: con.Name, Description: "host"})
+				}
+
+			// %dryrun [on|off]
+			case "%dryrun":
+				if num == 1 || (num == 2 && char != " ") {
+					suggest = []prompt.Suggest{
+						{Text: "on", Description: "start dry-run mode. command lines are printed only"},
+						{Text: "off", Description: "stop dry-run mode"},
+					}
+				}
+
+			// %forward add|list|del
+			case "%forward":
+				args := pslice[sl-1][ll-1].Args
+				switch {
+				case num == 1 || (num == 2 && char != " "):
+					suggest = []prompt.Suggest{
+						{Text: "add", Description: "add <-L|-R|-D|-r> spec... [@host], start port forwarding"},
+						{Text: "list", Description: "print port forwardings with connections and bytes"},
+						{Text: "del", Description: "del N..., stop port forwarding"},
+					}
+				case args[1] == "add":
+					suggest = []prompt.Suggest{
+						{Text: "-L", Description: "local port forward. [bind_address:]port:remote_address:port"},
+						{Text: "-R", Description: "remote port forward. [bind_address:]port:local_address:port, or port (socks5)"},
+						{Text: "-D", Description: "dynamic port forward (socks5). port"},
+						{Text: "-r", Description: "reverse dynamic port forward (http). port"},
+					}
+					for _, con := range s.getActiveConnects() {
+						suggest = append(suggest, prompt.Suggest{Text: "@" + con.Name, Description: "host"})
+					}
+				case args[1] == "del":
+					for _, fw := range s.getPortForwards() {
+						listen, connect := fw.Addrs()
+						suggest = append(suggest, prompt.Suggest{Text: strconv.Itoa(fw.ID), Description: fw.Mode + " " + listen + " => " + connect})
+					}
+				}
+
+			// %fg [N], %kill [-SIGNAL] [N]
+			case "%fg", "%wait", "%kill":
+				for _, j := range s.getJobList() {
+					suggest = append(suggest, prompt.Suggest{Text: strconv.Itoa(j.ID), Description: j.Command})
+				}
+
+			// %stdin <mode> command...
+			case "%stdin":
+				if num == 1 || (num == 2 && char != " ") {
+					suggest = []prompt.Suggest{
+						{Text: "broadcast", Description: "send all input to all hosts (default)"},
+						{Text: "rr", Description: "send each line to hosts in round-robin"},
+						{Text: "chunk", Description: "split input lines into contiguous chunks per host"},
+						{Text: "key", Description: "send line to the host selected by hash of line"},
+						{Text: "key:1", Description: "send line to the host selected by hash of column N"},
+					}
+				} else {
+					suggest = s.getCmdComplete()
+				}
+
+			// %cd
+			case "%cd":
+				for _, sg := range s.getPathSuggest(true, t.GetWordBeforeCursor()) {
+					if strings.HasSuffix(sg.Text, "/") {
+						suggest = append(suggest, sg)
+					}
+				}
 			}
 
 			return prompt.FilterHasPrefix(suggest, t.GetWordBeforeCursor(), false)
 
 		default:
-			switch {
-			case contains([]string{"/"}, char): // char is slach or
-				s.PathComplete = s.GetPathComplete(!checkLocalCommand(c), t.GetWordBeforeCursor())
-			case contains([]string{" "}, char) && strings.Count(t.CurrentLineBeforeCursor(), " ") == 1:
-				s.PathComplete = s.GetPathComplete(!checkLocalCommand(c), t.GetWordBeforeCursor())
-			}
+			// argument complete of remote command (systemctl, docker, etc...)
+			if !checkLocalCommand(c) {
+				args := pslice[sl-1][ll-1].Args[1:]
+				if char != " " && len(args) > 0 {
+					args = args[:len(args)-1]
+				}
 
-			// get last slash place
-			word := t.GetWordBeforeCursor()
-			sp := strings.LastIndex(word, "/")
-			if len(word) > 0 {
-				word = word[sp+1:]
+				if suggest, ok := s.getArgSuggest(c, args, t.GetWordBeforeCursor()); ok {
+					return suggest
+				}
 			}
 
-			return prompt.FilterHasPrefix(s.PathComplete, word, false)
+			return s.getPathSuggest(!checkLocalCommand(c), t.GetWordBeforeCursor())
 		}
 	}
 
@@ -172,126 +290,69 @@ func (s *shell) GetLocalhostCommandComplete() (suggest []prompt.Suggest) {
 	return suggest
 }
 
-// GetCommandComplete get command list remote machine.
-// mode ... command/path
-// data ... Value being entered
-func (s *shell) GetCommandComplete() {
-	// bash complete command. use `compgen`.
-	compCmd := []string{"compgen", "-c"}
-	command := strings.Join(compCmd, " ")
-
-	// get local machine command complete
-	local, _ := exec.Command("bash", "-c", command).Output()
-	rd := strings.NewReader(string(local))
-	sc := bufio.NewScanner(rd)
-	for sc.Scan() {
-		suggest := prompt.Suggest{
-			Text:        "!" + sc.Text(),
-			Description: "Command. from:localhost",
-		}
-		s.CmdComplete = append(s.CmdComplete, suggest)
-	}
-
-	// get remote machine command complete
-	// command map
-	cmdMap := map[string][]string{}
-
-	// append command to cmdMap
-	for _, c := range s.Connects {
-		// Create buffer
-		buf := new(bytes.Buffer)
-
-		// Create session, and output to buffer
-		session, _ := c.CreateSession()
-		session.Stdout = buf
-
-		// Run get complete command
-		session.Run(command)
-
-		// Scan and put completed command to map.
-		sc := bufio.NewScanner(buf)
-		for sc.Scan() {
-			cmdMap[sc.Text()] = append(cmdMap[sc.Text()], c.Name)
-		}
-	}
-
-	// cmdMap to suggest
-	for cmd, hosts := range cmdMap {
-		// join hosts
-		sort.Strings(hosts)
-		h := strings.Join(hosts, ",")
-
-		// create suggest
-		suggest := prompt.Suggest{
-			Text:        cmd,
-			Description: "Command. from:" + h,
+// GetPathComplete return complete path from local or remote machine.
+// dir is the directory part of the word being typed (ex. `/etc/`, `src/`, or empty).
+// Remote results are merged from all hosts, and the description shows which hosts have the path.
+func (s *shell) GetPathComplete(remote bool, dir string) (p []prompt.Suggest) {
+	command := "ls -1Ap"
+	if dir != "" {
+		quoted, ok := quotePathWord(dir)
+		if !ok {
+			return
 		}
-
-		// append s.Complete
-		s.CmdComplete = append(s.CmdComplete, suggest)
+		command = "cd -- " + quoted + " && " + command
 	}
 
-	sort.SliceStable(s.CmdComplete, func(i, j int) bool { return s.CmdComplete[i].Text < s.CmdComplete[j].Text })
-}
-
-// GetPathComplete return complete path from local or remote machine.
-// TODO(blacknon): 複数のノードにあるPATHだけ補完リストに出てる状態なので、単一ノードにしか無いファイルも出力されるよう修正する
-func (s *shell) GetPathComplete(remote bool, word string) (p []prompt.Suggest) {
-	compCmd := []string{"compgen", "-f", word}
-	command := strings.Join(compCmd, " ")
-
 	switch {
 	case remote: // is remote machine
 		// create map
 		m := map[string][]string{}
 
-		exit := make(chan bool)
-
 		// create sync mutex
 		sm := new(sync.Mutex)
+		wg := new(sync.WaitGroup)
 
 		// append path to m
-		for _, c := range s.Connects {
-			con := c
-			go func() {
-				// Create buffer
-				buf := new(bytes.Buffer)
-
-				// Create session, and output to buffer
-				session, _ := c.CreateSession()
-				session.Stdout = buf
+		connects := s.getActiveConnects()
+		for _, c := range connects {
+			wg.Add(1)
+			go func(con *sConnect) {
+				defer wg.Done()
+
+				// respect the remote working directory
+				cmd := command
+				if con.Cwd != "" {
+					cmd = "cd -- " + shellQuote(con.Cwd) + " && " + cmd
+				}
 
-				// Run get complete command
-				session.Run(command)
+				data, err := getRemoteOutput(con, cmd, s.Options.PathCompleteTimeout)
+				if err != nil {
+					return
+				}
 
-				// Scan and put completed command to map.
-				sc := bufio.NewScanner(buf)
+				// Scan and put completed path to map.
+				sc := bufio.NewScanner(bytes.NewReader(data))
 				for sc.Scan() {
-					sm.Lock()
-
-					path := libpath.Base(sc.Text())
+					path := dir + sc.Text()
 
+					sm.Lock()
 					m[path] = append(m[path], con.Name)
 					sm.Unlock()
 				}
-
-				exit <- true
-			}()
-		}
-
-		for i := 0; i < len(s.Connects); i++ {
-			<-exit
+			}(c)
 		}
+		wg.Wait()
 
 		// m to suggest
 		for path, hosts := range m {
 			// join hosts
+			sort.Strings(hosts)
 			h := strings.Join(hosts, ",")
 
 			// create suggest
 			suggest := prompt.Suggest{
 				Text:        path,
-				Description: "remote path. from:" + h,
+				Description: fmt.Sprintf("remote path. [%d/%d] from:%s", len(hosts), len(connects), h),
 			}
 
 			// append s.Complete
@@ -300,13 +361,11 @@ func (s *shell) GetPathComplete(remote bool, word string) (p []prompt.Suggest) {
 
 	case !remote: // is local machine
 		if runtime.GOOS != "windows" {
-			sgt, _ := exec.Command("bash", "-c", command).Output()
-			rd := strings.NewReader(string(sgt))
-			sc := bufio.NewScanner(rd)
+			sgt, _ := exec.Command("sh", "-c", command).Output()
+			sc := bufio.NewScanner(bytes.NewReader(sgt))
 			for sc.Scan() {
 				suggest := prompt.Suggest{
-					Text: filepath.Base(sc.Text()),
-					// Text:        sc.Text(),
+					Text:        dir + sc.Text(),
 					Description: "local path.",
 				}
 				p = append(p, suggest)
@@ -318,6 +377,58 @@ func (s *shell) GetPathComplete(remote bool, word string) (p []prompt.Suggest) {
 	return
 }
 
+// getPathSuggest return path complete of word. The complete data is fetched again
+// only when the directory part of word (or local/remote) is changed.
+func (s *shell) getPathSuggest(remote bool, word string) []prompt.Suggest {
+	dir := word[:strings.LastIndex(word, "/")+1]
+
+	key := fmt.Sprintf("%t:%s", remote, dir)
+	if key != s.pathCompleteKey {
+		s.PathComplete = s.GetPathComplete(remote, dir)
+		s.pathCompleteKey = key
+	}
+
+	// hide dot files, if word is not start with `.`
+	base := word[len(dir):]
+	showHidden := strings.HasPrefix(base, ".")
+
+	var suggest []prompt.Suggest
+	for _, sg := range s.PathComplete {
+		if !showHidden && strings.HasPrefix(sg.Text[len(dir):], ".") {
+			continue
+		}
+		suggest = append(suggest, sg)
+	}
+
+	return prompt.FilterHasPrefix(suggest, word, false)
+}
+
+// tildePrefixRegex match tilde prefix left unquoted to be expanded (`~`, `~user`).
+var tildePrefixRegex = regexp.MustCompile(`^~[A-Za-z0-9._-]*$`)
+
+// quotePathWord quote path for shell, but leave the leading tilde (`~/`, `~user/`) to be expanded.
+// ok is false if the tilde prefix is not `~` or `~user` (ex. `~$(cmd)/`). Such path must not be run.
+func quotePathWord(path string) (quoted string, ok bool) {
+	if !strings.HasPrefix(path, "~") {
+		return shellQuote(path), true
+	}
+
+	prefix, rest := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		prefix, rest = path[:i+1], path[i+1:]
+	}
+
+	if !tildePrefixRegex.MatchString(strings.TrimSuffix(prefix, "/")) {
+		return "", false
+	}
+
+	if rest == "" {
+		return prefix, true
+	}
+
+	return prefix + shellQuote(rest), true
+}
+
 func contains(s []string, e string) bool {
 	for _, v := range s {
 		if e == v {
@@ -0,0 +1,284 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+)
+
+// argCompleteFunc is completer of command arguments.
+// args is the arguments already entered (without command name and the word being typed).
+// Return static suggest, or command to get candidates from remote hosts.
+// The command prints one candidate per line, as `text` or `text<TAB>description`.
+// If both are empty, path complete is used.
+type argCompleteFunc func(args []string) (suggest []prompt.Suggest, command string)
+
+// argCompleteCache is cache of remote argument candidates.
+type argCompleteCache struct {
+	Timestamp time.Time
+	Suggest   []prompt.Suggest
+}
+
+// argCompleter is registered argument completer.
+type argCompleter struct {
+	complete argCompleteFunc
+
+	// sameDescription is true if the remote candidate is kept only when the description is the same on all hosts reporting it.
+	// (ex. PID of `kill` is per host, so it is kept only when the process name is the same)
+	sameDescription bool
+}
+
+// argCompleters is registry of argument completers. key is command name.
+var argCompleters = map[string]argCompleter{}
+
+// registArgCompleter add argument completer of cmd.
+func registArgCompleter(cmd string, f argCompleteFunc, sameDescription bool) {
+	argCompleters[cmd] = argCompleter{complete: f, sameDescription: sameDescription}
+}
+
+func init() {
+	registArgCompleter("systemctl", systemctlArgCompleter, false)
+	registArgCompleter("service", serviceArgCompleter, false)
+	registArgCompleter("docker", dockerArgCompleter, false)
+	registArgCompleter("kill", killArgCompleter, true)
+}
+
+// getArgSuggest return argument complete of cmd. ok is false if cmd has no completer,
+// or the completer does not handle the position.
+func (s *shell) getArgSuggest(cmd string, args []string, word string) (suggest []prompt.Suggest, ok bool) {
+	completer, ok := argCompleters[cmd]
+	if !ok {
+		return
+	}
+
+	// options are not counted as argument position
+	var pargs []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			pargs = append(pargs, a)
+		}
+	}
+
+	suggest, command := completer.complete(pargs)
+	switch {
+	case len(suggest) > 0:
+	case command != "":
+		suggest = s.getRemoteArgComplete(command, completer.sameDescription)
+	default:
+		return nil, false
+	}
+
+	return prompt.FilterHasPrefix(suggest, word, false), true
+}
+
+// getRemoteArgComplete run command on all hosts, and return merged candidates.
+// The result is cached per command, for s.Options.ArgCompleteCacheTTL (cleared when hosts are changed).
+func (s *shell) getRemoteArgComplete(command string, sameDescription bool) (suggest []prompt.Suggest) {
+	s.completeMutex.Lock()
+	cache, ok := s.argCompleteCache[command]
+	s.completeMutex.Unlock()
+	if ok && time.Since(cache.Timestamp) < s.Options.ArgCompleteCacheTTL {
+		return cache.Suggest
+	}
+
+	// key: server name
+	outputs := map[string][]byte{}
+
+	sm := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	connects := s.getActiveConnects()
+	for _, c := range connects {
+		wg.Add(1)
+		go func(con *sConnect) {
+			defer wg.Done()
+
+			data, err := getRemoteOutput(con, command, s.Options.ArgCompleteTimeout)
+			if err != nil {
+				return
+			}
+
+			sm.Lock()
+			outputs[con.Name] = data
+			sm.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	suggest = mergeArgCandidates(outputs, len(connects), sameDescription)
+
+	s.completeMutex.Lock()
+	s.argCompleteCache[command] = &argCompleteCache{
+		Timestamp: time.Now(),
+		Suggest:   suggest,
+	}
+	s.completeMutex.Unlock()
+
+	return
+}
+
+// clearArgCompleteCache clear cache of remote argument candidates. Call when hosts are changed.
+func (s *shell) clearArgCompleteCache() {
+	s.completeMutex.Lock()
+	defer s.completeMutex.Unlock()
+
+	s.argCompleteCache = map[string]*argCompleteCache{}
+}
+
+// mergeArgCandidates merge candidates of outputs (key: server name), and return suggest with the hosts reporting it.
+// total is number of hosts the command was run on.
+// If sameDescription is true, candidate with different descriptions between hosts is dropped.
+func mergeArgCandidates(outputs map[string][]byte, total int, sameDescription bool) (suggest []prompt.Suggest) {
+	// key: candidate text
+	hostMap := map[string][]string{}
+	descMap := map[string]map[string]bool{}
+
+	for name, data := range outputs {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			text := strings.SplitN(sc.Text(), "\t", 2)
+			if text[0] == "" {
+				continue
+			}
+
+			desc := ""
+			if len(text) > 1 {
+				desc = text[1]
+			}
+
+			hostMap[text[0]] = append(hostMap[text[0]], name)
+			if descMap[text[0]] == nil {
+				descMap[text[0]] = map[string]bool{}
+			}
+			descMap[text[0]][desc] = true
+		}
+	}
+
+	for text, hosts := range hostMap {
+		if sameDescription && len(descMap[text]) > 1 {
+			continue
+		}
+		sort.Strings(hosts)
+
+		var descs []string
+		for d := range descMap[text] {
+			if d != "" {
+				descs = append(descs, d)
+			}
+		}
+		sort.Strings(descs)
+
+		desc := fmt.Sprintf("[%d/%d] from:%s", len(hosts), total, strings.Join(hosts, ","))
+		if len(descs) > 0 {
+			desc = strings.Join(descs, ",") + " " + desc
+		}
+
+		suggest = append(suggest, prompt.Suggest{Text: text, Description: desc})
+	}
+	sort.SliceStable(suggest, func(i, j int) bool { return suggest[i].Text < suggest[j].Text })
+
+	return
+}
+
+// systemctlArgCompleter is argument completer of `systemctl`.
+//   - systemctl <subcommand> <unit>...
+func systemctlArgCompleter(args []string) (suggest []prompt.Suggest, command string) {
+	if len(args) == 0 {
+		suggest = []prompt.Suggest{
+			{Text: "start", Description: "Start unit"},
+			{Text: "stop", Description: "Stop unit"},
+			{Text: "restart", Description: "Restart unit"},
+			{Text: "reload", Description: "Reload unit"},
+			{Text: "status", Description: "Show unit status"},
+			{Text: "enable", Description: "Enable unit"},
+			{Text: "disable", Description: "Disable unit"},
+			{Text: "is-active", Description: "Check whether unit is active"},
+			{Text: "is-enabled", Description: "Check whether unit is enabled"},
+			{Text: "list-units", Description: "List units"},
+			{Text: "daemon-reload", Description: "Reload systemd manager configuration"},
+		}
+		return
+	}
+
+	switch args[0] {
+	case "start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "status",
+		"enable", "disable", "is-active", "is-enabled", "is-failed", "mask", "unmask", "cat", "show":
+		command = "{ systemctl list-units --all --no-legend --plain; systemctl list-unit-files --no-legend; } 2>/dev/null | awk '{print $1}' | sort -u"
+	}
+
+	return
+}
+
+// serviceArgCompleter is argument completer of `service`.
+//   - service <name> <action>
+func serviceArgCompleter(args []string) (suggest []prompt.Suggest, command string) {
+	switch len(args) {
+	case 0:
+		command = "{ ls -1 /etc/init.d; systemctl list-unit-files --no-legend --type=service | awk '{print $1}' | sed 's/\\.service$//'; } 2>/dev/null | sort -u"
+	case 1:
+		suggest = []prompt.Suggest{
+			{Text: "start", Description: "Start service"},
+			{Text: "stop", Description: "Stop service"},
+			{Text: "restart", Description: "Restart service"},
+			{Text: "reload", Description: "Reload service"},
+			{Text: "status", Description: "Show service status"},
+		}
+	}
+
+	return
+}
+
+// dockerArgCompleter is argument completer of `docker`.
+//   - docker <subcommand> <container|image>...
+func dockerArgCompleter(args []string) (suggest []prompt.Suggest, command string) {
+	if len(args) == 0 {
+		suggest = []prompt.Suggest{
+			{Text: "ps", Description: "List containers"},
+			{Text: "logs", Description: "Fetch the logs of a container"},
+			{Text: "exec", Description: "Run a command in a running container"},
+			{Text: "start", Description: "Start containers"},
+			{Text: "stop", Description: "Stop containers"},
+			{Text: "restart", Description: "Restart containers"},
+			{Text: "rm", Description: "Remove containers"},
+			{Text: "inspect", Description: "Return low-level information on objects"},
+			{Text: "stats", Description: "Display container resource usage statistics"},
+			{Text: "top", Description: "Display the running processes of a container"},
+			{Text: "images", Description: "List images"},
+			{Text: "rmi", Description: "Remove images"},
+			{Text: "pull", Description: "Pull an image"},
+			{Text: "run", Description: "Run a command in a new container"},
+		}
+		return
+	}
+
+	// only the first argument after subcommand is completed
+	if len(args) > 1 {
+		return
+	}
+
+	switch args[0] {
+	case "logs", "exec", "start", "stop", "restart", "rm", "inspect", "stats", "top", "kill", "attach", "port", "pause", "unpause":
+		command = "docker ps -a --format '{{.Names}}\t{{.Image}} {{.Status}}' 2>/dev/null"
+	case "rmi", "run", "pull", "push", "tag", "history":
+		command = "docker images --format '{{.Repository}}:{{.Tag}}\t{{.Size}}' 2>/dev/null | grep -v '<none>'"
+	}
+
+	return
+}
+
+// killArgCompleter is argument completer of `kill`.
+// PID is per host, so it is completed only when the process name is the same on all hosts reporting it.
+//   - kill <pid>...
+func killArgCompleter(args []string) (suggest []prompt.Suggest, command string) {
+	command = "ps -eo pid=,comm= 2>/dev/null | awk '{print $1\"\\t\"$2}'"
+	return
+}
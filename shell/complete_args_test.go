@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/c-bata/go-prompt"
+)
+
+// TestMergeArgCandidates check merge of remote argument candidates.
+func TestMergeArgCandidates(t *testing.T) {
+	outputs := map[string][]byte{
+		"web01": []byte("1\tsystemd\n1234\tnginx\n2000\tsshd\nnginx.service\n"),
+		"web02": []byte("1\tsystemd\n1234\tmysqld\n\nnginx.service\n"),
+		"web03": []byte("1\tsystemd\n1234\tnginx\n"),
+	}
+
+	tests := []struct {
+		name            string
+		sameDescription bool
+		want            []prompt.Suggest
+	}{
+		{
+			name:            "same description",
+			sameDescription: true,
+			want: []prompt.Suggest{
+				{Text: "1", Description: "systemd [3/4] from:web01,web02,web03"},
+				{Text: "2000", Description: "sshd [1/4] from:web01"},
+				{Text: "nginx.service", Description: "[2/4] from:web01,web02"},
+			},
+		},
+		{
+			name:            "any description",
+			sameDescription: false,
+			want: []prompt.Suggest{
+				{Text: "1", Description: "systemd [3/4] from:web01,web02,web03"},
+				{Text: "1234", Description: "mysqld,nginx [3/4] from:web01,web02,web03"},
+				{Text: "2000", Description: "sshd [1/4] from:web01"},
+				{Text: "nginx.service", Description: "[2/4] from:web01,web02"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeArgCandidates(outputs, 4, tt.sameDescription)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeArgCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestArgCompleters check static suggest and remote command of argument completers, by argument position.
+func TestArgCompleters(t *testing.T) {
+	tests := []struct {
+		cmd         string
+		args        []string
+		wantSuggest bool
+		wantCommand bool
+	}{
+		{cmd: "systemctl", args: nil, wantSuggest: true},
+		{cmd: "systemctl", args: []string{"restart"}, wantCommand: true},
+		{cmd: "systemctl", args: []string{"daemon-reload"}},
+		{cmd: "service", args: nil, wantCommand: true},
+		{cmd: "service", args: []string{"nginx"}, wantSuggest: true},
+		{cmd: "service", args: []string{"nginx", "restart"}},
+		{cmd: "docker", args: nil, wantSuggest: true},
+		{cmd: "docker", args: []string{"logs"}, wantCommand: true},
+		{cmd: "docker", args: []string{"rmi"}, wantCommand: true},
+		{cmd: "docker", args: []string{"logs", "web"}},
+		{cmd: "kill", args: nil, wantCommand: true},
+	}
+
+	for _, tt := range tests {
+		completer := argCompleters[tt.cmd]
+		suggest, command := completer.complete(tt.args)
+		if (len(suggest) > 0) != tt.wantSuggest || (command != "") != tt.wantCommand {
+			t.Errorf("%s %v: suggest = %d, command = %q", tt.cmd, tt.args, len(suggest), command)
+		}
+	}
+
+	if !argCompleters["kill"].sameDescription {
+		t.Errorf("kill completer must keep only PID with the same process name")
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+)
+
+// completeCache is the command complete data of one host, saved to the cache directory.
+type completeCache struct {
+	Server    string    `json:"server"`
+	Timestamp time.Time `json:"timestamp"`
+	Commands  []string  `json:"commands"`
+}
+
+// completeCommand is command for get command complete list.
+const completeCommand = "compgen -c"
+
+// startCompleteRefresher load the command complete cache, and start refresh goroutine.
+// The first refresh runs after the prompt is shown, so the startup is not blocked by slow hosts.
+func (s *shell) startCompleteRefresher() {
+	// get local machine command complete
+	s.GetLocalCommandComplete()
+
+	// read cache file (include expired data. it is updated by refresher)
+	for _, c := range s.getConnects() {
+		cache, err := s.readCompleteCache(c.Name)
+		if err != nil {
+			continue
+		}
+
+		s.completeMutex.Lock()
+		s.cmdCompleteMap[c.Name] = cache.Commands
+		s.completeMutex.Unlock()
+	}
+	s.rebuildCommandComplete()
+
+	go func() {
+		// first update, only expired hosts.
+		s.updateCommandComplete(false)
+
+		ticker := time.NewTicker(s.Options.CompleteRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.updateCommandComplete(false)
+		}
+	}()
+}
+
+// GetLocalCommandComplete get command list local machine.
+func (s *shell) GetLocalCommandComplete() {
+	local, _ := exec.Command("bash", "-c", completeCommand).Output()
+
+	var suggests []prompt.Suggest
+	sc := bufio.NewScanner(bytes.NewReader(local))
+	for sc.Scan() {
+		suggest := prompt.Suggest{
+			Text:        "!" + sc.Text(),
+			Description: "Command. from:localhost",
+		}
+		suggests = append(suggests, suggest)
+	}
+
+	s.completeMutex.Lock()
+	s.localCmdComplete = suggests
+	s.completeMutex.Unlock()
+}
+
+// updateCommandComplete get command list from remote machines in parallel.
+// If force is false, hosts with unexpired cache are skipped.
+func (s *shell) updateCommandComplete(force bool) (count int) {
+	wg := new(sync.WaitGroup)
+	m := new(sync.Mutex)
+
+	for _, c := range s.getConnects() {
+		if !force {
+			cache, err := s.readCompleteCache(c.Name)
+			if err == nil && time.Since(cache.Timestamp) < s.Options.CompleteCacheTTL {
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(c *sConnect) {
+			defer wg.Done()
+
+			data, err := getRemoteOutput(c, completeCommand, s.Options.CompleteTimeout)
+			if err != nil {
+				return
+			}
+
+			var commands []string
+			sc := bufio.NewScanner(bytes.NewReader(data))
+			for sc.Scan() {
+				commands = append(commands, sc.Text())
+			}
+
+			// write cache file
+			s.writeCompleteCache(&completeCache{
+				Server:    c.Name,
+				Timestamp: time.Now(),
+				Commands:  commands,
+			})
+
+			s.completeMutex.Lock()
+			s.cmdCompleteMap[c.Name] = commands
+			s.completeMutex.Unlock()
+
+			m.Lock()
+			count++
+			m.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+
+	s.rebuildCommandComplete()
+
+	return
+}
+
+// rebuildCommandComplete merge local and remote command list to s.CmdComplete.
+// Only currently connected hosts are included.
+func (s *shell) rebuildCommandComplete() {
+	s.completeMutex.Lock()
+	defer s.completeMutex.Unlock()
+
+	// command map
+	cmdMap := map[string][]string{}
+	for _, c := range s.getConnects() {
+		for _, cmd := range s.cmdCompleteMap[c.Name] {
+			cmdMap[cmd] = append(cmdMap[cmd], c.Name)
+		}
+	}
+
+	result := []prompt.Suggest{}
+	result = append(result, s.localCmdComplete...)
+
+	// cmdMap to suggest
+	for cmd, hosts := range cmdMap {
+		// join hosts
+		sort.Strings(hosts)
+		h := strings.Join(hosts, ",")
+
+		// create suggest
+		suggest := prompt.Suggest{
+			Text:        cmd,
+			Description: "Command. from:" + h,
+		}
+
+		result = append(result, suggest)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Text < result[j].Text })
+
+	s.CmdComplete = result
+}
+
+// getCmdComplete return copy of s.CmdComplete.
+func (s *shell) getCmdComplete() []prompt.Suggest {
+	s.completeMutex.Lock()
+	defer s.completeMutex.Unlock()
+
+	return append([]prompt.Suggest{}, s.CmdComplete...)
+}
+
+// getCompleteCachePath return cache file path of server.
+func (s *shell) getCompleteCachePath(server string) string {
+	usr, _ := user.Current()
+	dir := strings.Replace(s.Options.CompleteCacheDir, "~", usr.HomeDir, 1)
+
+	key := md5.Sum([]byte(server))
+	return filepath.Join(dir, fmt.Sprintf("%x.json", key))
+}
+
+// readCompleteCache read cache file of server.
+func (s *shell) readCompleteCache(server string) (cache *completeCache, err error) {
+	data, err := os.ReadFile(s.getCompleteCachePath(server))
+	if err != nil {
+		return
+	}
+
+	cache = new(completeCache)
+	err = json.Unmarshal(data, cache)
+	if err != nil {
+		return
+	}
+
+	// check server name (md5 collision)
+	if cache.Server != server {
+		err = errors.New("cache server name is not match")
+	}
+
+	return
+}
+
+// writeCompleteCache write cache file of server.
+func (s *shell) writeCompleteCache(cache *completeCache) (err error) {
+	path := s.getCompleteCachePath(cache.Server)
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// buildin_rehash is refresh command complete data forcibly.
+func (s *shell) buildin_rehash(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	count := s.updateCommandComplete(true)
+	fmt.Fprintf(stdout, "rehash: updated command complete from %d/%d hosts.\n", count, len(s.getConnects()))
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// getRemoteOutput run command at remote machine, and return stdout.
+// If the command does not finish within timeout, the session is closed and an error is returned.
+func getRemoteOutput(c *sConnect, command string, timeout time.Duration) (data []byte, err error) {
+	// Create session, and output to buffer
+	session, err := c.CreateSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	buf := new(bytes.Buffer)
+	session.Stdout = buf
+
+	// Run command
+	exit := make(chan error, 1)
+	go func() {
+		exit <- session.Run(command)
+	}()
+
+	select {
+	case <-exit:
+		data = buf.Bytes()
+	case <-time.After(timeout):
+		err = fmt.Errorf("%s: command timeout", c.Name)
+	}
+
+	return
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"testing"
+)
+
+// TestQuotePathWord check that only the tilde prefix (`~`, `~user`) is left unquoted, and invalid tilde prefix is rejected.
+func TestQuotePathWord(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{name: "absolute", input: "/etc/", want: `'/etc/'`, wantOK: true},
+		{name: "relative", input: "src/", want: `'src/'`, wantOK: true},
+		{name: "single quote", input: "it's/", want: `'it'\''s/'`, wantOK: true},
+		{name: "command substitution without tilde", input: "$(touch pwn)/", want: `'$(touch pwn)/'`, wantOK: true},
+		{name: "tilde only", input: "~", want: `~`, wantOK: true},
+		{name: "tilde slash", input: "~/", want: `~/`, wantOK: true},
+		{name: "tilde path", input: "~/a b/", want: `~/'a b/'`, wantOK: true},
+		{name: "tilde user", input: "~web-user.1", want: `~web-user.1`, wantOK: true},
+		{name: "tilde user path", input: "~web_user/$x/", want: `~web_user/'$x/'`, wantOK: true},
+		{name: "tilde command substitution", input: "~$(touch pwn)/", wantOK: false},
+		{name: "tilde semicolon", input: "~;reboot;x/", wantOK: false},
+		{name: "tilde semicolon without slash", input: "~;reboot", wantOK: false},
+		{name: "tilde backquote", input: "~`id`", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := quotePathWord(tt.input)
+			if ok != tt.wantOK {
+				t.Fatalf("quotePathWord(%q) ok = %v, want %v", tt.input, ok, tt.wantOK)
+			}
+
+			if ok && got != tt.want {
+				t.Errorf("quotePathWord(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -19,11 +19,41 @@ type PipeSet struct {
 
 // Executor run ssh command in parallel-shell.
 func (s *shell) Executor(command string) {
+	// finish reverse history search (run the found command)
+	s.searching = false
+
+	// multi-line input
+	command, complete := s.readContinuation(command)
+	if !complete {
+		return
+	}
+
+	// signals received at the prompt
+	s.discardSignals()
+
 	// trim space
+	startWithSpace := strings.HasPrefix(command, " ")
 	command = strings.TrimSpace(command)
 
+	// history expansion (%!!, %!N, %!$)
+	command, expanded, err := s.expandHistory(command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+	if expanded {
+		fmt.Println(command)
+	}
+
+	// one-shot dry-run (`%dryrun command...`)
+	dryrunCommand, dryrun := trimDryrunPrefix(command)
+
 	// parse command
-	pslice, _ := parsePipeLine(command)
+	pslice, err := parsePipeLine(dryrunCommand)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
 	if len(pslice) == 0 {
 		return
 	}
@@ -32,7 +62,21 @@ func (s *shell) Executor(command string) {
 	s.latestCommand = command
 
 	// regist history
-	s.PutHistoryFile(command)
+	if !s.checkHistoryIgnore(command, startWithSpace) {
+		s.PutHistoryFile(command)
+		s.addCommandHistory(command)
+	}
+
+	// dry-run (print only)
+	if dryrun || (s.dryrun && !isDryrunCommand(pslice)) {
+		s.printDryrun(os.Stdout, pslice)
+		return
+	}
+
+	// command guard
+	if !s.confirmGuard(pslice) {
+		return
+	}
 
 	// exec pipeline
 	s.parseExecuter(pslice)
@@ -41,84 +85,52 @@ func (s *shell) Executor(command string) {
 }
 
 // parseExecuter assemble and execute the parsed command line.
-// TODO(blacknon): 現状はパイプにしか対応していないので、`&&`や`||`にも対応できるよう変更する(v0.6.1)
+// `&&` and `||` between pipelines are evaluated by the exit status of before pipeline (the status of the last element, not pipefail).
 // TODO(blacknon): !commandで1プロセス、!!commandでssh接続ごとにプロセスを生成してローカルのコマンドを実行するように変更(v0.6.1)
 func (s *shell) parseExecuter(pslice [][]pipeLine) {
 	// Create History
-	s.History[s.Count] = map[string]*shellHistory{}
+	s.newHistoryResult()
+
+	// exit status of before pipeline
+	status := true
+
+	// status panel (only when remote command is included)
+	if s.statusPanel && hasRemotePipeLine(pslice) {
+		stop := s.startStatusPanel()
+		defer stop()
+	}
 
 	// for pslice
 	for _, pline := range pslice {
-		// count pipe num
-		pnum := countPipeSet(pline, "|")
-
-		// create pipe set
-		pipes := createPipeSet(pnum)
+		// check condition (`&&`, `||`)
+		switch {
+		case pline[0].Condition == "&&" && !status:
+			continue
+		case pline[0].Condition == "||" && status:
+			continue
+		}
 
 		// join pipe set
 		pline = joinPipeLine(pline)
 
-		// printout run command
-		fmt.Printf("[Command:%s ]\n", joinPipeLineSlice(pline))
-
-		// pipe counter
-		var n int
-
-		// create channel
-		ch := make(chan bool)
-		defer close(ch)
-
-		kill := make(chan bool)
-		defer close(kill)
-
-		for i, p := range pline {
-			// declare nextPipeLine
-			var bp pipeLine
-
-			// declare in,out
-			var in *io.PipeReader
-			var out *io.PipeWriter
-
-			// get next pipe line
-			if i > 0 {
-				bp = pline[i-1]
-			}
-
-			// set stdin
-			// If the before delimiter is a pipe, set the stdin before io.PipeReader.
-			if bp.Oprator == "|" {
-				in = pipes[n-1].in
-			}
-
-			// set stdout
-			// If the delimiter is a pipe, set the stdout output a io.PipeWriter.
-			if p.Oprator == "|" {
-				out = pipes[n].out
-
-				// add pipe num
-				n++
-			}
+		// background job (`command &`)
+		if pline[0].Background {
+			s.startJob(pline)
+			status = true
+			continue
+		}
 
-			// exec pipeline
-			go s.run(p, in, out, ch, kill)
+		// execution strategy (`%canary`, `%rolling`, `%serial`)
+		if i := strategyIndex(pline); i >= 0 {
+			status = s.runStrategy(pline, i)
+			continue
 		}
 
-		// get and send kill
-		killExit := make(chan bool)
-		defer close(killExit)
-		go func(sig chan os.Signal) {
-			select {
-			case <-sig:
-				for i := 0; i < len(pline); i++ {
-					kill <- true
-				}
-			case <-killExit:
-				return
-			}
-		}(s.Signal)
+		// printout run command
+		fmt.Printf("[Command:%s ]\n", joinPipeLineSlice(pline))
 
-		// wait channel
-		s.wait(len(pline), ch)
+		// exec pipeline
+		status = s.runForeground(pline)
 	}
 
 	// add s.Count
@@ -141,6 +153,113 @@ func (s *shell) parseExecuter(pslice [][]pipeLine) {
 	}
 }
 
+// runForeground run pline in foreground, and wait for it to finish.
+// It returns the exit status of pline (the status of the last element, same as bash).
+func (s *shell) runForeground(pline []pipeLine) bool {
+	// create channel
+	ch := make(chan bool)
+	defer close(ch)
+
+	kill := make(chan bool)
+	defer close(kill)
+
+	// exec pipeline
+	s.foreground.reset()
+	s.runPipeLine(pline, ch, kill)
+
+	// get and send kill (1st Ctrl-C select hosts, 2nd Ctrl-C kill all)
+	killExit := make(chan bool)
+	interruptExit := make(chan bool)
+	go func() {
+		s.handleInterrupt(len(pline), kill, killExit)
+		close(interruptExit)
+	}()
+
+	// wait channel
+	status := s.wait(len(pline), ch)
+
+	// stop interrupt handler, and wait for it.
+	// the next reader of s.Signal (e.g. pause of `%rolling`) must not race with it.
+	close(killExit)
+	<-interruptExit
+
+	return status
+}
+
+// runPipeLine connect each element of pline with pipe, and run them.
+// The exit status of each element is sent to ch. Only the last element can send failure.
+func (s *shell) runPipeLine(pline []pipeLine, ch chan<- bool, kill chan bool) {
+	// count pipe num
+	pnum := countPipeSet(pline, "|")
+
+	// create pipe set
+	pipes := createPipeSet(pnum)
+
+	// pipe counter
+	var n int
+
+	for i, p := range pline {
+		// declare nextPipeLine
+		var bp pipeLine
+
+		// declare in,out
+		var in *io.PipeReader
+		var out *io.PipeWriter
+
+		// get next pipe line
+		if i > 0 {
+			bp = pline[i-1]
+		}
+
+		// set stdin
+		// If the before delimiter is a pipe, set the stdin before io.PipeReader.
+		if bp.Oprator == "|" {
+			in = pipes[n-1].in
+		}
+
+		// set stdout
+		// If the delimiter is a pipe, set the stdout output a io.PipeWriter.
+		if p.Oprator == "|" {
+			out = pipes[n].out
+
+			// add pipe num
+			n++
+		}
+
+		// exit status of pipeline is the status of the last element (same as bash, not pipefail).
+		// the status of the other elements is sent as success.
+		stageCh := ch
+		if i < len(pline)-1 {
+			c := make(chan bool)
+			go func() {
+				<-c
+				ch <- true
+			}()
+			stageCh = c
+		}
+
+		// exec pipeline
+		go s.run(p, in, out, stageCh, kill)
+	}
+}
+
+// hasRemotePipeLine return true if pslice include remote command (except background job).
+func hasRemotePipeLine(pslice [][]pipeLine) bool {
+	for _, pline := range pslice {
+		if pline[0].Background {
+			continue
+		}
+
+		for _, p := range pline {
+			if !p.isLocal() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // countPipeSet count delimiter in pslice.
 func countPipeSet(pline []pipeLine, del string) (count int) {
 	for _, p := range pline {
@@ -0,0 +1,541 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+// Port forwarding.
+//   - -L [bind_address:]port:host:hostport ... local port forward. N-th host listen on port + N.
+//   - -R [bind_address:]port:host:hostport ... remote port forward. each host listen on port.
+//   - -R port                              ... reverse dynamic forward (SOCKS5). each host listen on port.
+//   - -D port                              ... dynamic forward (SOCKS5). N-th host listen on port + N.
+//   - -r port                              ... reverse dynamic forward (HTTP proxy). each host listen on port.
+// Since local listeners can not share the port, the port of local listener is shifted by index of host.
+
+// forward modes
+const (
+	forwardLocal              = "L"
+	forwardRemote             = "R"
+	forwardDynamic            = "D"
+	forwardReverseDynamic     = "RD"
+	forwardHTTPReverseDynamic = "RH"
+)
+
+// portForward is port forwarding of a host.
+type portForward struct {
+	ID   int
+	Host string
+	Mode string
+
+	// Local is local address. listen address of L and D, connect address of R.
+	Local string
+
+	// Remote is remote address. connect address of L, listen address of R, RD and RH.
+	Remote string
+
+	listener net.Listener
+
+	// statistics
+	bytesIn  int64 // bytes received from connections accepted by listener
+	bytesOut int64 // bytes sent to connections accepted by listener
+	conns    map[*forwardConn]bool
+	mutex    *sync.Mutex
+}
+
+// forwardListener is listener of portForward, that count connections and bytes.
+type forwardListener struct {
+	net.Listener
+	fw *portForward
+}
+
+// forwardConn is connection accepted by forwardListener.
+type forwardConn struct {
+	net.Conn
+	fw   *portForward
+	once sync.Once
+}
+
+// forwardSpec is port forwarding specified by options. Port of local listener is not shifted yet.
+type forwardSpec struct {
+	Mode   string
+	Local  string
+	Remote string
+}
+
+// getForwardSpecs return port forwardings specified by options (`-L`, `-R`, `-D`, `-r`).
+func (s *shell) getForwardSpecs() (specs []forwardSpec) {
+	r := s.Run
+
+	for _, fw := range r.PortForward {
+		mode := forwardLocal
+		if fw.Mode == "R" {
+			mode = forwardRemote
+		}
+		specs = append(specs, forwardSpec{Mode: mode, Local: fw.Local, Remote: fw.Remote})
+	}
+
+	if r.DynamicPortForward != "" {
+		specs = append(specs, forwardSpec{Mode: forwardDynamic, Local: net.JoinHostPort("localhost", r.DynamicPortForward)})
+	}
+
+	if r.ReverseDynamicPortForward != "" {
+		specs = append(specs, forwardSpec{Mode: forwardReverseDynamic, Remote: net.JoinHostPort("localhost", r.ReverseDynamicPortForward)})
+	}
+
+	if r.HTTPReverseDynamicPortForward != "" {
+		specs = append(specs, forwardSpec{Mode: forwardHTTPReverseDynamic, Remote: net.JoinHostPort("localhost", r.HTTPReverseDynamicPortForward)})
+	}
+
+	return
+}
+
+// startPortForwards start port forwardings specified by options on connects, and print them.
+func (s *shell) startPortForwards(connects []*sConnect) {
+	specs := s.getForwardSpecs()
+	if len(specs) == 0 {
+		return
+	}
+
+	var started []*portForward
+	for _, c := range connects {
+		forwards, _ := openPortForwards(c, specs, s.nextForwardIndex())
+		started = append(started, forwards...)
+	}
+
+	s.registerPortForwards(started)
+	printPortForwards(os.Stderr, started, false)
+}
+
+// openPortForwards start port forwardings of specs on connect c. Port of local listener is shifted by index.
+// Errors are printed, and ok is false if any forwarding can not be started.
+func openPortForwards(c *sConnect, specs []forwardSpec, index int) (started []*portForward, ok bool) {
+	ok = true
+	for _, spec := range specs {
+		fw := newPortForward(c.Name, spec)
+
+		// shift port of local listener
+		if fw.Mode == forwardLocal || fw.Mode == forwardDynamic {
+			local, err := shiftPort(fw.Local, index)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: port forward %s: %s\n", c.Name, err)
+				ok = false
+				continue
+			}
+			fw.Local = local
+		}
+
+		if err := fw.start(c); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: port forward %s: %s\n", c.Name, err)
+			ok = false
+			continue
+		}
+
+		started = append(started, fw)
+	}
+
+	return
+}
+
+// registerPortForwards set ID to forwards, and add them to s.forwards.
+func (s *shell) registerPortForwards(forwards []*portForward) {
+	s.forwardMutex.Lock()
+	defer s.forwardMutex.Unlock()
+
+	for _, fw := range forwards {
+		s.forwardID++
+		fw.ID = s.forwardID
+		s.forwards = append(s.forwards, fw)
+	}
+}
+
+// getPortForwards return copy of port forwardings.
+func (s *shell) getPortForwards() []*portForward {
+	s.forwardMutex.Lock()
+	defer s.forwardMutex.Unlock()
+
+	return append([]*portForward{}, s.forwards...)
+}
+
+// deletePortForward stop port forwarding of id, and delete it.
+func (s *shell) deletePortForward(id int) (fw *portForward, err error) {
+	s.forwardMutex.Lock()
+	defer s.forwardMutex.Unlock()
+
+	for i, f := range s.forwards {
+		if f.ID == id {
+			f.stop()
+			s.forwards = append(s.forwards[:i], s.forwards[i+1:]...)
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such forward `%d`", id)
+}
+
+// nextForwardIndex return index of host for port shift. It is increased for each host, including hosts added by `%add`.
+func (s *shell) nextForwardIndex() int {
+	s.forwardMutex.Lock()
+	defer s.forwardMutex.Unlock()
+
+	index := s.forwardIndex
+	s.forwardIndex++
+
+	return index
+}
+
+// stopPortForwards stop port forwardings of host.
+func (s *shell) stopPortForwards(name string) {
+	s.forwardMutex.Lock()
+	defer s.forwardMutex.Unlock()
+
+	result := []*portForward{}
+	for _, fw := range s.forwards {
+		if fw.Host == name {
+			fw.stop()
+			continue
+		}
+		result = append(result, fw)
+	}
+	s.forwards = result
+}
+
+// shiftPort return addr (`host:port`) that port is added n.
+func shiftPort(addr string, n int) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port `%s`", port)
+	}
+
+	if p+n > 65535 {
+		return "", fmt.Errorf("port %d is out of range", p+n)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(p+n)), nil
+}
+
+// printPortForwards print table of port forwardings. If stats is true, connections and bytes are printed.
+func printPortForwards(w io.Writer, forwards []*portForward, stats bool) {
+	if len(forwards) == 0 {
+		return
+	}
+
+	nameWidth := 4
+	for _, fw := range forwards {
+		if len(fw.Host) > nameWidth {
+			nameWidth = len(fw.Host)
+		}
+	}
+
+	if stats {
+		fmt.Fprintf(w, "%-3s  %-*s  %-4s  %-21s  %-30s  %5s  %10s  %10s\n", "ID", nameWidth, "HOST", "MODE", "LISTEN", "CONNECT", "CONNS", "IN", "OUT")
+	} else {
+		fmt.Fprintf(w, "%-3s  %-*s  %-4s  %-21s  %s\n", "ID", nameWidth, "HOST", "MODE", "LISTEN", "CONNECT")
+	}
+
+	for _, fw := range forwards {
+		listen, connect := fw.Addrs()
+		if stats {
+			conns, in, out := fw.Stats()
+			fmt.Fprintf(w, "%-3d  %-*s  %-4s  %-21s  %-30s  %5d  %10s  %10s\n", fw.ID, nameWidth, fw.Host, fw.Mode, listen, connect, conns, formatBytes(float64(in)), formatBytes(float64(out)))
+		} else {
+			fmt.Fprintf(w, "%-3d  %-*s  %-4s  %-21s  %s\n", fw.ID, nameWidth, fw.Host, fw.Mode, listen, connect)
+		}
+	}
+}
+
+// Addrs return listen and connect address of fw for display. Remote address is prefixed with host.
+func (fw *portForward) Addrs() (listen, connect string) {
+	remote := fw.Host + ":" + fw.Remote
+
+	switch fw.Mode {
+	case forwardLocal:
+		return fw.Local, remote
+	case forwardRemote:
+		return remote, fw.Local
+	case forwardDynamic:
+		return fw.Local, fw.Host + " (socks5)"
+	case forwardReverseDynamic:
+		return remote, "local (socks5)"
+	case forwardHTTPReverseDynamic:
+		return remote, "local (http)"
+	}
+
+	return
+}
+
+// newPortForward return portForward of spec on host.
+func newPortForward(host string, spec forwardSpec) *portForward {
+	return &portForward{
+		Host:   host,
+		Mode:   spec.Mode,
+		Local:  spec.Local,
+		Remote: spec.Remote,
+		conns:  map[*forwardConn]bool{},
+		mutex:  new(sync.Mutex),
+	}
+}
+
+// start listen and start forwarding of fw on connect c.
+func (fw *portForward) start(c *sConnect) (err error) {
+	client := c.Connect.Client
+
+	// listen
+	var listener net.Listener
+	switch fw.Mode {
+	case forwardLocal, forwardDynamic:
+		listener, err = net.Listen("tcp", fw.Local)
+	default:
+		listener, err = client.Listen("tcp", fw.Remote)
+	}
+	if err != nil {
+		return
+	}
+	fw.listener = &forwardListener{Listener: listener, fw: fw}
+
+	// dial of remote side and local side
+	remoteDial := func(network, addr string) (net.Conn, error) {
+		return client.Dial(network, addr)
+	}
+	localDial := func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, 10*time.Second)
+	}
+
+	switch fw.Mode {
+	case forwardLocal:
+		go serveForward(fw.listener, func() (net.Conn, error) { return remoteDial("tcp", fw.Remote) })
+	case forwardRemote:
+		go serveForward(fw.listener, func() (net.Conn, error) { return localDial("tcp", fw.Local) })
+	case forwardDynamic:
+		go serveSocks5(fw.listener, remoteDial)
+	case forwardReverseDynamic:
+		go serveSocks5(fw.listener, localDial)
+	case forwardHTTPReverseDynamic:
+		go serveHTTPProxy(fw.listener, localDial)
+	}
+
+	return
+}
+
+// stop close listener and active connections of fw.
+func (fw *portForward) stop() {
+	if fw.listener != nil {
+		fw.listener.Close()
+	}
+
+	fw.mutex.Lock()
+	var conns []*forwardConn
+	for conn := range fw.conns {
+		conns = append(conns, conn)
+	}
+	fw.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Stats return number of active connections, and bytes received and sent.
+func (fw *portForward) Stats() (conns int, in, out int64) {
+	fw.mutex.Lock()
+	conns = len(fw.conns)
+	fw.mutex.Unlock()
+
+	return conns, atomic.LoadInt64(&fw.bytesIn), atomic.LoadInt64(&fw.bytesOut)
+}
+
+// Accept accept connection, and count it as active connection of forward.
+func (l *forwardListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &forwardConn{Conn: conn, fw: l.fw}
+
+	l.fw.mutex.Lock()
+	l.fw.conns[c] = true
+	l.fw.mutex.Unlock()
+
+	return c, nil
+}
+
+// Read count bytes received.
+func (c *forwardConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	atomic.AddInt64(&c.fw.bytesIn, int64(n))
+	return
+}
+
+// Write count bytes sent.
+func (c *forwardConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	atomic.AddInt64(&c.fw.bytesOut, int64(n))
+	return
+}
+
+// Close close connection, and remove it from active connections.
+func (c *forwardConn) Close() error {
+	c.once.Do(func() {
+		c.fw.mutex.Lock()
+		delete(c.fw.conns, c)
+		c.fw.mutex.Unlock()
+	})
+
+	return c.Conn.Close()
+}
+
+// serveForward accept connection of listener, and connect it to the connection of dial.
+func serveForward(listener net.Listener, dial func() (net.Conn, error)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			target, err := dial()
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			copyConn(conn, target)
+		}(conn)
+	}
+}
+
+// copyConn copy data between a and b, and close both at the end.
+func copyConn(a, b net.Conn) {
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+	}()
+
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+	}()
+
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+// socks5Resolver resolve name at the destination side, not local.
+type socks5Resolver struct{}
+
+// Resolve return no address, so that name is passed to dial as it is.
+func (socks5Resolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return ctx, nil, nil
+}
+
+// serveSocks5 serve SOCKS5 proxy on listener. Connection to destination is created by dial.
+func serveSocks5(listener net.Listener, dial func(network, addr string) (net.Conn, error)) {
+	conf := &socks5.Config{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(network, addr)
+		},
+		Resolver: socks5Resolver{},
+		Logger:   log.New(io.Discard, "", 0),
+	}
+
+	server, err := socks5.New(conf)
+	if err != nil {
+		listener.Close()
+		return
+	}
+
+	server.Serve(listener)
+}
+
+// serveHTTPProxy serve HTTP proxy on listener. Connection to destination is created by dial.
+func serveHTTPProxy(listener net.Listener, dial func(network, addr string) (net.Conn, error)) {
+	transport := &http.Transport{Dial: dial}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// https (CONNECT)
+			if r.Method == http.MethodConnect {
+				target, err := dial("tcp", r.Host)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+
+				hijacker, ok := w.(http.Hijacker)
+				if !ok {
+					target.Close()
+					http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+				conn, buf, err := hijacker.Hijack()
+				if err != nil {
+					target.Close()
+					return
+				}
+
+				// data already read by http server
+				if n := buf.Reader.Buffered(); n > 0 {
+					data, _ := buf.Reader.Peek(n)
+					target.Write(data)
+				}
+
+				copyConn(conn, target)
+				return
+			}
+
+			// http
+			r.RequestURI = ""
+			if r.URL.Scheme == "" {
+				r.URL.Scheme = "http"
+			}
+			if r.URL.Host == "" {
+				r.URL.Host = r.Host
+			}
+
+			resp, err := transport.RoundTrip(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			defer resp.Body.Close()
+
+			for key, values := range resp.Header {
+				for _, v := range values {
+					w.Header().Add(key, v)
+				}
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+
+	server.Serve(listener)
+}
@@ -0,0 +1,318 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blacknon/lsshell/config"
+)
+
+// Command guard.
+// Remote command matching `commands` (regex) in `[guard]`, or run on hosts with one of `tags` in `[guard]`,
+// requires typed confirmation (`yes`) listing the affected hosts, before execution.
+//
+// Dry-run.
+// `%dryrun` print what would run on each host (after target expansion), without execution.
+//   - %dryrun command... ... print the command line, instead of run
+//   - %dryrun [on|off]   ... switch dry-run mode. While on, all command lines are printed only.
+
+// guardConfirmWord is the word to be typed to run guarded command.
+const guardConfirmWord = "yes"
+
+// dryrunPrefixRegex match `%dryrun` prefix of command line.
+var dryrunPrefixRegex = regexp.MustCompile(`^%dryrun\s+`)
+
+// commandGuard is compiled `[guard]` settings.
+type commandGuard struct {
+	commands []*regexp.Regexp
+	tags     []string
+}
+
+// newCommandGuard return commandGuard of c. Invalid regex is reported, and ignored.
+func newCommandGuard(c config.GuardConfig) *commandGuard {
+	g := &commandGuard{tags: c.Tags}
+	for _, r := range c.Commands {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid guard command `%s` in config: %s\n", r, err)
+			continue
+		}
+
+		g.commands = append(g.commands, re)
+	}
+
+	return g
+}
+
+// guardMatch is remote command matched by guard rules.
+type guardMatch struct {
+	Command string
+	Rules   []string // matched rules
+	Hosts   []string // affected hosts
+}
+
+// checkGuard return remote commands of pline (joined) matched by guard rules.
+// Hosts of command matching regex are all target hosts, and hosts of tag rule are target hosts with the tag.
+func (s *shell) checkGuard(pline []pipeLine) (matches []guardMatch) {
+	if s.guard == nil {
+		return
+	}
+
+	for _, p := range pline {
+		if p.isLocal() {
+			continue
+		}
+
+		// unknown target is reported at run
+		connects, err := s.getTargetConnects(p.Targets)
+		if err != nil {
+			continue
+		}
+
+		m := guardMatch{Command: strings.TrimRight(p.Command(), "\n")}
+		affected := map[string]bool{}
+
+		for _, re := range s.guard.commands {
+			if re.MatchString(p.Command()) {
+				m.Rules = append(m.Rules, fmt.Sprintf("command `%s`", re.String()))
+				for _, c := range connects {
+					affected[c.Name] = true
+				}
+			}
+		}
+
+		for _, tag := range s.guard.tags {
+			found := false
+			for _, c := range connects {
+				if s.ExtConfig.HasTags(c.Name, []string{tag}) {
+					affected[c.Name] = true
+					found = true
+				}
+			}
+
+			if found {
+				m.Rules = append(m.Rules, fmt.Sprintf("tag `%s`", tag))
+			}
+		}
+
+		if len(m.Rules) == 0 {
+			continue
+		}
+
+		for _, c := range connects {
+			if affected[c.Name] {
+				m.Hosts = append(m.Hosts, c.Name)
+			}
+		}
+
+		matches = append(matches, m)
+	}
+
+	return
+}
+
+// confirmGuard check pslice with guard rules, and ask typed confirmation if matched.
+// It returns true if command line can be run.
+func (s *shell) confirmGuard(pslice [][]pipeLine) bool {
+	var matches []guardMatch
+	for _, pline := range pslice {
+		matches = append(matches, s.checkGuard(joinPipeLine(pline))...)
+	}
+
+	if len(matches) == 0 {
+		return true
+	}
+
+	fmt.Fprintf(os.Stderr, "Guard: this command line requires confirmation.\n")
+	for _, m := range matches {
+		fmt.Fprintf(os.Stderr, "  command : %s\n", m.Command)
+		fmt.Fprintf(os.Stderr, "  matched : %s\n", strings.Join(m.Rules, ", "))
+		fmt.Fprintf(os.Stderr, "  hosts   : %s (%d hosts)\n", strings.Join(m.Hosts, ","), len(m.Hosts))
+	}
+	fmt.Fprintf(os.Stderr, "Type `%s` to run (anything else is cancel): ", guardConfirmWord)
+
+	line, ok := readTerminalLine()
+	if !ok || line != guardConfirmWord {
+		fmt.Fprintf(os.Stderr, "Canceled.\n")
+		return false
+	}
+
+	return true
+}
+
+// trimDryrunPrefix return command without `%dryrun` prefix, and true if command has it.
+// `%dryrun on` and `%dryrun off` are not prefix.
+func trimDryrunPrefix(command string) (string, bool) {
+	loc := dryrunPrefixRegex.FindStringIndex(command)
+	if loc == nil {
+		return command, false
+	}
+
+	rest := command[loc[1]:]
+	switch strings.TrimSpace(rest) {
+	case "on", "off":
+		return command, false
+	}
+
+	return rest, true
+}
+
+// isDryrunCommand return true if pslice is only `%dryrun` build-in command (it is run in dry-run mode).
+func isDryrunCommand(pslice [][]pipeLine) bool {
+	return len(pslice) == 1 && len(pslice[0]) == 1 && len(pslice[0][0].Args) > 0 && pslice[0][0].Args[0] == "%dryrun"
+}
+
+// printDryrun print what would run on each host for pslice, without execution.
+func (s *shell) printDryrun(w io.Writer, pslice [][]pipeLine) {
+	for _, pline := range pslice {
+		pline = joinPipeLine(pline)
+
+		fmt.Fprintf(w, "[Dryrun:%s ]\n", joinPipeLineSlice(pline))
+
+		// pipeline attributes
+		var attrs []string
+		switch pline[0].Condition {
+		case "&&":
+			attrs = append(attrs, "run if before succeeded")
+		case "||":
+			attrs = append(attrs, "run if before failed")
+		}
+		if pline[0].Background {
+			attrs = append(attrs, "background job")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(w, "  (%s)\n", strings.Join(attrs, ", "))
+		}
+
+		for i, p := range pline {
+			command := strings.TrimRight(p.Command(), "\n")
+
+			// local or build-in command
+			if p.isLocal() {
+				kind := "local"
+				if checkBuildInCommand(p.Args[0]) {
+					kind = "build-in"
+				}
+
+				fmt.Fprintf(w, "  [%d] %s\n", i+1, kind)
+				fmt.Fprintf(w, "      %s\n", command)
+				continue
+			}
+
+			// remote command
+			connects, err := s.getTargetConnects(p.Targets)
+			if err != nil {
+				fmt.Fprintf(w, "  [%d] remote\n", i+1)
+				fmt.Fprintf(w, "      Error: %s\n", err)
+				continue
+			}
+
+			var names []string
+			for _, c := range connects {
+				names = append(names, c.Name)
+			}
+
+			opts := ""
+			if o := s.dryrunOptions(p); len(o) > 0 {
+				opts = " (" + strings.Join(o, ", ") + ")"
+			}
+
+			fmt.Fprintf(w, "  [%d] remote on %s%s\n", i+1, strings.Join(names, ","), opts)
+			for _, c := range connects {
+				fmt.Fprintf(w, "      %s: %s\n", c.Name, c.remoteCommand(command))
+			}
+
+			if p.Strategy != "" {
+				var batches []string
+				for _, b := range splitBatches(connects, p) {
+					batches = append(batches, "["+strings.Join(getConnectNames(b), ",")+"]")
+				}
+				fmt.Fprintf(w, "      batches: %s\n", strings.Join(batches, " "))
+			}
+
+			for _, m := range s.checkGuard([]pipeLine{p}) {
+				fmt.Fprintf(w, "      guard: %s on %s (confirmation required)\n", strings.Join(m.Rules, ", "), strings.Join(m.Hosts, ","))
+			}
+		}
+	}
+}
+
+// dryrunOptions return options of remote pipeLine p (timeout, strategy, stdin mode, local redirect) for dry-run.
+func (s *shell) dryrunOptions(p pipeLine) (opts []string) {
+	timeout, detach := p.Timeout, p.TimeoutDetach
+	if timeout == 0 {
+		timeout, detach = s.Options.CommandTimeout, s.Options.CommandTimeoutDetach
+	}
+	if timeout > 0 {
+		opt := "timeout " + timeout.Round(time.Millisecond).String()
+		if detach {
+			opt = opt + " detach"
+		}
+		opts = append(opts, opt)
+	}
+
+	if p.Strategy != "" {
+		opts = append(opts, s.strategyDescription(p))
+	}
+
+	if p.StdinMode != "" {
+		opts = append(opts, "stdin "+p.StdinMode)
+	}
+
+	if p.RedirectFile != "" {
+		redirect := "%> "
+		if p.RedirectAppend {
+			redirect = "%>> "
+		}
+		opts = append(opts, redirect+p.RedirectFile)
+	}
+
+	return
+}
+
+// buildin_dryrun switch dry-run mode.
+// example:
+//   - %dryrun       ... toggle
+//   - %dryrun on
+//   - %dryrun off
+func (s *shell) buildin_dryrun(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	switch {
+	case len(args) == 0:
+		s.dryrun = !s.dryrun
+	case len(args) == 1 && args[0] == "on":
+		s.dryrun = true
+	case len(args) == 1 && args[0] == "off":
+		s.dryrun = false
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %%dryrun: invalid argument. usage: %%dryrun [on|off] or %%dryrun command...\n")
+		status = false
+	}
+
+	if status {
+		mode := "off"
+		if s.dryrun {
+			mode = "on"
+		}
+		fmt.Fprintf(stdout, "dryrun: %s\n", mode)
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
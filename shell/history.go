@@ -3,7 +3,6 @@
 // that can be found in the LICENSE file.
 
 // TODO: ResultにOutputのほか、Stdout・Stderrを追加する(あとで分けて利用できるようにするため)
-// TODO: historyで、重複履歴をshellのhistory追加しないオプションの実装(ただし、outputは追加する)
 
 package shell
 
@@ -13,8 +12,8 @@ import (
 	"io"
 	"os"
 	"os/user"
+	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/blacknon/lssh/output"
@@ -25,29 +24,33 @@ type shellHistory struct {
 	Command   string
 	Result    string
 	Output    *output.Output
+
+	// Batch is the batch of execution strategy (`%canary`, `%rolling`, `%serial`). nil is not batch.
+	Batch *batchInfo
 }
 
-func (s *shell) NewHistoryWriter(server string, output *output.Output, m *sync.Mutex) *io.PipeWriter {
+// NewHistoryWriter return writer to record output of server to History of count.
+// batch is the batch of execution strategy running the command (nil is not batch).
+func (s *shell) NewHistoryWriter(count int, server string, output *output.Output, batch *batchInfo) *io.PipeWriter {
 	// craete pShellHistory struct
 	psh := &shellHistory{
 		Command:   s.latestCommand,
 		Timestamp: time.Now().Format("2006/01/02_15:04:05 "), // "yyyy/mm/dd_HH:MM:SS "
 		Output:    output,
+		Batch:     batch,
 	}
 
 	// create io.PipeReader, io.PipeWriter
 	r, w := io.Pipe()
 
 	// output Struct
-	go s.shellHistoryPrint(psh, server, r, m)
+	go s.shellHistoryPrint(psh, count, server, r)
 
 	// return io.PipeWriter
 	return w
 }
 
-func (s *shell) shellHistoryPrint(psh *shellHistory, server string, r *io.PipeReader, m *sync.Mutex) {
-	count := s.Count
-
+func (s *shell) shellHistoryPrint(psh *shellHistory, count int, server string, r *io.PipeReader) {
 	var result string
 	sc := bufio.NewScanner(r)
 loop:
@@ -71,36 +74,95 @@ loop:
 	psh.Result = result
 
 	// Add History
-	m.Lock()
+	s.resultMutex.Lock()
 	s.History[count][server] = psh
-	m.Unlock()
+	s.resultMutex.Unlock()
+}
+
+// newHistoryResult create History of s.Count.
+func (s *shell) newHistoryResult() {
+	s.resultMutex.Lock()
+	defer s.resultMutex.Unlock()
+
+	s.History[s.Count] = map[string]*shellHistory{}
+}
+
+// getHistoryResult return copy of History of num (key: server name).
+// History is written by background jobs, so it is copied with lock.
+func (s *shell) getHistoryResult(num int) (result map[string]*shellHistory) {
+	s.resultMutex.Lock()
+	defer s.resultMutex.Unlock()
+
+	result = map[string]*shellHistory{}
+	for k, v := range s.History[num] {
+		result[k] = v
+	}
+
+	return
+}
+
+// historyTimestampRegex is regex of timestamp at the beginning of history line.
+// Timestamp is followed by space (command as is), or tab (escaped multi-line command).
+var historyTimestampRegex = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}_\d{2}:\d{2}:\d{2}[ \t]`)
+
+// historyEscaper escape multi-line command to one line of history file, and historyUnescaper is the reverse.
+var (
+	historyEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	historyUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+)
+
+// formatHistoryLine return line of history file of command.
+// Multi-line command is escaped to one line (`\` -> `\\`, newline -> `\n`), and separated from timestamp by tab.
+func formatHistoryLine(timestamp, command string) string {
+	if !strings.Contains(command, "\n") {
+		return timestamp + " " + command
+	}
+
+	return timestamp + "\t" + historyEscaper.Replace(command)
 }
 
-// GetHistoryFromFile return []History from historyfile
+// parseHistoryLine return timestamp and command of line in history file.
+// ok is false if line has no timestamp (continuation line of multi-line command, written by old version).
+func parseHistoryLine(line string) (timestamp, command string, ok bool) {
+	if !historyTimestampRegex.MatchString(line) {
+		return "", "", false
+	}
+
+	// length of "yyyy/mm/dd_HH:MM:SS"
+	n := len("2006/01/02_15:04:05")
+	timestamp, command = line[:n], line[n+1:]
+	if line[n] == '\t' {
+		command = historyUnescaper.Replace(command)
+	}
+
+	return timestamp, command, true
+}
+
+// GetHistoryFromFile return []History from historyfile.
+// Lines without timestamp (written by old version) are continuation of the multi-line command.
 func (s *shell) GetHistoryFromFile() (data []shellHistory, err error) {
 	// user path
-	usr, _ := user.Current()
-	histfile := strings.Replace(s.HistoryFile, "~", usr.HomeDir, 1)
+	histfile := s.getHistoryFilePath()
 
-	// Open history file
-	file, err := os.OpenFile(histfile, os.O_RDONLY, 0600)
+	lines, err := readLines(histfile)
 	if err != nil {
 		return
 	}
-	defer file.Close()
 
-	sc := bufio.NewScanner(file)
-	for sc.Scan() {
-		line := sc.Text()
-		text := strings.SplitN(line, " ", 2)
+	for _, line := range lines {
+		timestamp, command, ok := parseHistoryLine(line)
 
-		if len(text) < 2 {
+		// continuation line of multi-line command (without timestamp)
+		if !ok {
+			if len(data) > 0 {
+				data[len(data)-1].Command += "\n" + line
+			}
 			continue
 		}
 
 		d := shellHistory{
-			Timestamp: text[0],
-			Command:   text[1],
+			Timestamp: timestamp,
+			Command:   command,
 			Result:    "",
 		}
 
@@ -109,28 +171,211 @@ func (s *shell) GetHistoryFromFile() (data []shellHistory, err error) {
 	return
 }
 
+// checkHistoryIgnore return true if command should not be saved to history.
+// startWithSpace is whether the input started with space. Output result is recorded regardless of this.
+func (s *shell) checkHistoryIgnore(command string, startWithSpace bool) bool {
+	control := map[string]bool{}
+	for _, c := range strings.Split(s.ExtConfig.Shell.HistControl, ":") {
+		switch c {
+		case "ignoreboth":
+			control["ignoredups"] = true
+			control["ignorespace"] = true
+		default:
+			control[c] = true
+		}
+	}
+
+	// ignorespace
+	if control["ignorespace"] && startWithSpace {
+		return true
+	}
+
+	// ignoredups
+	if control["ignoredups"] {
+		histories := s.getCommandHistory()
+		if len(histories) > 0 && histories[len(histories)-1].Command == command {
+			return true
+		}
+	}
+
+	// histignore
+	for _, re := range s.histIgnore {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileHistIgnore return compiled regex of histignore. Invalid regex is reported, and ignored.
+func compileHistIgnore(patterns []string) (result []*regexp.Regexp) {
+	for _, r := range patterns {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid histignore `%s` in config: %s\n", r, err)
+			continue
+		}
+
+		result = append(result, re)
+	}
+
+	return
+}
+
+// getHistoryFilePath return full path of s.HistoryFile.
+func (s *shell) getHistoryFilePath() string {
+	usr, _ := user.Current()
+	return strings.Replace(s.HistoryFile, "~", usr.HomeDir, 1)
+}
+
 // PutHistoryFile put history text to s.HistoryFile
 // ex.) write history(history file format)
 //
 //	YYYY-mm-dd_HH:MM:SS command...
-//	YYYY-mm-dd_HH:MM:SS command...
+//	YYYY-mm-dd_HH:MM:SS<TAB>escaped multi-line command...
 //	...
+//
+// Multi-line command (here-document, etc...) is escaped to one line, see formatHistoryLine.
+// The history file is locked while writing, so concurrent lsshell do not interleave lines.
 func (s *shell) PutHistoryFile(cmd string) (err error) {
 	// user path
-	usr, _ := user.Current()
-	histfile := strings.Replace(s.HistoryFile, "~", usr.HomeDir, 1)
+	histfile := s.getHistoryFilePath()
+
+	// lock history file
+	unlock, err := lockFile(histfile + ".lock")
+	if err != nil {
+		return
+	}
+	defer unlock()
 
 	// Open history file
 	file, err := os.OpenFile(histfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return
 	}
-	defer file.Close()
 
 	// Get Time
-	timestamp := time.Now().Format("2006/01/02_15:04:05 ") // "yyyy/mm/dd_HH:MM:SS "
+	timestamp := time.Now().Format("2006/01/02_15:04:05") // "yyyy/mm/dd_HH:MM:SS"
+
+	fmt.Fprintln(file, formatHistoryLine(timestamp, cmd))
+	file.Close()
+
+	// history file size limit
+	if s.ExtConfig.Shell.HistSize > 0 {
+		err = rotateHistoryFile(histfile, s.ExtConfig.Shell.HistSize, s.ExtConfig.Shell.HistRotate)
+	}
+
+	return
+}
+
+// rotateHistoryFile keep the last size entries in histfile.
+// Older entries are moved to `histfile.1`. When `histfile.1` is over size, it is rotated to `histfile.2`..., up to rotate files.
+// Rotation is done in chunks, when histfile is over size + size/10 (histRotateMargin), so histfile is not rewritten on every command.
+// Entry is counted with continuation lines (old format), so multi-line command is not split.
+// Call with history file locked.
+func rotateHistoryFile(histfile string, size, rotate int) (err error) {
+	lines, err := readLines(histfile)
+	if err != nil {
+		return
+	}
+
+	entries := splitHistoryEntries(lines)
+	if len(entries) <= size+histRotateMargin(size) {
+		return
+	}
+
+	overflow := joinHistoryEntries(entries[:len(entries)-size])
+	keep := joinHistoryEntries(entries[len(entries)-size:])
+
+	// move overflow entries to rotated file
+	if rotate > 0 {
+		rotated := histfile + ".1"
+		old, _ := readLines(rotated)
+		if len(splitHistoryEntries(old))+len(entries)-size > size {
+			for i := rotate - 1; i >= 1; i-- {
+				os.Rename(fmt.Sprintf("%s.%d", histfile, i), fmt.Sprintf("%s.%d", histfile, i+1))
+			}
+		}
+
+		err = writeLines(rotated, overflow, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		if err != nil {
+			return
+		}
+	}
+
+	// rewrite history file
+	tmp := histfile + ".tmp"
+	err = writeLines(tmp, keep, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp, histfile)
+}
+
+// histRotateMargin return the number of entries allowed over size before rotation (size/10, at least 1).
+func histRotateMargin(size int) int {
+	if margin := size / 10; margin > 0 {
+		return margin
+	}
+
+	return 1
+}
 
-	fmt.Fprintln(file, timestamp+cmd)
+// splitHistoryEntries split lines of history file to entries. Entry is a line with timestamp and following continuation lines.
+func splitHistoryEntries(lines []string) (entries [][]string) {
+	for _, line := range lines {
+		if _, _, ok := parseHistoryLine(line); ok || len(entries) == 0 {
+			entries = append(entries, []string{line})
+			continue
+		}
+
+		entries[len(entries)-1] = append(entries[len(entries)-1], line)
+	}
+
+	return
+}
+
+// joinHistoryEntries return lines of entries.
+func joinHistoryEntries(entries [][]string) (lines []string) {
+	for _, e := range entries {
+		lines = append(lines, e...)
+	}
+
+	return
+}
+
+// readLines return lines of file.
+func readLines(path string) (lines []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	err = sc.Err()
 
 	return
 }
+
+// writeLines write lines to file with flag.
+func writeLines(path string, lines []string, flag int) (err error) {
+	file, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+
+	return w.Flush()
+}
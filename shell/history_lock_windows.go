@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build windows
+
+package shell
+
+import (
+	"math"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile take exclusive lock of path (LockFileEx), and return unlock function.
+func lockFile(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+
+	handle := windows.Handle(file.Fd())
+	ol := new(windows.Overlapped)
+	err = windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, math.MaxUint32, math.MaxUint32, ol)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	unlock = func() {
+		windows.UnlockFileEx(handle, 0, math.MaxUint32, math.MaxUint32, ol)
+		file.Close()
+	}
+
+	return
+}
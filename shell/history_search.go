@@ -0,0 +1,334 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+	"mvdan.cc/sh/syntax"
+)
+
+// History expansion.
+// `!` is the prefix of local command, so history expansion use `%!`.
+//   - %!!  ... previous command
+//   - %!N  ... command of history number N (see `%history`)
+//   - %!-N ... command of N before
+//   - %!$  ... last argument of previous command
+//
+// Incremental reverse search (Ctrl-R).
+// Typed characters are added to the query, and the newest command including the query is shown in the buffer.
+//   - Ctrl-R          ... search older command including the query
+//   - Backspace       ... delete the last character of the query
+//   - Enter           ... run the found command
+//   - Ctrl-G, Esc     ... cancel search, and restore the buffer
+//   - other edit keys ... finish search, and edit the found command
+
+// addCommandHistory append command to in-memory command history (file history + session history).
+func (s *shell) addCommandHistory(command string) {
+	h := shellHistory{
+		Timestamp: time.Now().Format("2006/01/02_15:04:05"),
+		Command:   command,
+	}
+
+	s.historyMutex.Lock()
+	s.commandHistory = append(s.commandHistory, h)
+	s.historyMutex.Unlock()
+}
+
+// getCommandHistory return copy of command history.
+func (s *shell) getCommandHistory() []shellHistory {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	return append([]shellHistory{}, s.commandHistory...)
+}
+
+// expandHistory replace history expansion in command.
+// Expansion in single quote is not replaced (in double quote, it is replaced as bash). expanded is true if command is changed.
+func (s *shell) expandHistory(command string) (result string, expanded bool, err error) {
+	if !strings.Contains(command, "%!") {
+		return command, false, nil
+	}
+
+	histories := s.getCommandHistory()
+
+	var buf strings.Builder
+	inSingleQuote, inDoubleQuote := false, false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+
+		switch {
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case c == '\\' && !inSingleQuote && i+1 < len(command):
+			buf.WriteByte(c)
+			i++
+			c = command[i]
+		case c == '%' && !inSingleQuote && strings.HasPrefix(command[i:], "%!"):
+			value, size, herr := expandHistoryWord(command[i+2:], histories)
+			if herr != nil {
+				return command, false, herr
+			}
+
+			if size > 0 {
+				buf.WriteString(value)
+				i += 2 + size - 1
+				expanded = true
+				continue
+			}
+		}
+
+		buf.WriteByte(c)
+	}
+
+	return buf.String(), expanded, nil
+}
+
+// expandHistoryWord return expansion value of word (string after `%!`), and size of designator.
+// If size is 0, word is not history designator.
+func expandHistoryWord(word string, histories []shellHistory) (value string, size int, err error) {
+	if len(word) == 0 {
+		return
+	}
+
+	// get previous command
+	getPrevious := func() (string, error) {
+		if len(histories) == 0 {
+			return "", fmt.Errorf("%%!: event not found")
+		}
+		return histories[len(histories)-1].Command, nil
+	}
+
+	switch {
+	// %!!
+	case word[0] == '!':
+		value, err = getPrevious()
+		size = 1
+
+	// %!$
+	case word[0] == '$':
+		var prev string
+		prev, err = getPrevious()
+		value = getLastArgument(prev)
+		size = 1
+
+	// %!N, %!-N
+	default:
+		n := 0
+		if word[0] == '-' {
+			n = 1
+		}
+		for n < len(word) && word[n] >= '0' && word[n] <= '9' {
+			n++
+		}
+		if n == 0 || (word[0] == '-' && n == 1) {
+			return
+		}
+
+		num, _ := strconv.Atoi(word[:n])
+		if num < 0 {
+			num = len(histories) + num + 1
+		}
+
+		if num < 1 || num > len(histories) {
+			return "", 0, fmt.Errorf("%%!%s: event not found", word[:n])
+		}
+
+		value = histories[num-1].Command
+		size = n
+	}
+
+	return
+}
+
+// getLastArgument return last word of command.
+func getLastArgument(command string) (last string) {
+	f, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		fields := strings.Fields(command)
+		if len(fields) > 0 {
+			last = fields[len(fields)-1]
+		}
+		return
+	}
+
+	printer := syntax.NewPrinter()
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if c, ok := node.(*syntax.CallExpr); ok && len(c.Args) > 0 {
+			buf := new(bytes.Buffer)
+			printer.Print(buf, c.Args[len(c.Args)-1])
+			last = buf.String()
+		}
+		return true
+	})
+
+	return
+}
+
+// historySearchExitKeys is keys finishing reverse search (the found command is kept in buffer).
+var historySearchExitKeys = []prompt.Key{
+	prompt.Up, prompt.ControlP, prompt.Left, prompt.Right, prompt.Home, prompt.End, prompt.Tab, prompt.Delete,
+	prompt.ControlA, prompt.ControlB, prompt.ControlE, prompt.ControlF,
+	prompt.ControlK, prompt.ControlU, prompt.ControlW, prompt.ControlC,
+}
+
+// historySearchKeyBinds return key binds of reverse search.
+// Printable characters are bound to add to the query while searching (and inserted to buffer as usual while not).
+func (s *shell) historySearchKeyBinds() (opts []prompt.Option) {
+	// NOTE: 0x12 is parsed as prompt.ControlR, so it is bound by KeyBind (not ASCIICodeBind).
+	opts = append(opts,
+		prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlR, Fn: s.historySearch}),
+		prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.Backspace, Fn: s.historySearchBackspace}),
+		prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlH, Fn: s.historySearchBackspace}),
+		prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlG, Fn: s.historySearchCancel}),
+		prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.Escape, Fn: s.historySearchCancel}),
+	)
+
+	for _, key := range historySearchExitKeys {
+		opts = append(opts, prompt.OptionAddKeyBind(prompt.KeyBind{Key: key, Fn: s.historySearchExit}))
+	}
+
+	for c := byte(0x20); c < 0x7f; c++ {
+		c := c
+		opts = append(opts, prompt.OptionAddASCIICodeBind(prompt.ASCIICodeBind{
+			ASCIICode: []byte{c},
+			Fn: func(buf *prompt.Buffer) {
+				s.historySearchInput(buf, c)
+			},
+		}))
+	}
+
+	return
+}
+
+// searchHistory return index of the newest command including query, older than index. -1 is not found.
+// Command same as skip (the current result) is skipped.
+func searchHistory(histories []shellHistory, query string, index int, skip string) int {
+	if index > len(histories) {
+		index = len(histories)
+	}
+
+	for i := index - 1; i >= 0; i-- {
+		cmd := histories[i].Command
+		if cmd != skip && strings.Contains(cmd, query) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// historySearch start reverse search, or search older command while searching. bind to Ctrl-R.
+func (s *shell) historySearch(buf *prompt.Buffer) {
+	if !s.searching {
+		s.searching = true
+		s.searchFailed = false
+		s.searchQuery = ""
+		s.searchOriginal = buf.Text()
+		s.searchIndex = len(s.getCommandHistory())
+		s.searchResult = ""
+		return
+	}
+
+	s.updateHistorySearch(buf, s.searchIndex, s.searchResult)
+}
+
+// historySearchInput add c to the query while searching. While not searching, c is inserted to buffer.
+func (s *shell) historySearchInput(buf *prompt.Buffer, c byte) {
+	if !s.searching {
+		buf.InsertText(string(c), false, true)
+		return
+	}
+
+	s.searchQuery += string(c)
+
+	// the current result is kept if it includes the query
+	s.updateHistorySearch(buf, s.searchIndex+1, "")
+}
+
+// historySearchBackspace delete the last character of the query, and search again from the newest.
+func (s *shell) historySearchBackspace(buf *prompt.Buffer) {
+	if !s.searching {
+		return
+	}
+
+	if len(s.searchQuery) > 0 {
+		s.searchQuery = s.searchQuery[:len(s.searchQuery)-1]
+	}
+
+	if s.searchQuery == "" {
+		s.searchIndex = len(s.getCommandHistory())
+		s.searchResult = ""
+		s.searchFailed = false
+		setBufferText(buf, s.searchOriginal)
+		return
+	}
+
+	s.updateHistorySearch(buf, len(s.getCommandHistory()), "")
+
+	// backspace already deleted a character of the buffer, restore it
+	if s.searchFailed {
+		setBufferText(buf, s.searchResult)
+	}
+}
+
+// historySearchCancel cancel search, and restore the buffer. bind to Ctrl-G and Esc.
+func (s *shell) historySearchCancel(buf *prompt.Buffer) {
+	if !s.searching {
+		return
+	}
+
+	s.searching = false
+	setBufferText(buf, s.searchOriginal)
+}
+
+// historySearchExit finish search, and keep the found command in buffer.
+func (s *shell) historySearchExit(buf *prompt.Buffer) {
+	s.searching = false
+}
+
+// updateHistorySearch search the query older than index, and show the result in buffer.
+// If not found, the last result is kept, and the prompt shows failed.
+func (s *shell) updateHistorySearch(buf *prompt.Buffer, index int, skip string) {
+	if s.searchQuery == "" {
+		return
+	}
+
+	histories := s.getCommandHistory()
+	i := searchHistory(histories, s.searchQuery, index, skip)
+	if i < 0 {
+		s.searchFailed = true
+		return
+	}
+
+	s.searchFailed = false
+	s.searchIndex = i
+	s.searchResult = histories[i].Command
+	setBufferText(buf, s.searchResult)
+}
+
+// historySearchPrompt return prompt while searching.
+func (s *shell) historySearchPrompt() string {
+	if s.searchFailed {
+		return fmt.Sprintf("(failed reverse-i-search)`%s': ", s.searchQuery)
+	}
+
+	return fmt.Sprintf("(reverse-i-search)`%s': ", s.searchQuery)
+}
+
+// setBufferText replace the text of buf.
+func setBufferText(buf *prompt.Buffer, text string) {
+	d := buf.Document()
+	buf.DeleteBeforeCursor(len([]rune(d.TextBeforeCursor())))
+	buf.Delete(len([]rune(d.TextAfterCursor())))
+	buf.InsertText(text, false, true)
+}
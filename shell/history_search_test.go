@@ -0,0 +1,228 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"sync"
+	"testing"
+
+	prompt "github.com/c-bata/go-prompt"
+)
+
+// newHistoryTestShell return shell with command history.
+func newHistoryTestShell(commands ...string) *shell {
+	s := &shell{historyMutex: new(sync.Mutex)}
+	for _, c := range commands {
+		s.commandHistory = append(s.commandHistory, shellHistory{Command: c})
+	}
+
+	return s
+}
+
+// TestExpandHistory check history expansion and quoting (not expanded in single quote, expanded in double quote).
+func TestExpandHistory(t *testing.T) {
+	s := newHistoryTestShell("ls -l /tmp", "echo 'a b' c")
+
+	tests := []struct {
+		name         string
+		input        string
+		want         string
+		wantExpanded bool
+		wantErr      bool
+	}{
+		{name: "no expansion", input: "echo !!", want: "echo !!"},
+		{name: "previous", input: "%!!", want: "echo 'a b' c", wantExpanded: true},
+		{name: "number", input: "%!1 | wc", want: "ls -l /tmp | wc", wantExpanded: true},
+		{name: "negative number", input: "%!-2", want: "ls -l /tmp", wantExpanded: true},
+		{name: "last argument", input: "cat %!$", want: "cat c", wantExpanded: true},
+		{name: "single quote", input: "echo '%!!'", want: "echo '%!!'"},
+		{name: "double quote", input: `echo "%!1"`, want: `echo "ls -l /tmp"`, wantExpanded: true},
+		{name: "single quote in double quote", input: `echo "it's" %!1`, want: `echo "it's" ls -l /tmp`, wantExpanded: true},
+		{name: "double quote in single quote", input: `echo "'" '%!!'`, want: `echo "'" '%!!'`},
+		{name: "escaped", input: `echo \%!!`, want: `echo \%!!`},
+		{name: "not designator", input: "echo %!x", want: "echo %!x"},
+		{name: "event not found", input: "%!5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, expanded, err := s.expandHistory(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandHistory(%q) err = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got != tt.want || expanded != tt.wantExpanded {
+				t.Errorf("expandHistory(%q) = (%q, %v), want (%q, %v)", tt.input, got, expanded, tt.want, tt.wantExpanded)
+			}
+		})
+	}
+}
+
+// TestExpandHistoryWord check each history designator.
+func TestExpandHistoryWord(t *testing.T) {
+	histories := []shellHistory{{Command: "hostname"}, {Command: "uptime"}, {Command: "tail -n 10 /var/log/messages"}}
+
+	tests := []struct {
+		name      string
+		word      string
+		histories []shellHistory
+		wantValue string
+		wantSize  int
+		wantErr   bool
+	}{
+		{name: "empty", word: "", histories: histories},
+		{name: "previous", word: "! | wc", histories: histories, wantValue: "tail -n 10 /var/log/messages", wantSize: 1},
+		{name: "last argument", word: "$", histories: histories, wantValue: "/var/log/messages", wantSize: 1},
+		{name: "number", word: "2", histories: histories, wantValue: "uptime", wantSize: 1},
+		{name: "number with suffix", word: "1abc", histories: histories, wantValue: "hostname", wantSize: 1},
+		{name: "negative number", word: "-3", histories: histories, wantValue: "hostname", wantSize: 2},
+		{name: "minus only", word: "-x", histories: histories},
+		{name: "not designator", word: "x", histories: histories},
+		{name: "zero", word: "0", histories: histories, wantErr: true},
+		{name: "out of range", word: "4", histories: histories, wantErr: true},
+		{name: "negative out of range", word: "-4", histories: histories, wantErr: true},
+		{name: "previous without history", word: "!", wantErr: true},
+		{name: "last argument without history", word: "$", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, size, err := expandHistoryWord(tt.word, tt.histories)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandHistoryWord(%q) err = %v, wantErr %v", tt.word, err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if value != tt.wantValue || size != tt.wantSize {
+				t.Errorf("expandHistoryWord(%q) = (%q, %d), want (%q, %d)", tt.word, value, size, tt.wantValue, tt.wantSize)
+			}
+		})
+	}
+}
+
+// TestGetLastArgument check last argument of command.
+func TestGetLastArgument(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{name: "empty", command: "", want: ""},
+		{name: "no argument", command: "uptime", want: "uptime"},
+		{name: "simple", command: "ls -l /tmp", want: "/tmp"},
+		{name: "quoted", command: "echo 'a b'", want: "'a b'"},
+		{name: "pipe", command: "cat /etc/hosts | grep localhost", want: "localhost"},
+		{name: "list", command: "cd /var/log && ls messages", want: "messages"},
+		{name: "redirect", command: "echo foo > /tmp/out", want: "foo"},
+		{name: "syntax error", command: "echo a (", want: "("},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getLastArgument(tt.command); got != tt.want {
+				t.Errorf("getLastArgument(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSearchHistory check the newest command including query, older than index.
+func TestSearchHistory(t *testing.T) {
+	histories := []shellHistory{{Command: "ls /tmp"}, {Command: "uptime"}, {Command: "ls /var"}, {Command: "ls /var"}}
+
+	tests := []struct {
+		name  string
+		query string
+		index int
+		skip  string
+		want  int
+	}{
+		{name: "newest", query: "ls", index: 4, want: 3},
+		{name: "older", query: "ls", index: 3, want: 2},
+		{name: "skip duplicate", query: "ls", index: 3, skip: "ls /var", want: 0},
+		{name: "index over", query: "up", index: 10, want: 1},
+		{name: "not found", query: "df", index: 4, want: -1},
+		{name: "no older", query: "ls", index: 0, want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchHistory(histories, tt.query, tt.index, tt.skip); got != tt.want {
+				t.Errorf("searchHistory(%q, %d, %q) = %d, want %d", tt.query, tt.index, tt.skip, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHistorySearch check incremental reverse search with key input.
+func TestHistorySearch(t *testing.T) {
+	s := newHistoryTestShell("ls /tmp", "uptime", "ls /var", "df -h")
+	buf := prompt.NewBuffer()
+	buf.InsertText("original", false, true)
+
+	type step struct {
+		name       string
+		key        func(buf *prompt.Buffer)
+		wantText   string
+		wantPrompt string
+	}
+
+	input := func(text string) func(buf *prompt.Buffer) {
+		return func(buf *prompt.Buffer) {
+			for i := 0; i < len(text); i++ {
+				s.historySearchInput(buf, text[i])
+			}
+		}
+	}
+
+	steps := []step{
+		{name: "start", key: s.historySearch, wantText: "original", wantPrompt: "(reverse-i-search)`': "},
+		{name: "query", key: input("ls"), wantText: "ls /var", wantPrompt: "(reverse-i-search)`ls': "},
+		{name: "older", key: s.historySearch, wantText: "ls /tmp", wantPrompt: "(reverse-i-search)`ls': "},
+		{name: "no more older", key: s.historySearch, wantText: "ls /tmp", wantPrompt: "(failed reverse-i-search)`ls': "},
+		{name: "narrow", key: input(" /t"), wantText: "ls /tmp", wantPrompt: "(reverse-i-search)`ls /t': "},
+		{name: "not found", key: input("x"), wantText: "ls /tmp", wantPrompt: "(failed reverse-i-search)`ls /tx': "},
+		{name: "backspace", key: s.historySearchBackspace, wantText: "ls /tmp", wantPrompt: "(reverse-i-search)`ls /t': "},
+		{name: "backspace search from newest", key: func(buf *prompt.Buffer) {
+			s.historySearchBackspace(buf)
+			s.historySearchBackspace(buf)
+		}, wantText: "ls /var", wantPrompt: "(reverse-i-search)`ls ': "},
+		{name: "cancel", key: s.historySearchCancel, wantText: "original"},
+		{name: "input after cancel", key: input("!"), wantText: "original!"},
+		{name: "restart", key: s.historySearch, wantText: "original!", wantPrompt: "(reverse-i-search)`': "},
+		{name: "query after restart", key: input("up"), wantText: "uptime", wantPrompt: "(reverse-i-search)`up': "},
+		{name: "exit", key: s.historySearchExit, wantText: "uptime"},
+	}
+
+	for _, st := range steps {
+		st.key(buf)
+
+		if got := buf.Text(); got != st.wantText {
+			t.Fatalf("%s: buffer = %q, want %q", st.name, got, st.wantText)
+		}
+
+		if st.wantPrompt == "" {
+			if s.searching {
+				t.Fatalf("%s: still searching", st.name)
+			}
+			continue
+		}
+
+		if !s.searching {
+			t.Fatalf("%s: not searching", st.name)
+		}
+
+		if got := s.historySearchPrompt(); got != st.wantPrompt {
+			t.Fatalf("%s: prompt = %q, want %q", st.name, got, st.wantPrompt)
+		}
+	}
+}
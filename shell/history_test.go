@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/blacknon/lsshell/config"
+)
+
+// historyLines return n history lines, numbered from start.
+func historyLines(start, n int) (lines []string) {
+	for i := start; i < start+n; i++ {
+		lines = append(lines, fmt.Sprintf("2024/01/01_00:00:00 cmd%d", i))
+	}
+
+	return
+}
+
+// TestSplitHistoryEntries check that continuation lines (old format) are joined to the entry.
+func TestSplitHistoryEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  [][]string
+	}{
+		{name: "empty", lines: nil, want: nil},
+		{
+			name:  "single line entries",
+			lines: []string{"2024/01/01_00:00:00 ls", "2024/01/01_00:00:01\tcat <<EOF\\nx\\nEOF"},
+			want:  [][]string{{"2024/01/01_00:00:00 ls"}, {"2024/01/01_00:00:01\tcat <<EOF\\nx\\nEOF"}},
+		},
+		{
+			name:  "continuation lines",
+			lines: []string{"2024/01/01_00:00:00 cat <<EOF", "a", "EOF", "2024/01/01_00:00:01 ls"},
+			want:  [][]string{{"2024/01/01_00:00:00 cat <<EOF", "a", "EOF"}, {"2024/01/01_00:00:01 ls"}},
+		},
+		{
+			name:  "leading line without timestamp",
+			lines: []string{"broken", "2024/01/01_00:00:00 ls"},
+			want:  [][]string{{"broken"}, {"2024/01/01_00:00:00 ls"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitHistoryEntries(tt.lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitHistoryEntries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRotateHistoryFile check that history file is rotated in chunks, and rotated files are shifted.
+func TestRotateHistoryFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		rotated     []string // existing histfile.1
+		size        int
+		rotate      int
+		wantLines   []string
+		wantRotated [][]string // histfile.1, histfile.2...
+	}{
+		{
+			name:      "under size",
+			lines:     historyLines(1, 10),
+			size:      10,
+			rotate:    1,
+			wantLines: historyLines(1, 10),
+		},
+		{
+			name:      "within margin",
+			lines:     historyLines(1, 22),
+			size:      20,
+			rotate:    1,
+			wantLines: historyLines(1, 22),
+		},
+		{
+			name:        "over margin",
+			lines:       historyLines(1, 23),
+			size:        20,
+			rotate:      1,
+			wantLines:   historyLines(4, 20),
+			wantRotated: [][]string{historyLines(1, 3)},
+		},
+		{
+			name:      "margin of small size",
+			lines:     historyLines(1, 6),
+			size:      5,
+			rotate:    1,
+			wantLines: historyLines(1, 6),
+		},
+		{
+			name:        "over margin of small size",
+			lines:       historyLines(1, 7),
+			size:        5,
+			rotate:      1,
+			wantLines:   historyLines(3, 5),
+			wantRotated: [][]string{historyLines(1, 2)},
+		},
+		{
+			name:      "without rotate",
+			lines:     historyLines(1, 7),
+			size:      5,
+			rotate:    0,
+			wantLines: historyLines(3, 5),
+		},
+		{
+			name:        "append to rotated",
+			lines:       historyLines(3, 7),
+			rotated:     historyLines(1, 2),
+			size:        5,
+			rotate:      2,
+			wantLines:   historyLines(5, 5),
+			wantRotated: [][]string{historyLines(1, 4)},
+		},
+		{
+			name:        "shift rotated",
+			lines:       historyLines(5, 7),
+			rotated:     historyLines(1, 4),
+			size:        5,
+			rotate:      2,
+			wantLines:   historyLines(7, 5),
+			wantRotated: [][]string{historyLines(5, 2), historyLines(1, 4)},
+		},
+		{
+			name:      "multi-line entry is not split",
+			lines:     append(append([]string{"2024/01/01_00:00:00 cat <<EOF", "a", "EOF"}, historyLines(1, 5)...), historyLines(6, 1)...),
+			size:      5,
+			rotate:    0,
+			wantLines: historyLines(2, 5),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			histfile := filepath.Join(t.TempDir(), "history")
+			if err := writeLines(histfile, tt.lines, os.O_WRONLY|os.O_CREATE); err != nil {
+				t.Fatal(err)
+			}
+			if tt.rotated != nil {
+				if err := writeLines(histfile+".1", tt.rotated, os.O_WRONLY|os.O_CREATE); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := rotateHistoryFile(histfile, tt.size, tt.rotate); err != nil {
+				t.Fatalf("rotateHistoryFile() err = %v", err)
+			}
+
+			got, _ := readLines(histfile)
+			if !reflect.DeepEqual(got, tt.wantLines) {
+				t.Errorf("history = %q, want %q", got, tt.wantLines)
+			}
+
+			for i := 1; i <= tt.rotate+1; i++ {
+				var want []string
+				if i <= len(tt.wantRotated) {
+					want = tt.wantRotated[i-1]
+				}
+
+				got, _ := readLines(fmt.Sprintf("%s.%d", histfile, i))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("history.%d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestCheckHistoryIgnore check histcontrol (ignorespace, ignoredups, ignoreboth) and histignore.
+func TestCheckHistoryIgnore(t *testing.T) {
+	tests := []struct {
+		name           string
+		histControl    string
+		histIgnore     []string
+		command        string
+		startWithSpace bool
+		want           bool
+	}{
+		{name: "default", command: "ls", want: false},
+		{name: "default with space", command: "ls", startWithSpace: true, want: false},
+		{name: "default dup", command: "uptime", want: false},
+		{name: "ignorespace", histControl: "ignorespace", command: "ls", startWithSpace: true, want: true},
+		{name: "ignorespace without space", histControl: "ignorespace", command: "ls", want: false},
+		{name: "ignoredups", histControl: "ignoredups", command: "uptime", want: true},
+		{name: "ignoredups older", histControl: "ignoredups", command: "hostname", want: false},
+		{name: "ignoreboth space", histControl: "ignoreboth", command: "ls", startWithSpace: true, want: true},
+		{name: "ignoreboth dup", histControl: "ignoreboth", command: "uptime", want: true},
+		{name: "colon separated", histControl: "ignorespace:ignoredups", command: "uptime", want: true},
+		{name: "histignore", histIgnore: []string{"password=", "^%history"}, command: "mysql password=x", want: true},
+		{name: "histignore anchored", histIgnore: []string{"^%history"}, command: "echo %history", want: false},
+		{name: "histignore invalid regex", histIgnore: []string{"("}, command: "(", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHistoryTestShell("hostname", "uptime")
+			s.ExtConfig = config.Config{Shell: config.ShellConfig{HistControl: tt.histControl}}
+
+			// invalid regex is reported to stderr
+			stderr := os.Stderr
+			os.Stderr, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			s.histIgnore = compileHistIgnore(tt.histIgnore)
+			os.Stderr.Close()
+			os.Stderr = stderr
+
+			if got := s.checkHistoryIgnore(tt.command, tt.startWithSpace); got != tt.want {
+				t.Errorf("checkHistoryIgnore(%q, %v) = %v, want %v", tt.command, tt.startWithSpace, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHistoryLine check format of history line, and that parseHistoryLine is the reverse of formatHistoryLine.
+func TestHistoryLine(t *testing.T) {
+	const timestamp = "2024/01/01_00:00:00"
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{name: "single line", command: "ls -l", want: timestamp + " ls -l"},
+		{name: "backslash in single line", command: `echo a\nb`, want: timestamp + ` echo a\nb`},
+		{name: "multi-line", command: "cat <<EOF\na\nEOF", want: timestamp + "\tcat <<EOF\\na\\nEOF"},
+		{name: "backslash in multi-line", command: "echo \\\\n \\\n  x", want: timestamp + "\techo \\\\\\\\n \\\\\\n  x"},
+		{name: "tab in single line", command: "printf '\t'", want: timestamp + " printf '\t'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := formatHistoryLine(timestamp, tt.command)
+			if line != tt.want {
+				t.Fatalf("formatHistoryLine(%q) = %q, want %q", tt.command, line, tt.want)
+			}
+
+			ts, command, ok := parseHistoryLine(line)
+			if !ok || ts != timestamp || command != tt.command {
+				t.Errorf("parseHistoryLine(%q) = (%q, %q, %v), want (%q, %q, true)", line, ts, command, ok, timestamp, tt.command)
+			}
+		})
+	}
+
+	// line without timestamp is continuation line (old format)
+	for _, line := range []string{"", "EOF", "2024/01/01_00:00:00", "2024-01-01_00:00:00 ls"} {
+		if _, _, ok := parseHistoryLine(line); ok {
+			t.Errorf("parseHistoryLine(%q) ok = true, want false", line)
+		}
+	}
+}
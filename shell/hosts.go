@@ -0,0 +1,431 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/blacknon/lssh/conf"
+	"github.com/blacknon/lsshell/config"
+)
+
+// Host management.
+//   - %hosts           ... print hosts with state.
+//   - %add host...     ... connect to hosts in lssh config, and add them. host expression (`web*`, `tag:role=web`...) can be used.
+//   - %remove host...  ... disconnect and remove hosts.
+//   - %disable host... ... exclude hosts from command, without disconnecting.
+//   - %enable host...  ... include disabled hosts again.
+
+// getConnects return copy of all connects (include disabled hosts).
+func (s *shell) getConnects() []*sConnect {
+	s.connectMutex.Lock()
+	defer s.connectMutex.Unlock()
+
+	return append([]*sConnect{}, s.Connects...)
+}
+
+// getActiveConnects return connects not disabled. Commands are run on these hosts.
+func (s *shell) getActiveConnects() (connects []*sConnect) {
+	s.connectMutex.Lock()
+	defer s.connectMutex.Unlock()
+
+	for _, c := range s.Connects {
+		if !c.Disabled {
+			connects = append(connects, c)
+		}
+	}
+
+	return
+}
+
+// findConnect return connect of name. nil if not connected.
+func (s *shell) findConnect(name string) *sConnect {
+	for _, c := range s.getConnects() {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// removeConnects delete connects from s.Connects.
+func (s *shell) removeConnects(connects []*sConnect) {
+	s.connectMutex.Lock()
+	result := []*sConnect{}
+	for _, c := range s.Connects {
+		removed := false
+		for _, r := range connects {
+			if c == r {
+				removed = true
+				break
+			}
+		}
+
+		if !removed {
+			result = append(result, c)
+		}
+	}
+	s.Connects = result
+	s.connectMutex.Unlock()
+
+	s.updateServerList()
+}
+
+// updateServerList update server list of output prompt, so that the width and color of OPROMPT
+// are the same as connected hosts.
+func (s *shell) updateServerList() {
+	s.connectMutex.Lock()
+
+	var names []string
+	for _, c := range s.Connects {
+		names = append(names, c.Name)
+	}
+	s.ServerList = names
+
+	for _, c := range s.Connects {
+		c.Output.ServerList = names
+		c.Output.Create(c.Name)
+	}
+	s.connectMutex.Unlock()
+
+	// remote argument candidates are depend on hosts.
+	// (completeMutex is locked after connectMutex is unlocked, as rebuildCommandComplete)
+	s.clearArgCompleteCache()
+}
+
+// getAddableHosts return hosts in lssh config, not connected.
+func (s *shell) getAddableHosts() (hosts []string) {
+	for _, name := range conf.GetNameList(s.Run.Conf) {
+		if s.findConnect(name) == nil {
+			hosts = append(hosts, name)
+		}
+	}
+	sort.Strings(hosts)
+
+	return
+}
+
+// getGroupNames return sorted group names in config.
+func (s *shell) getGroupNames() []string {
+	groups := s.ExtConfig.GetGroupNames()
+	sort.Strings(groups)
+
+	return groups
+}
+
+// buildin_hosts print hosts with state.
+func (s *shell) buildin_hosts(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	connects := s.getConnects()
+
+	nameWidth := 4
+	for _, c := range connects {
+		if len(c.Name) > nameWidth {
+			nameWidth = len(c.Name)
+		}
+	}
+
+	fmt.Fprintf(stdout, "%-*s  %-8s  %-21s  %s\n", nameWidth, "HOST", "STATE", "ADDRESS", "CWD")
+	for _, c := range connects {
+		state := "enabled"
+		if c.Disabled {
+			state = "disabled"
+		}
+
+		cwd := c.Cwd
+		if cwd == "" {
+			cwd = "-"
+		}
+
+		addr := c.Output.Conf.Addr
+		if c.Output.Conf.Port != "" {
+			addr = addr + ":" + c.Output.Conf.Port
+		}
+
+		fmt.Fprintf(stdout, "%-*s  %-8s  %-21s  %s\n", nameWidth, c.Name, state, addr, cwd)
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildin_add connect to hosts in lssh config, and add them.
+func (s *shell) buildin_add(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: %%add: specify host. usage: %%add <host>...\n")
+		status = false
+	} else {
+		names, err := s.expandAddHosts(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %%add: %s\n", err)
+			status = false
+		} else {
+			status = s.addHosts("%add", names, stdout)
+		}
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// expandAddHosts expand host expressions of args to hosts in lssh config, not connected.
+// Server names in args are returned as it is.
+func (s *shell) expandAddHosts(args []string) (names []string, err error) {
+	for _, arg := range args {
+		if !config.IsHostPattern(arg) {
+			names = append(names, arg)
+			continue
+		}
+
+		hosts, err := s.ExtConfig.MatchHosts(s.getAddableHosts(), []string{arg})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, h := range hosts {
+			if indexOf(names, h) < 0 {
+				names = append(names, h)
+			}
+		}
+	}
+
+	return
+}
+
+// addHosts connect to hosts in lssh config, and add them. cmd is used for error message.
+// It returns false if any host can not be added.
+func (s *shell) addHosts(cmd string, names []string, stdout io.Writer) (status bool) {
+	status = true
+
+	// connect in parallel
+	m := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	var added []*sConnect
+	for _, name := range names {
+		switch {
+		case s.findConnect(name) != nil:
+			fmt.Fprintf(os.Stderr, "Error: %s: %s is already connected\n", cmd, name)
+			status = false
+			continue
+		case !s.isConfigServer(name):
+			fmt.Fprintf(os.Stderr, "Error: %s: %s is not found in config\n", cmd, name)
+			status = false
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			c, err := createConnect(s.Run, s.Config, name)
+
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %s: %s\n", cmd, name, err)
+				status = false
+				return
+			}
+			added = append(added, c)
+		}(name)
+	}
+	wg.Wait()
+
+	if len(added) == 0 {
+		return
+	}
+
+	// keep the order of names
+	sort.SliceStable(added, func(i, j int) bool {
+		return indexOf(names, added[i].Name) < indexOf(names, added[j].Name)
+	})
+
+	s.connectMutex.Lock()
+	s.Connects = append(s.Connects, added...)
+	s.connectMutex.Unlock()
+	s.updateServerList()
+
+	for _, c := range added {
+		s.getHostStatus(c.Name)
+		fmt.Fprintf(stdout, "add %s\n", c.Name)
+
+		// command complete from cache, and update expired in background
+		if cache, err := s.readCompleteCache(c.Name); err == nil {
+			s.completeMutex.Lock()
+			s.cmdCompleteMap[c.Name] = cache.Commands
+			s.completeMutex.Unlock()
+		}
+	}
+	s.rebuildCommandComplete()
+	go s.updateCommandComplete(false)
+
+	// start port forwarding of added hosts
+	s.startPortForwards(added)
+
+	return
+}
+
+// isConfigServer return true if name is server in lssh config.
+func (s *shell) isConfigServer(name string) bool {
+	_, ok := s.Run.Conf.Server[name]
+	return ok
+}
+
+// buildin_remove disconnect and remove hosts.
+func (s *shell) buildin_remove(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	connects, err := s.getHostArgs("%remove", args)
+	if err == nil {
+		err = s.removeHosts("%remove", connects, stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// removeHosts disconnect and remove connects. cmd is used for error message.
+func (s *shell) removeHosts(cmd string, connects []*sConnect, stdout io.Writer) error {
+	if len(connects) >= len(s.getConnects()) {
+		return fmt.Errorf("%s: can not remove all hosts", cmd)
+	}
+
+	s.removeConnects(connects)
+	for _, c := range connects {
+		s.stopPortForwards(c.Name)
+		c.Client.Close()
+		s.deleteHostStatus(c.Name)
+		fmt.Fprintf(stdout, "remove %s\n", c.Name)
+	}
+	s.rebuildCommandComplete()
+
+	return nil
+}
+
+// buildin_disable exclude hosts from command (disabled is true), or include them again (disabled is false).
+func (s *shell) buildin_disable(args []string, disabled bool, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	name := "%enable"
+	if disabled {
+		name = "%disable"
+	}
+
+	status := true
+	connects, err := s.getHostArgs(name, args)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	case disabled && countDisabled(s.getActiveConnects(), connects) == 0:
+		fmt.Fprintf(os.Stderr, "Error: %%disable: can not disable all hosts\n")
+		status = false
+		connects = nil
+	}
+
+	s.connectMutex.Lock()
+	for _, c := range connects {
+		c.Disabled = disabled
+	}
+	s.connectMutex.Unlock()
+
+	for _, c := range connects {
+		s.getHostStatus(c.Name).setDisabled(disabled)
+		fmt.Fprintf(stdout, "%s %s\n", name[1:], c.Name)
+	}
+
+	// remote argument candidates are depend on active hosts
+	if len(connects) > 0 {
+		s.clearArgCompleteCache()
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// getHostArgs return connects of args. cmd is used for error message.
+func (s *shell) getHostArgs(cmd string, args []string) (connects []*sConnect, err error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: specify host. usage: %s <host>...", cmd, cmd)
+	}
+
+	for _, name := range args {
+		c := s.findConnect(name)
+		if c == nil {
+			return nil, fmt.Errorf("%s: %s is not connected", cmd, name)
+		}
+		connects = append(connects, c)
+	}
+
+	return
+}
+
+// countDisabled return number of active connects, after connects are disabled.
+func countDisabled(active, connects []*sConnect) (count int) {
+	for _, a := range active {
+		found := false
+		for _, c := range connects {
+			if a == c {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			count++
+		}
+	}
+
+	return
+}
+
+// indexOf return index of str in list. -1 if not found.
+func indexOf(list []string, str string) int {
+	for i, l := range list {
+		if l == str {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,433 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Interrupt of foreground command.
+//   - 1st Ctrl-C ... show host chooser, and interrupt only selected hosts.
+//   - 2nd Ctrl-C ... interrupt all hosts (while the chooser is shown).
+// SIGTSTP, SIGQUIT and window size change are forwarded to remote sessions.
+
+// signalBufferSize is buffer size of shell.Signal. signal.Notify drops the signal if the channel is full.
+const signalBufferSize = 8
+
+// discardSignals discard signals received while no command is running (at the prompt).
+// They must not interrupt the next command.
+func (s *shell) discardSignals() {
+	for {
+		select {
+		case <-s.Signal:
+		default:
+			return
+		}
+	}
+}
+
+// runningSession is remote session of foreground command.
+type runningSession struct {
+	Name    string
+	session *ssh.Session
+	stdin   io.Writer
+	tty     bool
+	done    bool
+}
+
+// foregroundSessions is remote sessions of foreground command, and the terminal input pump.
+type foregroundSessions struct {
+	sessions []*runningSession
+	pump     *inputPump
+	mutex    *sync.Mutex
+}
+
+// remoteSignal is signal forwarded to remote session.
+// If the session has tty, ctrl (control character) is sent to the remote tty instead.
+type remoteSignal struct {
+	name   ssh.Signal
+	ctrl   byte
+	resize bool
+}
+
+// newForegroundSessions return new foregroundSessions.
+func newForegroundSessions() *foregroundSessions {
+	return &foregroundSessions{mutex: new(sync.Mutex)}
+}
+
+// reset clear sessions. It is called at start of each pipeline.
+func (f *foregroundSessions) reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sessions = nil
+	f.pump = nil
+}
+
+// add add session of host, and return it.
+func (f *foregroundSessions) add(name string, session *ssh.Session, stdin io.Writer, tty bool) *runningSession {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	rs := &runningSession{
+		Name:    name,
+		session: session,
+		stdin:   stdin,
+		tty:     tty,
+	}
+	f.sessions = append(f.sessions, rs)
+
+	return rs
+}
+
+// finish mark rs as finished.
+func (f *foregroundSessions) finish(rs *runningSession) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	rs.done = true
+}
+
+// running return sessions not finished.
+func (f *foregroundSessions) running() (result []*runningSession) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, rs := range f.sessions {
+		if !rs.done {
+			result = append(result, rs)
+		}
+	}
+
+	return
+}
+
+// setPump set terminal input pump of foreground command.
+func (f *foregroundSessions) setPump(pump *inputPump) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pump = pump
+}
+
+// getPump return terminal input pump of foreground command. nil if terminal input is not used.
+func (f *foregroundSessions) getPump() *inputPump {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.pump
+}
+
+// forward send sig to running sessions.
+func (f *foregroundSessions) forward(sig remoteSignal) {
+	var width, height int
+	if sig.resize {
+		var err error
+		width, height, err = term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return
+		}
+	}
+
+	for _, rs := range f.running() {
+		switch {
+		case sig.resize:
+			if rs.tty {
+				rs.session.WindowChange(height, width)
+			}
+		case rs.tty && sig.ctrl != 0:
+			rs.stdin.Write([]byte{sig.ctrl})
+		default:
+			rs.session.Signal(sig.name)
+		}
+	}
+}
+
+// interrupt send SIGINT to rs. If rs has tty, Ctrl-C is sent to the remote tty.
+func (rs *runningSession) interrupt() {
+	if rs.tty {
+		rs.stdin.Write([]byte{0x03})
+		return
+	}
+
+	rs.session.Signal(ssh.SIGINT)
+}
+
+// requestTty request pty of local terminal size to session.
+// Window size change is forwarded by foregroundSessions, not by goroutine per session as sshlib.RequestTty.
+func requestTty(session *ssh.Session) (err error) {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	// Get terminal window size
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return
+	}
+
+	// Get env `TERM`
+	termName := os.Getenv("TERM")
+	if len(termName) == 0 {
+		termName = "xterm"
+	}
+
+	return session.RequestPty(termName, height, width, modes)
+}
+
+// handleInterrupt handle signals while foreground pipeline of num elements is running, until done is closed.
+// The 1st SIGINT shows the host chooser, and SIGINT while the chooser is shown sends kill to all elements.
+func (s *shell) handleInterrupt(num int, kill chan<- bool, done <-chan bool) {
+	var cancel chan bool           // cancel of host chooser. not nil while the chooser is shown.
+	finished := make(chan bool, 1) // host chooser is finished
+
+	killAll := func() {
+		if cancel != nil {
+			close(cancel)
+			cancel = nil
+		}
+
+		for i := 0; i < num; i++ {
+			select {
+			case kill <- true:
+			case <-done:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case sig := <-s.Signal:
+			// SIGTSTP, SIGQUIT, SIGWINCH
+			if f, ok := getForwardSignal(sig); ok {
+				s.foreground.forward(f)
+				continue
+			}
+
+			// interrupt all hosts: SIGTERM, 2nd SIGINT, or only one host is running.
+			running := s.foreground.running()
+			if sig == syscall.SIGTERM || cancel != nil || len(running) <= 1 {
+				killAll()
+				return
+			}
+
+			cancel = make(chan bool)
+			go func(cancel chan bool) {
+				s.chooseInterruptHosts(running, cancel)
+				finished <- true
+			}(cancel)
+
+		case <-finished:
+			cancel = nil
+
+		case <-done:
+			if cancel != nil {
+				close(cancel)
+			}
+			return
+		}
+	}
+}
+
+// chooseInterruptHosts show running hosts, and interrupt hosts selected by number or name.
+func (s *shell) chooseInterruptHosts(running []*runningSession, cancel <-chan bool) {
+	var list []string
+	for i, rs := range running {
+		list = append(list, fmt.Sprintf("[%d] %s", i+1, rs.Name))
+	}
+	fmt.Fprintf(os.Stderr, "\nInterrupt hosts: %s\n", strings.Join(list, "  "))
+	fmt.Fprintf(os.Stderr, "Select number or name (space separated, `a` is all, empty is cancel. Ctrl-C again interrupts all): ")
+
+	// read line from terminal
+	var input <-chan []byte
+	if pump := s.foreground.getPump(); pump != nil {
+		var release func()
+		input, release = pump.Hook()
+		defer release()
+	} else {
+		tty, err := openTTYReader()
+		if err != nil {
+			return
+		}
+		defer tty.Close()
+		input = readChunks(tty)
+	}
+
+	line, ok := readLine(input, cancel)
+	if !ok {
+		return
+	}
+
+	// select hosts
+	var selected []*runningSession
+	for _, f := range strings.Fields(line) {
+		if f == "a" || f == "all" {
+			selected = running
+			break
+		}
+
+		found := false
+		for i, rs := range running {
+			if f == rs.Name || f == strconv.Itoa(i+1) {
+				selected = append(selected, rs)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Error: no such host `%s`\n", f)
+		}
+	}
+
+	for _, rs := range selected {
+		rs.interrupt()
+		fmt.Fprintf(os.Stderr, "[%s] interrupted\n", rs.Name)
+	}
+}
+
+// readChunks return channel of data read from r. The channel is closed when read returns error.
+func readChunks(r io.Reader) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ch <- append([]byte{}, buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// readLine read a line from input, until cancel is closed.
+func readLine(input <-chan []byte, cancel <-chan bool) (line string, ok bool) {
+	var buf []byte
+	for {
+		select {
+		case data, ok := <-input:
+			if !ok {
+				return "", false
+			}
+
+			buf = append(buf, data...)
+			if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+				return strings.TrimSpace(string(buf[:i])), true
+			}
+
+		case <-cancel:
+			return "", false
+		}
+	}
+}
+
+// readTerminalLine read a line from terminal (while prompt is not running).
+func readTerminalLine() (line string, ok bool) {
+	tty, err := openTTYReader()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError: %s\n", err)
+		return "", false
+	}
+	defer tty.Close()
+
+	return readLine(readChunks(tty), nil)
+}
+
+// inputPump send terminal input to remote sessions, instead of output.PushInput.
+// The reading can be stopped by Close, and the input is taken by the host chooser while hooked.
+type inputPump struct {
+	tty     *ttyReader
+	writers []io.WriteCloser
+	hook    chan []byte
+	mutex   *sync.Mutex
+}
+
+// newInputPump return inputPump of terminal to writers.
+func newInputPump(writers []io.WriteCloser) (pump *inputPump, err error) {
+	tty, err := openTTYReader()
+	if err != nil {
+		return
+	}
+
+	pump = &inputPump{
+		tty:     tty,
+		writers: writers,
+		mutex:   new(sync.Mutex),
+	}
+
+	return
+}
+
+// Run send terminal input to writers, until Close or EOF (Ctrl-D). The writers are closed at the end.
+func (p *inputPump) Run() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := p.tty.Read(buf)
+		if n > 0 {
+			data := append([]byte{}, buf[:n]...)
+
+			p.mutex.Lock()
+			hook := p.hook
+			p.mutex.Unlock()
+
+			if hook != nil {
+				hook <- data
+			} else {
+				for _, w := range p.writers {
+					w.Write(data)
+				}
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// close output
+	for _, w := range p.writers {
+		w.Close()
+	}
+}
+
+// Hook take the terminal input until release is called.
+func (p *inputPump) Hook() (input <-chan []byte, release func()) {
+	hook := make(chan []byte, 16)
+
+	p.mutex.Lock()
+	p.hook = hook
+	p.mutex.Unlock()
+
+	release = func() {
+		p.mutex.Lock()
+		p.hook = nil
+		p.mutex.Unlock()
+	}
+
+	return hook, release
+}
+
+// Close stop reading terminal.
+func (p *inputPump) Close() error {
+	return p.tty.Close()
+}
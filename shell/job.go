@@ -0,0 +1,449 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Background jobs.
+//   - command &               ... run remote command in background. The output is recorded to history (`%out N`), not terminal.
+//   - %jobs                   ... print running jobs, with status of each host.
+//   - %fg [N]                 ... show the output of job N (default is latest job) until it finish. press Ctrl-C to return to prompt.
+//   - %wait [N]               ... wait for job N (default is all jobs) to finish. press Ctrl-C to return to prompt.
+//   - %kill [-SIGNAL] [N]     ... send signal (default is TERM) to job N (default is latest job).
+
+// job is background job.
+type job struct {
+	ID        int
+	Command   string
+	Count     int // number of History (`%out N`)
+	StartTime time.Time
+
+	hosts    []*hostStatus
+	sessions []*ssh.Session
+	outputs  []*switchWriter
+	attached bool
+
+	finished chan bool
+	status   bool
+
+	mutex *sync.Mutex
+}
+
+// jobSignals is signals can be sent by `%kill`.
+var jobSignals = map[string]ssh.Signal{
+	"ABRT": ssh.SIGABRT,
+	"ALRM": ssh.SIGALRM,
+	"FPE":  ssh.SIGFPE,
+	"HUP":  ssh.SIGHUP,
+	"ILL":  ssh.SIGILL,
+	"INT":  ssh.SIGINT,
+	"KILL": ssh.SIGKILL,
+	"PIPE": ssh.SIGPIPE,
+	"QUIT": ssh.SIGQUIT,
+	"SEGV": ssh.SIGSEGV,
+	"TERM": ssh.SIGTERM,
+	"USR1": ssh.SIGUSR1,
+	"USR2": ssh.SIGUSR2,
+	"1":    ssh.SIGHUP,
+	"2":    ssh.SIGINT,
+	"3":    ssh.SIGQUIT,
+	"9":    ssh.SIGKILL,
+	"15":   ssh.SIGTERM,
+}
+
+// startJob run pline as background job.
+func (s *shell) startJob(pline []pipeLine) {
+	j := s.newJob(strings.TrimSpace(joinPipeLineSlice(pline)))
+	for i := range pline {
+		pline[i].Job = j
+	}
+
+	ch := make(chan bool)
+	kill := make(chan bool)
+	s.runPipeLine(pline, ch, kill)
+
+	fmt.Printf("[%d] %s\n", j.ID, j.Command)
+
+	go func() {
+		status := s.wait(len(pline), ch)
+		close(kill)
+
+		j.finish(status)
+		s.removeJob(j)
+
+		// notify (not shown while `%fg`)
+		if !j.isAttached() {
+			result := "Done"
+			if !status {
+				result = "Exit"
+			}
+			fmt.Fprintf(os.Stderr, "\n[%d] %s  %s (%%out %d)\n", j.ID, result, j.Command, j.Count)
+		}
+	}()
+}
+
+// newJob create job of command, and add it to job list.
+func (s *shell) newJob(command string) *job {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	// job id is next to the max id of running jobs
+	id := 1
+	for _, j := range s.jobs {
+		if j.ID >= id {
+			id = j.ID + 1
+		}
+	}
+
+	j := &job{
+		ID:        id,
+		Command:   command,
+		Count:     s.Count,
+		StartTime: time.Now(),
+		finished:  make(chan bool),
+		mutex:     new(sync.Mutex),
+	}
+	s.jobs = append(s.jobs, j)
+
+	return j
+}
+
+// removeJob delete j from job list.
+func (s *shell) removeJob(j *job) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	for i, jj := range s.jobs {
+		if jj == j {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			break
+		}
+	}
+}
+
+// getJobList return copy of job list.
+func (s *shell) getJobList() []*job {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+
+	return append([]*job{}, s.jobs...)
+}
+
+// getJob return job of id (`N` or `%N`). If id is empty, return the latest job.
+func (s *shell) getJob(id string) (*job, error) {
+	jobs := s.getJobList()
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no running job")
+	}
+
+	if id == "" {
+		return jobs[len(jobs)-1], nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid job id `%s`", id)
+	}
+
+	for _, j := range jobs {
+		if j.ID == n {
+			return j, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such job `%s`", id)
+}
+
+// addHost add session of host to job, and return status of host in the job.
+func (j *job) addHost(name string, session *ssh.Session) *hostStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	st := newHostStatus(name)
+	j.hosts = append(j.hosts, st)
+	j.sessions = append(j.sessions, session)
+
+	return st
+}
+
+// addOutput return writer to w, that is written only while the job is attached (`%fg`).
+func (j *job) addOutput(w io.Writer) io.Writer {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	sw := newSwitchWriter(w)
+	if !j.attached {
+		sw.Detach()
+	}
+	j.outputs = append(j.outputs, sw)
+
+	return sw
+}
+
+// attach start the output of job to terminal.
+func (j *job) attach() {
+	j.setAttached(true)
+}
+
+// detach stop the output of job to terminal.
+func (j *job) detach() {
+	j.setAttached(false)
+}
+
+func (j *job) setAttached(attached bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.attached = attached
+	for _, sw := range j.outputs {
+		if attached {
+			sw.Attach()
+		} else {
+			sw.Detach()
+		}
+	}
+}
+
+// isAttached return true if the output of job is shown.
+func (j *job) isAttached() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.attached
+}
+
+// signal send sig to all sessions of job.
+func (j *job) signal(sig ssh.Signal) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, session := range j.sessions {
+		session.Signal(sig)
+	}
+}
+
+// finish set exit status of job, and notify to `%fg`.
+func (j *job) finish(status bool) {
+	j.mutex.Lock()
+	j.status = status
+	j.mutex.Unlock()
+
+	close(j.finished)
+}
+
+// getStatus return exit status of job.
+func (j *job) getStatus() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.status
+}
+
+// statusLines return status lines of job, and status of each host.
+func (j *job) statusLines() (lines []string) {
+	j.mutex.Lock()
+	var hosts []hostStatus
+	for _, st := range j.hosts {
+		st.mutex.Lock()
+		hosts = append(hosts, *st)
+		st.mutex.Unlock()
+	}
+	j.mutex.Unlock()
+
+	running := 0
+	nameWidth := 4
+	for _, st := range hosts {
+		if st.Running {
+			running++
+		}
+		if len(st.Name) > nameWidth {
+			nameWidth = len(st.Name)
+		}
+	}
+
+	lines = append(lines, fmt.Sprintf("[%d] running (%d/%d hosts)  %s  %%out %d  %s",
+		j.ID, running, len(hosts), time.Since(j.StartTime).Truncate(100*time.Millisecond), j.Count, j.Command))
+	for _, st := range hosts {
+		lines = append(lines, "    "+st.String(nameWidth))
+	}
+
+	return
+}
+
+// buildin_jobs print running jobs.
+func (s *shell) buildin_jobs(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	for _, j := range s.getJobList() {
+		for _, line := range j.statusLines() {
+			fmt.Fprintln(stdout, line)
+		}
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// buildin_fg show the output of job until it finish. If kill is received (Ctrl-C), the job is returned to background.
+func (s *shell) buildin_fg(args []string, out *io.PipeWriter, ch chan<- bool, kill chan bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		if stdout != os.Stdout {
+			return fmt.Errorf("%%fg: can not be used in pipeline")
+		}
+
+		var id string
+		if len(args) > 0 {
+			id = args[0]
+		}
+
+		j, err := s.getJob(id)
+		if err != nil {
+			return fmt.Errorf("%%fg: %s", err)
+		}
+
+		fmt.Printf("[%d] %s\n", j.ID, j.Command)
+
+		j.attach()
+		select {
+		case <-j.finished:
+			status = j.getStatus()
+		case <-kill:
+			j.detach()
+			fmt.Printf("\n[%d] running in background\n", j.ID)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// buildin_wait wait for job to finish. If kill is received (Ctrl-C), stop waiting.
+func (s *shell) buildin_wait(args []string, out *io.PipeWriter, ch chan<- bool, kill chan bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		jobs := s.getJobList()
+		if len(args) > 0 {
+			j, err := s.getJob(args[0])
+			if err != nil {
+				return fmt.Errorf("%%wait: %s", err)
+			}
+			jobs = []*job{j}
+		}
+
+		for _, j := range jobs {
+			select {
+			case <-j.finished:
+				if !j.getStatus() {
+					status = false
+				}
+			case <-kill:
+				status = false
+				return nil
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// buildin_kill send signal to job.
+// example:
+//   - %kill          ... send TERM to latest job
+//   - %kill 2        ... send TERM to job 2
+//   - %kill -KILL 2  ... send KILL to job 2
+func (s *shell) buildin_kill(args []string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		sig := ssh.SIGTERM
+		if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			name := strings.TrimPrefix(strings.ToUpper(args[0][1:]), "SIG")
+			js, ok := jobSignals[name]
+			if !ok {
+				return fmt.Errorf("%%kill: invalid signal `%s`", args[0])
+			}
+			sig = js
+			args = args[1:]
+		}
+
+		var id string
+		if len(args) > 0 {
+			id = args[0]
+		}
+
+		j, err := s.getJob(id)
+		if err != nil {
+			return fmt.Errorf("%%kill: %s", err)
+		}
+
+		j.signal(sig)
+		fmt.Fprintf(stdout, "[%d] send SIG%s\n", j.ID, sig)
+
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
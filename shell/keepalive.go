@@ -10,11 +10,12 @@ import (
 	"sync"
 )
 
+// checkKeepalive check connection of hosts, and remove disconnected hosts.
 func (s *shell) checkKeepalive() {
-	result := []*sConnect{}
+	failed := []*sConnect{}
 	ch := make(chan bool)
 	m := new(sync.Mutex)
-	clients := s.Connects
+	clients := s.getConnects()
 
 	for _, client := range clients {
 		go func(client *sConnect) {
@@ -27,10 +28,12 @@ func (s *shell) checkKeepalive() {
 
 				// close sftp client
 				client.Client.Close()
-			} else {
-				// delete client from map
+
+				s.getHostStatus(client.Name).setConnected(false)
+
+				// remove client from connects
 				m.Lock()
-				result = append(result, client)
+				failed = append(failed, client)
 				m.Unlock()
 			}
 
@@ -43,9 +46,17 @@ func (s *shell) checkKeepalive() {
 		<-ch
 	}
 
-	s.Connects = result
+	// remove disconnected hosts, and drop them from complete data
+	if len(failed) > 0 {
+		s.removeConnects(failed)
+		s.rebuildCommandComplete()
+
+		for _, c := range failed {
+			s.stopPortForwards(c.Name)
+		}
+	}
 
-	if len(clients) == 0 {
+	if len(s.getConnects()) == 0 {
 		s.exit(1, "Error: No valid connections\n")
 	}
 
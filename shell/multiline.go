@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/c-bata/go-prompt"
+	"mvdan.cc/sh/syntax"
+)
+
+// Multi-line input.
+// If the input is incomplete (here-document, unclosed quote, trailing backslash, `for ... do`, etc...),
+// the prompt is switched to continuation prompt (PS2), and lines are accumulated until the command is complete.
+// go-prompt adds history per line, so the history of go-prompt is replaced with the joined command after multi-line input
+// (Up-arrow recalls the whole command).
+
+// readContinuation append line to input buffer, and return whole command.
+// complete is false if the command needs more lines.
+func (s *shell) readContinuation(line string) (command string, complete bool) {
+	s.inputBuffer = append(s.inputBuffer, line)
+	command = strings.Join(s.inputBuffer, "\n")
+
+	if isIncompleteInput(command) {
+		return command, false
+	}
+
+	// history of go-prompt
+	if command != "" {
+		s.promptHistory = append(s.promptHistory, command)
+	}
+	if len(s.inputBuffer) > 1 {
+		s.resetPromptHistory()
+	}
+
+	s.inputBuffer = nil
+	return command, true
+}
+
+// cancelContinuation discard input buffer, bind to Ctrl-C.
+func (s *shell) cancelContinuation(buf *prompt.Buffer) {
+	// canceled lines were added to history of go-prompt
+	if len(s.inputBuffer) > 0 {
+		s.resetPromptHistory()
+	}
+
+	s.inputBuffer = nil
+}
+
+// resetPromptHistory replace the history of go-prompt (per line) with s.promptHistory (multi-line command is joined).
+// Call from Executor or key bind, that are run in the loop of go-prompt.
+func (s *shell) resetPromptHistory() {
+	if s.prompt == nil {
+		return
+	}
+
+	// prompt.Option can be applied to running prompt. It sets history, and clears the history selection.
+	prompt.OptionHistory(append([]string{}, s.promptHistory...))(s.prompt)
+}
+
+// incompleteProbeDepth is max number of compound commands (`for`, `if`, `case`...) closed by probe.
+const incompleteProbeDepth = 16
+
+// compoundEndRegex match parse error of compound command without the end (ex. `"do"`, `"fi"`, `"esac"`).
+var compoundEndRegex = regexp.MustCompile(`(?:must be followed by|must end with) "([a-z]+)"$`)
+
+// isIncompleteInput return true if command is incomplete and needs more lines.
+// Syntax error is not incomplete, except the error at the end of input that can be fixed by more input:
+// unclosed here-document, quote, brace or parenthesis, compound command without the end, and the trailing `|`, `&&`, `||`...
+func isIncompleteInput(command string) bool {
+	// trailing backslash (line continuation)
+	lines := strings.Split(command, "\n")
+	last := lines[len(lines)-1]
+	n := len(last) - len(strings.TrimRight(last, "\\"))
+	if n%2 == 1 {
+		return true
+	}
+
+	return isIncompleteSyntax(command, incompleteProbeDepth)
+}
+
+// isIncompleteSyntax return true if parse error of command is fixed by more input.
+// Compound command without the end is checked by parsing again with the end word (probe), up to depth times.
+func isIncompleteSyntax(command string, depth int) bool {
+	p := syntax.NewParser()
+	_, err := p.Parse(strings.NewReader(command), "")
+	if err == nil {
+		return false
+	}
+
+	if p.Incomplete() {
+		return true
+	}
+
+	var perr syntax.ParseError
+	if !errors.As(err, &perr) {
+		return false
+	}
+
+	switch {
+	// unclosed quote, brace, parenthesis...
+	case strings.HasPrefix(perr.Text, "reached EOF"):
+		return true
+
+	// `|`, `&&`, `||`, `do`, `then`... at the end of input, without the following statement
+	case strings.HasSuffix(perr.Text, "must be followed by a statement"),
+		strings.HasSuffix(perr.Text, "must be followed by a statement list"):
+		return int(perr.Pos.Offset()) >= lastTokenOffset(command)
+
+	// compound command without the end. It is incomplete if the end word fixes it.
+	case compoundEndRegex.MatchString(perr.Text) && depth > 0:
+		word := compoundEndRegex.FindStringSubmatch(perr.Text)[1]
+		probe := command + "\n" + word + "\n:"
+
+		p := syntax.NewParser()
+		if _, err := p.Parse(strings.NewReader(probe), ""); err == nil {
+			return true
+		}
+
+		return isIncompleteSyntax(probe, depth-1)
+	}
+
+	return false
+}
+
+// lastTokenOffset return offset of the last word (space separated) of command.
+func lastTokenOffset(command string) int {
+	trimmed := strings.TrimRight(command, " \t\n")
+	return strings.LastIndexAny(trimmed, " \t\n;") + 1
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"testing"
+)
+
+// TestIsIncompleteInput check that input fixed by more lines is incomplete, and syntax error is not.
+func TestIsIncompleteInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		// complete
+		{input: "", want: false},
+		{input: "echo a", want: false},
+		{input: "echo a | grep a && echo b || echo c", want: false},
+		{input: "cat <<EOF\nabc\nEOF", want: false},
+		{input: "for i in a b; do\necho $i\ndone", want: false},
+		{input: `echo a\\`, want: false},
+
+		// incomplete
+		{input: "cat <<EOF\nabc", want: true},
+		{input: "echo 'a", want: true},
+		{input: `echo "a`, want: true},
+		{input: "echo $(ls", want: true},
+		{input: "{ echo a", want: true},
+		{input: "echo a \\", want: true},
+		{input: "echo a |", want: true},
+		{input: "echo a | ", want: true},
+		{input: "echo a &&", want: true},
+		{input: "echo a ||", want: true},
+		{input: "echo a&&", want: true},
+		{input: "for i in a b; do", want: true},
+		{input: "for i in a b; do\necho $i", want: true},
+		{input: "if true; then", want: true},
+		{input: "if true; then\necho a", want: true},
+		{input: "while true", want: true},
+		{input: "while true; do\necho", want: true},
+		{input: "case a in\na) echo;;", want: true},
+		{input: "for i in a; do\nif true; then\necho", want: true},
+
+		// syntax error
+		{input: "echo a >", want: false},
+		{input: "foo | |", want: false},
+		{input: "foo && && bar", want: false},
+		{input: "for i in a; do done", want: false},
+		{input: "if true; then fi", want: false},
+		{input: "if true; then echo; done", want: false},
+		{input: "case a in\na) echo;; done", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncompleteInput(tt.input); got != tt.want {
+			t.Errorf("isIncompleteInput(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -6,7 +6,9 @@ package shell
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
+	"time"
 
 	"mvdan.cc/sh/syntax"
 )
@@ -14,16 +16,119 @@ import (
 type pipeLine struct {
 	Args    []string
 	Oprator string
+
+	// Source is the original text of this pipeLine, as the user typed.
+	// It is used to rebuild the command line run on remote or local shell.
+	Source string
+
+	// CommandOffset is the offset of command word (Args[0]) in Source.
+	// It is used to delete `!` of local command from Source.
+	CommandOffset int
+
+	// Heredoc is the here-document bodies of this pipeLine (with terminator).
+	// It is written after the command line.
+	Heredoc string
+
+	// RedirectFile is local file path template of `%>` or `%>>` (ex. `${SERVER}.log`).
+	// The output of each host is written to the local file, instead of terminal.
+	RedirectFile string
+
+	// RedirectAppend is true if `%>>`.
+	RedirectAppend bool
+
+	// StdinMode is stdin fan-out mode set by `%stdin <mode>` prefix (rr, chunk, key:N...).
+	// Empty is broadcast.
+	StdinMode string
+
+	// Targets is the hosts set by `@host[,host...]:` prefix. Empty is all hosts.
+	Targets []string
+
+	// Timeout is timeout of remote command set by `%timeout <duration>` prefix.
+	// 0 is the default timeout (config), negative is no timeout.
+	Timeout time.Duration
+
+	// TimeoutDetach is true if `%timeout -d`. Hosts not finished in timeout are detached to background, instead of kill.
+	TimeoutDetach bool
+
+	// Strategy is execution strategy set by `%canary`, `%rolling` or `%serial` prefix.
+	// Empty is run on all hosts at once.
+	Strategy string
+
+	// BatchSize is number of hosts of canary, or of each batch.
+	BatchSize int
+
+	// BatchPause is pause between batches set by `%rolling -p`. 0 is the default (config), negative is no pause.
+	BatchPause time.Duration
+
+	// BatchCheck is health check command between batches set by `%rolling -c`. Empty is the default (config).
+	BatchCheck string
+
+	// Batch is the batch of strategy running this pipeLine, set by runStrategy. nil is not batch.
+	Batch *batchInfo
+
+	// Condition is the operator before this pipeline (`&&` or `||`).
+	// Set only to the first pipeLine of pipeline, evaluated by lsshell.
+	Condition string
+
+	// Background is true if the pipeline is run as background job (`command &`).
+	// Set only to the first pipeLine of pipeline.
+	Background bool
+
+	// Job is the background job running this pipeLine, set by startJob. nil is foreground.
+	Job *job
+
+	// Compound is true if Args[0] is a statement (for, if, `( ... )`, etc...) without local command.
+	// It is executed on remote shell as it is.
+	Compound bool
 }
 
 // pipeLine return string of join
 func (p *pipeLine) String() string {
-	result := strings.Join(p.Args, " ")
+	result := p.Source
+	if result == "" {
+		result = strings.Join(p.Args, " ")
+	}
 	result = result + " " + p.Oprator
 
 	return result
 }
 
+// Command return command line string of pipeLine, to run on shell.
+// If Source is empty (created by build-in command), Args is joined with space.
+func (p *pipeLine) Command() string {
+	result := p.Source
+	if result == "" {
+		result = strings.Join(p.Args, " ")
+	}
+
+	if p.Heredoc != "" {
+		result = result + "\n" + p.Heredoc + "\n"
+	}
+
+	return result
+}
+
+// LocalCommand return command line of local command, without `!` prefix.
+// `!` is deleted from Source as the user typed, so quoted command word (ex. `!"echo"`, `"!echo"`) is kept as it is.
+func (p *pipeLine) LocalCommand() string {
+	command := p.Command()
+	if len(p.Args) == 0 || !strings.HasPrefix(p.Args[0], "!") {
+		return command
+	}
+
+	offset := p.CommandOffset
+	if offset < 0 || offset > len(command) {
+		offset = 0
+	}
+
+	i := strings.Index(command[offset:], "!")
+	if i < 0 {
+		return command
+	}
+
+	return command[:offset+i] + command[offset+i+1:]
+}
+
 // joinPipeLineSlice
 func joinPipeLineSlice(pslice []pipeLine) string {
 	var result string
@@ -42,28 +147,42 @@ func joinPipeLine(pslice []pipeLine) []pipeLine {
 	result := []pipeLine{}
 
 	for _, pline := range pslice {
-		// get command
-		cmd := pline.Args[0]
-
 		// check in local or build-in command
-		isLocal := checkLocalBuildInCommand(cmd)
+		isLocal := pline.isLocal()
 		switch {
+		case len(bpline.Args) == 0:
+			bpline = pline
+			beforeLocal = isLocal
 		case isLocal:
-			if len(bpline.Args) > 0 {
-				result = append(result, bpline)
-			}
+			result = append(result, bpline.boundary())
 			bpline = pline
 			beforeLocal = true
 		case !isLocal && beforeLocal: // RemoteCommand で前がLocalの場合
-			if len(bpline.Args) > 0 {
-				result = append(result, bpline)
-			}
+			result = append(result, bpline.boundary())
+			bpline = pline
+			beforeLocal = false
+		case !isLocal && !beforeLocal && !equalTargets(bpline.Targets, pline.Targets): // 実行先ホストが異なる場合
+			result = append(result, bpline.boundary())
 			bpline = pline
 			beforeLocal = false
 		case !isLocal && !beforeLocal: // RemoteCommandで前がRemoteの場合
 			// append bpline
 			bpline.Args = append(bpline.Args, bpline.Oprator)
 			bpline.Args = append(bpline.Args, pline.Args...)
+			bpline.Source = bpline.Source + " " + bpline.Oprator + " " + pline.Source
+			bpline.Heredoc = joinHeredoc(bpline.Heredoc, pline.Heredoc)
+			bpline.RedirectFile = pline.RedirectFile
+			bpline.RedirectAppend = pline.RedirectAppend
+			if bpline.Timeout == 0 {
+				bpline.Timeout = pline.Timeout
+				bpline.TimeoutDetach = pline.TimeoutDetach
+			}
+			if bpline.Strategy == "" {
+				bpline.Strategy = pline.Strategy
+				bpline.BatchSize = pline.BatchSize
+				bpline.BatchPause = pline.BatchPause
+				bpline.BatchCheck = pline.BatchCheck
+			}
 			bpline.Oprator = pline.Oprator
 			beforeLocal = false
 		}
@@ -73,7 +192,72 @@ func joinPipeLine(pslice []pipeLine) []pipeLine {
 	return result
 }
 
+// isLocal return true if pipeLine is local command or build-in command.
+func (p *pipeLine) isLocal() bool {
+	if p.Compound || len(p.Args) == 0 {
+		return false
+	}
+
+	return checkLocalBuildInCommand(p.Args[0])
+}
+
+// hasLocalPipeLine return true if pline include local or build-in command.
+func hasLocalPipeLine(pline []pipeLine) bool {
+	for _, p := range pline {
+		if p.isLocal() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinHeredoc join here-document bodies.
+func joinHeredoc(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+
+	return a + "\n" + b
+}
+
+// equalTargets return true if a and b is the same target hosts.
+func equalTargets(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// isTargetPrefix return true if word is target host prefix (`@host[,host...]:`).
+func isTargetPrefix(word string) bool {
+	return len(word) > 2 && strings.HasPrefix(word, "@") && strings.HasSuffix(word, ":")
+}
+
+// parseTargetPrefix return target hosts of prefix (`@host[,host...]:`).
+func parseTargetPrefix(word string) (targets []string) {
+	for _, t := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(word, "@"), ":"), ",") {
+		if t != "" {
+			targets = append(targets, t)
+		}
+	}
+
+	return
+}
+
+// boundary return pipeLine as a boundary of local and remote command.
+// `|&` is treated as `|`, because stderr is not passed through lsshell.
+func (p pipeLine) boundary() pipeLine {
+	if p.Oprator == "|&" {
+		p.Oprator = "|"
+	}
+
+	return p
+}
+
 // parseCmdPipeLine return [][]pipeLine.
+// Statements without local command are executed on remote shell as it is.
+// Statements with local command are split into pipelines, and evaluated by lsshell.
 func parsePipeLine(command string) (pslice [][]pipeLine, err error) {
 	// Create result pipeLineSlice
 	pslice = [][]pipeLine{}
@@ -87,70 +271,569 @@ func parsePipeLine(command string) (pslice [][]pipeLine, err error) {
 
 	// parse stmt
 	for _, stmt := range f.Stmts {
-		// create slice
-		var cmdLine []pipeLine
-
-		// create stmtCmd, stmtRedirs
-		var stmtCmd syntax.Command
-		var stmtRedirs []*syntax.Redirect
-		stmtCmd = stmt.Cmd
-		stmtRedirs = stmt.Redirs
-
-		// parse stmt loop
-	stmtCmdLoop:
-		for {
-			switch c := stmtCmd.(type) {
-			case *syntax.CallExpr:
-				args := parseCallExpr(c)
-
-				args = append(args, parseRedirect(stmtRedirs)...)
-				pLine := pipeLine{
-					Args: args,
+		var ps [][]pipeLine
+		ps, err = parseStmt(command, stmt, "")
+		if err != nil {
+			return [][]pipeLine{}, err
+		}
+
+		pslice = append(pslice, ps...)
+	}
+
+	return
+}
+
+// parseStmt return pipelines of stmt. src is the parsed command line,
+// cond is the operator before stmt (`&&`, `||`).
+func parseStmt(src string, stmt *syntax.Stmt, cond string) (pslice [][]pipeLine, err error) {
+	isLocal := hasLocalCommand(stmt)
+
+	// background statement is run as background job by lsshell.
+	if stmt.Background && !stmt.Negated && !stmt.Coprocess {
+		fg := *stmt
+		fg.Background = false
+
+		pslice, err = parseStmt(src, &fg, cond)
+		if err != nil {
+			return
+		}
+
+		if len(pslice) != 1 || hasLocalPipeLine(pslice[0]) {
+			err = fmt.Errorf("lsshell: local command in background statement is not supported")
+			return
+		}
+
+		if strategyIndex(pslice[0]) >= 0 {
+			err = fmt.Errorf("lsshell: execution strategy in background statement is not supported")
+			return
+		}
+
+		pslice[0][0].Background = true
+		return
+	}
+
+	// negated, background, coprocess statement
+	if stmt.Negated || stmt.Background || stmt.Coprocess {
+		if isLocal {
+			err = fmt.Errorf("lsshell: local command in negated or background statement is not supported")
+			return
+		}
+
+		pslice = [][]pipeLine{{newCompoundPipeLine(src, stmt, cond)}}
+		return
+	}
+
+	switch c := stmt.Cmd.(type) {
+	case *syntax.CallExpr:
+		if len(c.Args) > 0 {
+			var pLine pipeLine
+			pLine, err = newPipeLine(src, stmt, c)
+			if err != nil {
+				return
+			}
+
+			err = checkPipeLine([]pipeLine{pLine})
+			if err != nil {
+				return
+			}
+
+			pLine.Condition = cond
+			pslice = [][]pipeLine{{pLine}}
+			return
+		}
+
+	case *syntax.BinaryCmd:
+		switch c.Op {
+		case syntax.Pipe, syntax.PipeAll:
+			var cmdLine []pipeLine
+			cmdLine, err = parsePipeStmt(src, stmt)
+			if err != nil {
+				return
+			}
+
+			err = checkPipeLine(cmdLine)
+			if err != nil {
+				return
+			}
+
+			// remote stage without target host run on the same hosts as before stage.
+			for i := 1; i < len(cmdLine); i++ {
+				if len(cmdLine[i].Targets) == 0 && !cmdLine[i].isLocal() && !cmdLine[i-1].isLocal() {
+					cmdLine[i].Targets = cmdLine[i-1].Targets
+				}
+			}
+
+			cmdLine[0].Condition = cond
+			pslice = [][]pipeLine{cmdLine}
+			return
+
+		case syntax.AndStmt, syntax.OrStmt:
+			if !isLocal {
+				break
+			}
+
+			// evaluate `&&`, `||` by lsshell
+			var x, y [][]pipeLine
+			x, err = parseStmt(src, c.X, cond)
+			if err != nil {
+				return
+			}
+
+			y, err = parseStmt(src, c.Y, c.Op.String())
+			if err != nil {
+				return
+			}
+
+			pslice = append(x, y...)
+			return
+		}
+
+	case *syntax.Subshell, *syntax.Block:
+		if !isLocal {
+			break
+		}
+
+		// evaluate group by lsshell (statements are run in order)
+		switch {
+		case cond != "":
+			err = fmt.Errorf("lsshell: local command in group after `%s` is not supported", cond)
+			return
+		case len(stmt.Redirs) > 0:
+			err = fmt.Errorf("lsshell: redirect of group with local command is not supported")
+			return
+		}
+
+		var stmts []*syntax.Stmt
+		switch g := c.(type) {
+		case *syntax.Subshell:
+			stmts = g.Stmts
+		case *syntax.Block:
+			stmts = g.Stmts
+		}
+
+		for _, st := range stmts {
+			var ps [][]pipeLine
+			ps, err = parseStmt(src, st, "")
+			if err != nil {
+				return
+			}
+			pslice = append(pslice, ps...)
+		}
+		return
+	}
+
+	if isLocal {
+		err = fmt.Errorf("lsshell: local command in %s is not supported", getStmtName(stmt))
+		return
+	}
+
+	pslice = [][]pipeLine{{newCompoundPipeLine(src, stmt, cond)}}
+	return
+}
+
+// parsePipeStmt return pipeLine elements of pipe statement (`a | b | c`).
+func parsePipeStmt(src string, stmt *syntax.Stmt) (cmdLine []pipeLine, err error) {
+	for {
+		var pLine pipeLine
+
+		b, ok := stmt.Cmd.(*syntax.BinaryCmd)
+		if !ok || (b.Op != syntax.Pipe && b.Op != syntax.PipeAll) {
+			pLine, err = parsePipeElement(src, stmt)
+			if err != nil {
+				return
+			}
+
+			cmdLine = append(cmdLine, pLine)
+			return
+		}
+
+		pLine, err = parsePipeElement(src, b.X)
+		if err != nil {
+			return
+		}
+
+		pLine.Oprator = b.Op.String()
+		cmdLine = append(cmdLine, pLine)
+
+		stmt = b.Y
+	}
+}
+
+// parsePipeElement return pipeLine of a element in pipe.
+func parsePipeElement(src string, stmt *syntax.Stmt) (pLine pipeLine, err error) {
+	if c, ok := stmt.Cmd.(*syntax.CallExpr); ok && len(c.Args) > 0 {
+		pLine, err = newPipeLine(src, stmt, c)
+		return
+	}
+
+	if hasLocalCommand(stmt) {
+		err = fmt.Errorf("lsshell: local command in %s in pipe is not supported", getStmtName(stmt))
+		return
+	}
+
+	pLine = newCompoundPipeLine(src, stmt, "")
+	return
+}
+
+// newPipeLine return pipeLine of simple command.
+func newPipeLine(src string, stmt *syntax.Stmt, cmd *syntax.CallExpr) (pLine pipeLine, err error) {
+	source, heredoc := getStmtSource(src, stmt)
+	start := int(stmt.Pos().Offset()) // offset of source in src
+
+	args := cmd.Args
+	redirs := stmt.Redirs
+
+	// trimPrefix delete first n args from source and args.
+	trimPrefix := func(n int) {
+		source = source[int(args[n].Pos().Offset())-start:]
+		start = int(args[n].Pos().Offset())
+		args = args[n:]
+	}
+
+	// command prefix. Without command (while typing), it is left as build-in command.
+	//   - `@host[,host...]: command...` ... target host
+	//   - `%stdin <mode> command...`    ... stdin fan-out mode
+	//   - `%timeout [-d] <duration> command...` ... timeout of command
+	//   - `%canary N command...`, `%rolling [-p pause] [-c check] N command...`, `%serial command...` ... execution strategy
+prefix:
+	for {
+		switch {
+		case len(args) > 1 && isTargetPrefix(args[0].Lit()):
+			pLine.Targets = parseTargetPrefix(args[0].Lit())
+			trimPrefix(1)
+
+		case len(args) > 2 && args[0].Lit() == "%stdin":
+			pLine.StdinMode = args[1].Lit()
+			err = checkStdinMode(pLine.StdinMode)
+			if err != nil {
+				return
+			}
+
+			trimPrefix(2)
+
+		case len(args) > 3 && args[0].Lit() == "%timeout" && args[1].Lit() == "-d":
+			pLine.TimeoutDetach = true
+			pLine.Timeout, err = parseTimeout(args[2].Lit())
+			if err != nil {
+				return
+			}
+
+			trimPrefix(3)
+
+		case len(args) > 2 && args[0].Lit() == "%timeout" && args[1].Lit() != "-d":
+			pLine.Timeout, err = parseTimeout(args[1].Lit())
+			if err != nil {
+				return
+			}
+
+			trimPrefix(2)
+
+		case isStrategyPrefix(args[0].Lit()):
+			var n int
+			n, err = parseStrategyPrefix(&pLine, args)
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				break prefix
+			}
+
+			trimPrefix(n)
+
+		default:
+			break prefix
+		}
+	}
+
+	// local redirect (`%>`, `%>>`)
+	if w, r := findLocalRedirect(stmt); r != nil {
+		pLine.RedirectFile, err = getWordValue(r.Word)
+		if err != nil {
+			return
+		}
+		pLine.RedirectAppend = r.Op == syntax.AppOut
+
+		// delete `%> file` from source, args and redirects
+		removed := len(source)
+		source = strings.TrimRight(source[:int(w.Pos().Offset())-start], " ") + source[int(r.Word.End().Offset())-start:]
+		removed -= len(source)
+
+		var as []*syntax.Word
+		for _, a := range args {
+			if a != w {
+				as = append(as, a)
+			}
+		}
+		args = as
+
+		// `%> file` before command word
+		if len(args) > 0 && args[0].Pos().Offset() > w.Pos().Offset() {
+			start += removed
+		}
+
+		var rs []*syntax.Redirect
+		for _, rr := range redirs {
+			if rr != r {
+				rs = append(rs, rr)
+			}
+		}
+		redirs = rs
+	}
+
+	pLine.Args = append(parseCallExpr(&syntax.CallExpr{Assigns: cmd.Assigns, Args: args}), parseRedirect(redirs)...)
+	pLine.Source = source
+	pLine.Heredoc = heredoc
+	if len(args) > 0 && len(cmd.Assigns) == 0 {
+		pLine.CommandOffset = int(args[0].Pos().Offset()) - start
+	}
+
+	return
+}
+
+// findLocalRedirect return local redirect (`%>`, `%>>`) of stmt, and the word `%` before it.
+// `%>` is parsed as word `%` and redirect `>`, so `%` immediately before the redirect operator is checked.
+func findLocalRedirect(stmt *syntax.Stmt) (percent *syntax.Word, redirect *syntax.Redirect) {
+	c, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok {
+		return
+	}
+
+	for _, r := range stmt.Redirs {
+		if r.N != nil || (r.Op != syntax.RdrOut && r.Op != syntax.AppOut) {
+			continue
+		}
+
+		for i, arg := range c.Args {
+			if i > 0 && arg.Lit() == "%" && arg.End().Offset() == r.OpPos.Offset() {
+				return arg, r
+			}
+		}
+	}
+
+	return
+}
+
+// checkPipeLine check local redirect (`%>`, `%>>`) and `%stdin` in pipeline.
+//   - local redirect can be used only at the end of pipeline, and only with remote command.
+//   - `%stdin` can be used only with remote command receiving stdin from local (the first, or after local command).
+//   - target host (`@host:`) and `%timeout` can be used only with remote command.
+//   - execution strategy (`%canary`, `%rolling`, `%serial`) can be used only once in pipeline, and only with remote command.
+func checkPipeLine(cmdLine []pipeLine) error {
+	strategy := ""
+	for i, p := range cmdLine {
+		if p.Strategy != "" {
+			switch {
+			case p.isLocal():
+				return fmt.Errorf("lsshell: %%%s of local command is not supported", p.Strategy)
+			case strategy != "":
+				return fmt.Errorf("lsshell: only one execution strategy (%%%s, %%%s) can be used in pipeline", strategy, p.Strategy)
+			}
+			strategy = p.Strategy
+		}
+
+		if p.RedirectFile != "" {
+			switch {
+			case i < len(cmdLine)-1:
+				return fmt.Errorf("lsshell: local redirect (`%%>`) must be at the end of pipeline")
+			case p.isLocal():
+				return fmt.Errorf("lsshell: local redirect (`%%>`) of local command is not supported, use `>`")
+			}
+		}
+
+		if len(p.Targets) > 0 && p.isLocal() {
+			return fmt.Errorf("lsshell: target host (`@host:`) of local command is not supported")
+		}
+
+		if p.Timeout != 0 && p.isLocal() {
+			return fmt.Errorf("lsshell: %%timeout of local command is not supported")
+		}
+
+		if p.StdinMode != "" {
+			switch {
+			case p.isLocal():
+				return fmt.Errorf("lsshell: %%stdin of local command is not supported")
+			case i > 0 && !cmdLine[i-1].isLocal() && equalTargets(cmdLine[i-1].Targets, p.Targets):
+				return fmt.Errorf("lsshell: %%stdin must be after local command or other target host in pipeline")
+			}
+		}
+	}
+
+	return nil
+}
+
+// getWordValue return value of word without quotes.
+// Parameter expansion is kept as `${NAME}` (expanded as template variable later).
+func getWordValue(word *syntax.Word) (value string, err error) {
+	var getParts func(parts []syntax.WordPart) error
+	getParts = func(parts []syntax.WordPart) error {
+		for _, part := range parts {
+			switch p := part.(type) {
+			case *syntax.Lit:
+				value += p.Value
+			case *syntax.SglQuoted:
+				value += p.Value
+			case *syntax.DblQuoted:
+				if err := getParts(p.Parts); err != nil {
+					return err
 				}
-				cmdLine = append(cmdLine, pLine)
-
-				break stmtCmdLoop
-			case *syntax.BinaryCmd:
-				switch c.X.Cmd.(type) {
-				case *syntax.CallExpr:
-					cx := c.X.Cmd.(*syntax.CallExpr)
-					cxr := c.X.Redirs
-
-					args := parseCallExpr(cx)
-					args = append(args, parseRedirect(cxr)...)
-
-					pLine := pipeLine{
-						Args:    args,
-						Oprator: c.Op.String(),
-					}
-					cmdLine = append(cmdLine, pLine)
-					stmtCmd = c.Y.Cmd
-					stmtRedirs = c.Y.Redirs
-
-				case *syntax.BinaryCmd: // TODO(blacknon): &&や||に対応させる(対処方法がわからん…)
-					stmtCmd = c.X.Cmd
-					stmtRedirs = c.X.Redirs
+			case *syntax.ParamExp:
+				if p.Param == nil || p.Excl || p.Length || p.Width || p.Names != 0 || p.Index != nil || p.Slice != nil || p.Repl != nil || p.Exp != nil {
+					return fmt.Errorf("lsshell: unsupported expansion in local redirect file name")
 				}
+				value += "${" + p.Param.Value + "}"
+			default:
+				return fmt.Errorf("lsshell: unsupported expansion in local redirect file name")
+			}
+		}
+		return nil
+	}
+
+	err = getParts(word.Parts)
+	return
+}
+
+// newCompoundPipeLine return pipeLine to execute stmt on remote shell as it is.
+func newCompoundPipeLine(src string, stmt *syntax.Stmt, cond string) pipeLine {
+	source, heredoc := getStmtSource(src, stmt)
+
+	return pipeLine{
+		Args:      []string{source},
+		Source:    source,
+		Heredoc:   heredoc,
+		Condition: cond,
+		Compound:  true,
+	}
+}
+
+// getStmtSource return original text of stmt in src, and the here-document bodies after it.
+// The here-document body of stmt in pipe is written after the whole line
+// (`cat <<EOF | grep a\n...\nEOF`), so it is separated from source.
+func getStmtSource(src string, stmt *syntax.Stmt) (source, heredoc string) {
+	start := int(stmt.Pos().Offset())
+	end := start
+
+	var hdocs []*syntax.Word
+	syntax.Walk(stmt, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case nil:
+			return false
+
+		case *syntax.Stmt:
+			// Stmt.End() include here-document body. only `&` and `|&` is checked.
+			if n.Background || n.Coprocess {
+				end = maxOffset(end, n.End())
+			}
+			return true
+
+		case *syntax.Redirect:
+			end = maxOffset(end, n.Word.End())
+			if n.Hdoc != nil {
+				hdocs = append(hdocs, n.Hdoc)
 			}
+			return false
 		}
 
-		pslice = append(pslice, cmdLine)
+		end = maxOffset(end, node.End())
+		return true
+	})
+
+	source = src[start:end]
+
+	// here-document bodies not included in source
+	var bodies []string
+	for _, h := range hdocs {
+		if int(h.Pos().Offset()) >= end {
+			bodies = append(bodies, src[h.Pos().Offset():h.End().Offset()])
+		}
 	}
+	heredoc = strings.Join(bodies, "\n")
 
 	return
 }
 
+// maxOffset return larger of offset and pos.
+func maxOffset(offset int, pos syntax.Pos) int {
+	if int(pos.Offset()) > offset {
+		return int(pos.Offset())
+	}
+
+	return offset
+}
+
+// hasLocalCommand return true if node includes local command (`!command`), `%` build-in command,
+// local redirect (`%>`) or target host prefix (`@host:`).
+func hasLocalCommand(node syntax.Node) (result bool) {
+	printer := syntax.NewPrinter()
+
+	syntax.Walk(node, func(n syntax.Node) bool {
+		// local redirect (`%>`, `%>>`)
+		if st, ok := n.(*syntax.Stmt); ok {
+			if _, r := findLocalRedirect(st); r != nil {
+				result = true
+			}
+			return !result
+		}
+
+		c, ok := n.(*syntax.CallExpr)
+		if !ok || len(c.Args) == 0 {
+			return !result
+		}
+
+		buf := new(bytes.Buffer)
+		printer.Print(buf, c.Args[0])
+		cmd := buf.String()
+
+		if checkLocalCommand(cmd) || (strings.HasPrefix(cmd, "%") && checkBuildInCommand(cmd)) || isTargetPrefix(cmd) {
+			result = true
+		}
+
+		return !result
+	})
+
+	return
+}
+
+// getStmtName return name of statement type, for error message.
+func getStmtName(stmt *syntax.Stmt) string {
+	switch c := stmt.Cmd.(type) {
+	case *syntax.IfClause:
+		return "`if` clause"
+	case *syntax.WhileClause:
+		if c.Until {
+			return "`until` clause"
+		}
+		return "`while` clause"
+	case *syntax.ForClause:
+		if c.Select {
+			return "`select` clause"
+		}
+		return "`for` clause"
+	case *syntax.CaseClause:
+		return "`case` clause"
+	case *syntax.FuncDecl:
+		return "function"
+	case *syntax.Subshell:
+		return "subshell"
+	case *syntax.Block:
+		return "block"
+	case *syntax.TimeClause:
+		return "`time` clause"
+	case *syntax.CoprocClause:
+		return "`coproc` clause"
+	default:
+		return "statement"
+	}
+}
+
 // parseCallExpr return pipeline element ([]string).
+// Each element is a whole word (e.g. `"foo"bar` is one element).
 func parseCallExpr(cmd *syntax.CallExpr) (pLine []string) {
 	printer := syntax.NewPrinter()
 
 	for _, arg := range cmd.Args {
-		for _, part := range arg.Parts {
-			buf := new(bytes.Buffer)
-			printer.Print(buf, part)
-			pLine = append(pLine, buf.String())
-
-		}
+		buf := new(bytes.Buffer)
+		printer.Print(buf, arg)
+		pLine = append(pLine, buf.String())
 	}
 	return
 }
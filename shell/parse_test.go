@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"testing"
+)
+
+// TestParsePipeLineRemoteCommand check that the command line rebuilt from source is the same as the input.
+func TestParsePipeLineRemoteCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // empty is the same as input
+	}{
+		{name: "double quote", input: `echo "foo"bar`},
+		{name: "single quote", input: `echo 'a b'`},
+		{name: "escaped dollar", input: `echo \$x`},
+		{name: "command substitution", input: `echo $(cmd "x")`},
+		{name: "parameter", input: `echo "${HOME}" $PATH`},
+		{name: "glob", input: `ls *.log`},
+		{name: "glob in pipe", input: `ls /var/log/*.log | grep -v 'a b'`},
+		{name: "redirect fd dup", input: `cmd 2>&1`},
+		{name: "redirect fd input", input: `cmd 3<in`},
+		{name: "redirect append", input: `cmd >>out`},
+		{name: "redirect mixed", input: `cmd 3<in >>out 2>&1`},
+		{name: "heredoc", input: "cat <<EOF\nhello $x \"a\"\nEOF", want: "cat <<EOF\nhello $x \"a\"\nEOF\n"},
+		{name: "heredoc quoted strip tabs", input: "cat <<-'EOF'\n\thello $x\n\tEOF", want: "cat <<-'EOF'\n\thello $x\n\tEOF\n"},
+		{name: "heredoc in pipe", input: "cat <<EOF | grep a\nabc\nEOF", want: "cat <<EOF | grep a\nabc\nEOF\n"},
+		{name: "target prefix", input: `@web01: echo 'a b' 2>&1`, want: `echo 'a b' 2>&1`},
+		{name: "timeout prefix", input: `%timeout 5s ls *.log`, want: `ls *.log`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.input
+			}
+
+			pslice, err := parsePipeLine(tt.input)
+			if err != nil {
+				t.Fatalf("parsePipeLine(%q) error: %s", tt.input, err)
+			}
+
+			if len(pslice) != 1 {
+				t.Fatalf("parsePipeLine(%q) = %d pipelines, want 1", tt.input, len(pslice))
+			}
+
+			pline := joinPipeLine(pslice[0])
+			if len(pline) != 1 {
+				t.Fatalf("joinPipeLine(%q) = %d elements, want 1", tt.input, len(pline))
+			}
+
+			if pline[0].isLocal() {
+				t.Fatalf("parsePipeLine(%q) is local command", tt.input)
+			}
+
+			if got := pline[0].Command(); got != want {
+				t.Errorf("Command() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestParsePipeLineLocalCommand check that `!` of local command is deleted, and the rest is the same as the input.
+func TestParsePipeLineLocalCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // command line of each element (local command without `!`)
+	}{
+		{name: "simple", input: `!echo "a b"`, want: []string{`echo "a b"`}},
+		{name: "quoted command word", input: `!"echo" hi`, want: []string{`"echo" hi`}},
+		{name: "single quoted command word", input: `!'echo' 'a b'`, want: []string{`'echo' 'a b'`}},
+		{name: "bang in argument", input: `!echo !x`, want: []string{`echo !x`}},
+		{name: "redirect", input: `!cmd 3<in >>out 2>&1`, want: []string{`cmd 3<in >>out 2>&1`}},
+		{name: "heredoc", input: "!cat <<EOF\n!x\nEOF", want: []string{"cat <<EOF\n!x\nEOF\n"}},
+		{name: "remote to local", input: `echo 'a b' | !grep "a"`, want: []string{`echo 'a b'`, `grep "a"`}},
+		{name: "local to remote", input: `!cat *.log | grep -c x 2>&1`, want: []string{`cat *.log`, `grep -c x 2>&1`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pslice, err := parsePipeLine(tt.input)
+			if err != nil {
+				t.Fatalf("parsePipeLine(%q) error: %s", tt.input, err)
+			}
+
+			if len(pslice) != 1 {
+				t.Fatalf("parsePipeLine(%q) = %d pipelines, want 1", tt.input, len(pslice))
+			}
+
+			pline := joinPipeLine(pslice[0])
+			if len(pline) != len(tt.want) {
+				t.Fatalf("joinPipeLine(%q) = %d elements, want %d", tt.input, len(pline), len(tt.want))
+			}
+
+			for i, p := range pline {
+				got := p.Command()
+				if p.isLocal() {
+					got = p.LocalCommand()
+				}
+
+				if got != tt.want[i] {
+					t.Errorf("element %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
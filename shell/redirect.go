@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// localRedirect is local files of `%>`, `%>>`.
+// Hosts writing to the same file share one file, and the output is written per line (byte-for-byte, without line length limit).
+type localRedirect struct {
+	appendMode bool
+	files      map[string]*os.File
+	mutex      *sync.Mutex
+	wg         *sync.WaitGroup
+}
+
+// newLocalRedirect return new localRedirect.
+func newLocalRedirect(appendMode bool) *localRedirect {
+	return &localRedirect{
+		appendMode: appendMode,
+		files:      map[string]*os.File{},
+		mutex:      new(sync.Mutex),
+		wg:         new(sync.WaitGroup),
+	}
+}
+
+// NewWriter return writer to the local file of path.
+// If header is not empty, it is added to the beginning of each line.
+func (r *localRedirect) NewWriter(path string, header string) (writer *io.PipeWriter, err error) {
+	file, ok := r.files[path]
+	if !ok {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if r.appendMode {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		file, err = os.OpenFile(path, flag, 0644)
+		if err != nil {
+			return
+		}
+		r.files[path] = file
+	}
+
+	reader, writer := io.Pipe()
+
+	w := &redirectLineWriter{file: file, mutex: r.mutex, header: header}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		io.Copy(w, reader)
+		w.Flush()
+
+		// discard the rest (write error), not to block the writer.
+		io.Copy(io.Discard, reader)
+	}()
+
+	return
+}
+
+// Close wait for all writers, and close files.
+// Call after all writers are closed.
+func (r *localRedirect) Close() {
+	r.wg.Wait()
+
+	for _, file := range r.files {
+		file.Close()
+	}
+}
+
+// redirectLineWriter write complete lines to file with lock, so that lines of hosts sharing the file are not mixed.
+// If header is not empty, it is added to the beginning of each line.
+type redirectLineWriter struct {
+	file   *os.File
+	mutex  *sync.Mutex
+	header string
+	buf    []byte // incomplete line
+}
+
+// Write write complete lines of p, and keep the incomplete last line until the next Write or Flush.
+func (w *redirectLineWriter) Write(p []byte) (n int, err error) {
+	// search newline only in p, not to scan the long incomplete line again
+	i := bytes.LastIndexByte(p, '\n')
+	if i >= 0 {
+		i += len(w.buf)
+	}
+
+	w.buf = append(w.buf, p...)
+	if i < 0 {
+		return len(p), nil
+	}
+
+	err = w.write(w.buf[:i+1])
+	w.buf = append(w.buf[:0], w.buf[i+1:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush write the incomplete last line as is (without newline).
+func (w *redirectLineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.write(w.buf)
+	w.buf = nil
+
+	return err
+}
+
+// write write data to file, with header at the beginning of each line.
+func (w *redirectLineWriter) write(data []byte) error {
+	if w.header != "" {
+		var b bytes.Buffer
+		for len(data) > 0 {
+			line := data
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				line = data[:i+1]
+			}
+
+			b.WriteString(w.header + " ")
+			b.Write(line)
+			data = data[len(line):]
+		}
+		data = b.Bytes()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := w.file.Write(data)
+	return err
+}
+
+// getRedirectPath return local redirect file path of c.
+// `${SERVER}`, `${COUNT}` in templete are replaced, and other variables are expanded by local environment.
+func (s *shell) getRedirectPath(c *sConnect, templete string) string {
+	path := templete
+	path = strings.Replace(path, "${SERVER}", c.Name, -1)
+	path = strings.Replace(path, "${COUNT}", strconv.Itoa(s.Count), -1)
+	path = os.ExpandEnv(path)
+
+	if strings.HasPrefix(path, "~/") {
+		usr, _ := user.Current()
+		path = strings.Replace(path, "~", usr.HomeDir, 1)
+	}
+
+	return path
+}
+
+// getRedirectHeader return header of each line written to local redirect file.
+// It is OPROMPT without color. If redirectheader is false in config, return empty.
+func (s *shell) getRedirectHeader(c *sConnect) (header string) {
+	if !s.ExtConfig.Shell.RedirectHeader {
+		return
+	}
+
+	header = s.Config.OPrompt
+	if header == "" {
+		header = defaultOPrompt
+	}
+	header = strings.Replace(header, "${SERVER}", c.Name, -1)
+	header = strings.Replace(header, "${ADDR}", c.Output.Conf.Addr, -1)
+	header = strings.Replace(header, "${USER}", c.Output.Conf.User, -1)
+	header = strings.Replace(header, "${PORT}", c.Output.Conf.Port, -1)
+	header = strings.Replace(header, "${COUNT}", strconv.Itoa(s.Count), -1)
+
+	return
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blacknon/lssh/conf"
+	"github.com/c-bata/go-prompt"
+	runewidth "github.com/mattn/go-runewidth"
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Host selector.
+// `%select` (or Ctrl-O at the prompt) re-opens the server list as lssh, with the current hosts selected.
+// On Enter, newly selected hosts are connected and deselected hosts are disconnected.
+// Unlike the list of lssh, Esc and Ctrl-C return to lsshell without change.
+
+// hostSelector is TUI server list of `%select`.
+type hostSelector struct {
+	prompt   string
+	header   string
+	names    []string          // all server names
+	lines    map[string]string // list line of server
+	selected map[string]bool
+
+	keyword string
+	view    []string // server names matching keyword
+	cursor  int
+}
+
+// newHostSelector return hostSelector of servers in config, and selected servers.
+func newHostSelector(config conf.Config, selected []string) *hostSelector {
+	names := conf.GetNameList(config)
+	sort.Strings(names)
+
+	h := &hostSelector{
+		prompt:   "lsshell>>",
+		names:    names,
+		lines:    map[string]string{},
+		selected: map[string]bool{},
+	}
+
+	for _, name := range selected {
+		h.selected[name] = true
+	}
+
+	// create list text (same columns as lssh)
+	buf := new(bytes.Buffer)
+	tw := tabwriter.NewWriter(buf, 0, 4, 8, ' ', 0)
+	fmt.Fprintln(tw, "ServerName \tConnect Information \tNote \t")
+	for _, name := range names {
+		sc := config.Server[name]
+		fmt.Fprintln(tw, oneLine(name)+"\t"+oneLine(sc.User+"@"+sc.Addr)+"\t"+oneLine(sc.Note))
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	h.header = lines[0]
+	for i, name := range names {
+		h.lines[name] = lines[i+1]
+	}
+
+	h.filter()
+
+	return h
+}
+
+// oneLine replace newline of str to space.
+func oneLine(str string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(str)
+}
+
+// filter update view with keyword (space separated, ignore case).
+func (h *hostSelector) filter() {
+	keywords := strings.Fields(strings.ToLower(h.keyword))
+
+	h.view = []string{}
+	for _, name := range h.names {
+		line := strings.ToLower(h.lines[name])
+
+		match := true
+		for _, k := range keywords {
+			if !strings.Contains(line, k) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			h.view = append(h.view, name)
+		}
+	}
+
+	if h.cursor >= len(h.view) {
+		h.cursor = len(h.view) - 1
+	}
+	if h.cursor < 0 {
+		h.cursor = 0
+	}
+}
+
+// toggleAll select all servers in view. If all of them are already selected, deselect them.
+func (h *hostSelector) toggleAll() {
+	all := true
+	for _, name := range h.view {
+		if !h.selected[name] {
+			all = false
+			break
+		}
+	}
+
+	for _, name := range h.view {
+		h.selected[name] = !all
+	}
+}
+
+// Run show selector until Enter (ok is true) or Esc/Ctrl-C (ok is false).
+func (h *hostSelector) Run() (selected []string, ok bool, err error) {
+	err = termbox.Init()
+	if err != nil {
+		return
+	}
+	defer termbox.Close()
+	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+
+	for {
+		h.draw()
+
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventKey:
+			_, height := termbox.Size()
+			height = height - 2
+
+			switch ev.Key {
+			case termbox.KeyEsc, termbox.KeyCtrlC:
+				return nil, false, nil
+
+			case termbox.KeyEnter:
+				for _, name := range h.names {
+					if h.selected[name] {
+						selected = append(selected, name)
+					}
+				}
+				return selected, true, nil
+
+			case termbox.KeyArrowUp:
+				if h.cursor > 0 {
+					h.cursor--
+				}
+
+			case termbox.KeyArrowDown:
+				if h.cursor < len(h.view)-1 {
+					h.cursor++
+				}
+
+			case termbox.KeyArrowRight:
+				if next := (h.cursor/height + 1) * height; next < len(h.view) {
+					h.cursor = next
+				}
+
+			case termbox.KeyArrowLeft:
+				if before := (h.cursor/height - 1) * height; before >= 0 {
+					h.cursor = before
+				}
+
+			case termbox.KeyTab:
+				if len(h.view) > 0 {
+					name := h.view[h.cursor]
+					h.selected[name] = !h.selected[name]
+				}
+				if h.cursor < len(h.view)-1 {
+					h.cursor++
+				}
+
+			case termbox.KeyCtrlA:
+				h.toggleAll()
+
+			case termbox.KeyBackspace, termbox.KeyBackspace2:
+				if r := []rune(h.keyword); len(r) > 0 {
+					h.keyword = string(r[:len(r)-1])
+					h.filter()
+				}
+
+			case termbox.KeySpace:
+				h.keyword = h.keyword + " "
+
+			default:
+				if ev.Ch != 0 {
+					h.keyword = h.keyword + string(ev.Ch)
+					h.filter()
+				}
+			}
+
+		case termbox.EventMouse:
+			if ev.Key == termbox.MouseLeft {
+				_, height := termbox.Size()
+				line := (h.cursor/(height-2))*(height-2) + ev.MouseY - 2
+				if ev.MouseY >= 2 && line < len(h.view) {
+					h.cursor = line
+				}
+			}
+		}
+	}
+}
+
+// draw draw selector. Colors are the same as lssh list.
+func (h *hostSelector) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+
+	width, height := termbox.Size()
+	height = height - 2
+	if height < 1 {
+		height = 1
+	}
+
+	// head
+	x := drawSelectorLine(0, 0, h.prompt, termbox.ColorYellow, termbox.ColorDefault)
+	drawSelectorLine(x, 0, h.keyword, termbox.ColorDefault, termbox.ColorDefault)
+	drawSelectorLine(2, 1, h.header, termbox.ColorYellow, termbox.ColorDefault)
+
+	// list (page of cursor)
+	first := (h.cursor / height) * height
+	for i := first; i < len(h.view) && i < first+height; i++ {
+		name := h.view[i]
+
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		switch {
+		case i == h.cursor:
+			fg, bg = termbox.ColorBlack, termbox.ColorGreen
+		case h.selected[name]:
+			fg, bg = termbox.ColorBlack, termbox.ColorCyan
+		}
+
+		line := h.lines[name]
+		if pad := width - 2 - runewidth.StringWidth(line); pad > 0 {
+			line = line + strings.Repeat(" ", pad)
+		}
+		drawSelectorLine(2, i-first+2, line, fg, bg)
+	}
+
+	termbox.SetCursor(x+runewidth.StringWidth(h.keyword), 0)
+	termbox.Flush()
+}
+
+// drawSelectorLine draw str at (x, y), and return x of the end.
+func drawSelectorLine(x, y int, str string, fg, bg termbox.Attribute) int {
+	for _, r := range str {
+		termbox.SetCell(x, y, r, fg, bg)
+		x += runewidth.RuneWidth(r)
+	}
+
+	return x
+}
+
+// buildin_select open host selector, and connect/disconnect hosts by the selection.
+func (s *shell) buildin_select(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	status := true
+	err := func() error {
+		if stdout != os.Stdout {
+			return fmt.Errorf("%%select: can not be used in pipeline")
+		}
+
+		// current hosts
+		var current []string
+		for _, c := range s.getConnects() {
+			current = append(current, c.Name)
+		}
+
+		selected, ok, err := newHostSelector(s.Run.Conf, current).Run()
+		switch {
+		case err != nil:
+			return fmt.Errorf("%%select: %s", err)
+		case !ok:
+			fmt.Println("select canceled.")
+			return nil
+		case len(selected) == 0:
+			return fmt.Errorf("%%select: no host selected")
+		}
+
+		// add newly selected hosts
+		var added []string
+		for _, name := range selected {
+			if indexOf(current, name) < 0 {
+				added = append(added, name)
+			}
+		}
+		if len(added) > 0 && !s.addHosts("%select", added, stdout) {
+			status = false
+		}
+
+		// remove deselected hosts
+		var removed []*sConnect
+		for _, c := range s.getConnects() {
+			if indexOf(selected, c.Name) < 0 {
+				removed = append(removed, c)
+			}
+		}
+		if len(removed) > 0 {
+			return s.removeHosts("%select", removed, stdout)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		status = false
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- status
+}
+
+// insertSelectCommand replace the input with `%select`, bind to Ctrl-O.
+func (s *shell) insertSelectCommand(buf *prompt.Buffer) {
+	d := buf.Document()
+	buf.DeleteBeforeCursor(len([]rune(d.TextBeforeCursor())))
+	buf.Delete(len([]rune(d.TextAfterCursor())))
+	buf.InsertText("%select", false, true)
+}
@@ -10,8 +10,10 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,13 +21,12 @@ import (
 	"github.com/blacknon/lssh/conf"
 	"github.com/blacknon/lssh/output"
 	sshcmd "github.com/blacknon/lssh/ssh"
+	"github.com/blacknon/lsshell/config"
 	"github.com/c-bata/go-prompt"
 )
 
 // TODO(blacknon): 接続が切れた場合の再接続処理、および再接続ができなかった場合のsliceからの削除対応の追加(v0.3.0)
 // TODO(blacknon): pShellのログ(実行コマンド及び出力結果)をログとしてファイルに記録する機能の追加(v0.3.0) => 任意のファイルを指定するように
-// TODO(blacknon): グループ化(`()`で囲んだりする)や三項演算子への対応(v0.2.0)
-// TODO(blacknon): `サーバ名:command...` で、指定したサーバでのみコマンドを実行させる機能の追加(v0.2.0)
 // TODO(blacknon): petをうまいこと利用できるような仕組みを作る(v0.3.0)
 // TODO(blacknon): parallel shellでkeybindや関数が使えるような仕組みを作る(どうやってやるかは不明だが…)(v0.3.0)
 
@@ -41,17 +42,77 @@ import (
 // shell is lsshell struct
 type shell struct {
 	Config        conf.ShellConfig
+	ExtConfig     config.Config // lsshell specific settings
 	Signal        chan os.Signal
 	Count         int
 	ServerList    []string
 	Connects      []*sConnect
+	Run           *sshcmd.Run
 	PROMPT        string
 	History       map[int]map[string]*shellHistory
 	HistoryFile   string
 	latestCommand string
-	CmdComplete   []prompt.Suggest
-	PathComplete  []prompt.Suggest
-	Options       shellOption
+
+	// command history (file history + session history)
+	commandHistory []shellHistory
+	historyMutex   *sync.Mutex
+
+	// lock of History (command results), written by background jobs
+	resultMutex *sync.Mutex
+
+	// background jobs
+	jobs     []*job
+	jobMutex *sync.Mutex
+
+	// lock of Connects (changed by `%add`, `%remove` and keepalive)
+	connectMutex *sync.Mutex
+
+	// port forwardings (`-L`, `-R`, `-D`, `-r`)
+	forwards     []*portForward
+	forwardID    int // last ID of forward (`%forward`)
+	forwardIndex int // index of host for port shift
+	forwardMutex *sync.Mutex
+
+	// remote sessions of foreground command (for interrupt and signal forwarding)
+	foreground *foregroundSessions
+
+	// reverse history search state (Ctrl-R)
+	searching      bool
+	searchFailed   bool
+	searchQuery    string
+	searchIndex    int
+	searchResult   string
+	searchOriginal string // buffer before search
+
+	// multi-line input buffer (continuation lines)
+	inputBuffer []string
+
+	// go-prompt, and the history of go-prompt (multi-line command is joined)
+	prompt        *prompt.Prompt
+	promptHistory []string
+
+	// host status (key: server name)
+	hostStatus  map[string]*hostStatus
+	statusMutex *sync.Mutex
+	statusPanel bool
+
+	// compiled histignore regex
+	histIgnore []*regexp.Regexp
+
+	// command guard (`[guard]`) and dry-run mode (`%dryrun`)
+	guard  *commandGuard
+	dryrun bool
+
+	CmdComplete  []prompt.Suggest
+	PathComplete []prompt.Suggest
+	Options      shellOption
+
+	// command complete data
+	pathCompleteKey  string
+	localCmdComplete []prompt.Suggest
+	cmdCompleteMap   map[string][]string // key: server name
+	argCompleteCache map[string]*argCompleteCache
+	completeMutex    *sync.Mutex
 }
 
 // shellOption is optitons pshell.
@@ -71,28 +132,83 @@ type shellOption struct {
 
 	// local command実行時の結果をHistoryResultに記録しない(os.Stdoutに直接出す)
 	LocalCommandNotRecordResult bool
+
+	// command complete cache directory
+	CompleteCacheDir string
+
+	// command complete cache ttl. expired cache is updated by refresher.
+	CompleteCacheTTL time.Duration
+
+	// command complete refresh interval
+	CompleteRefreshInterval time.Duration
+
+	// timeout of complete command per host
+	CompleteTimeout time.Duration
+
+	// timeout of path complete per host. slow host is excluded from the result.
+	PathCompleteTimeout time.Duration
+
+	// timeout of argument complete (systemctl, docker, etc...) per host. slow host is excluded from the result.
+	ArgCompleteTimeout time.Duration
+
+	// argument complete (systemctl, docker, etc...) cache ttl.
+	ArgCompleteCacheTTL time.Duration
+
+	// default timeout of remote command. 0 is no timeout.
+	CommandTimeout time.Duration
+
+	// detach hosts not finished in timeout to background, instead of kill.
+	CommandTimeoutDetach bool
+
+	// default pause between batches of `%rolling`. 0 is no pause.
+	RollingPause time.Duration
+
+	// default health check command between batches of `%rolling`. empty is no check.
+	RollingCheck string
 }
 
 // sConnect is shell connect struct.
 type sConnect struct {
 	Name   string
 	Output *output.Output
+
+	// remote working directory. change with `%cd`.
+	Cwd string
+
+	// Disabled is true if excluded from command by `%disable`.
+	Disabled bool
+
 	*sshlib.Connect
 }
 
+// remoteCommand return command line run on c, at remote working directory.
+func (c *sConnect) remoteCommand(command string) string {
+	if c.Cwd == "" {
+		return command
+	}
+
+	return "cd -- " + shellQuote(c.Cwd) + " || exit 1; " + command
+}
+
 // variable
 var (
 	// Default PROMPT
 	defaultPrompt = "[${COUNT}] <<< "
 
+	// Default continuation prompt (PS2)
+	defaultPS2 = "> "
+
 	// Default OPROMPT
 	defaultOPrompt = "[${SERVER}][${COUNT}] > "
 
 	// Default Parallel shell history file
 	defaultHistoryFile = "~/.lssh_history"
+
+	// Default command complete cache directory
+	defaultCompleteCacheDir = "~/.lssh_complete"
 )
 
-func Shell(r *sshcmd.Run) (err error) {
+func Shell(r *sshcmd.Run, extConfig config.Config) (err error) {
 	// print header
 	fmt.Println("Start parallel-shell...")
 	r.PrintSelectServer()
@@ -123,32 +239,11 @@ func Shell(r *sshcmd.Run) (err error) {
 	// TODO: to change parallel
 	var cons []*sConnect
 	for _, server := range r.ServerList {
-		// Create *sshlib.Connect
-		con, err := r.CreateSshConnect(server)
+		psCon, err := createConnect(r, config, server)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-
-		// TTY enable
-		con.TTY = true
-
-		// Create Output
-		o := &output.Output{
-			Templete:   config.OPrompt,
-			ServerList: r.ServerList,
-			Conf:       r.Conf.Server[server],
-			AutoColor:  true,
-		}
-
-		// Create output prompt
-		o.Create(server)
-
-		psCon := &sConnect{
-			Name:    server,
-			Output:  o,
-			Connect: con,
-		}
 		cons = append(cons, psCon)
 	}
 
@@ -160,29 +255,83 @@ func Shell(r *sshcmd.Run) (err error) {
 	// create new shell struct
 	s := &shell{
 		Config:      config,
-		Signal:      make(chan os.Signal),
+		ExtConfig:   extConfig,
+		Signal:      make(chan os.Signal, signalBufferSize),
 		ServerList:  r.ServerList,
 		Connects:    cons,
+		Run:         r,
 		PROMPT:      config.Prompt,
 		History:     map[int]map[string]*shellHistory{},
 		HistoryFile: config.HistoryFile,
 		Options: shellOption{
 			LocalCommandNotRecordResult: true, // debug
+			CompleteCacheDir:            defaultCompleteCacheDir,
+			CompleteCacheTTL:            1 * time.Hour,
+			CompleteRefreshInterval:     10 * time.Minute,
+			CompleteTimeout:             10 * time.Second,
+			PathCompleteTimeout:         1 * time.Second,
+			ArgCompleteTimeout:          2 * time.Second,
+			ArgCompleteCacheTTL:         30 * time.Second,
+			CommandTimeoutDetach:        extConfig.Shell.TimeoutDetach,
+			RollingCheck:                extConfig.Shell.RollingCheck,
 		},
+		cmdCompleteMap:   map[string][]string{},
+		argCompleteCache: map[string]*argCompleteCache{},
+		completeMutex:    new(sync.Mutex),
+		historyMutex:     new(sync.Mutex),
+		resultMutex:      new(sync.Mutex),
+		jobMutex:         new(sync.Mutex),
+		foreground:       newForegroundSessions(),
+		connectMutex:     new(sync.Mutex),
+		forwardMutex:     new(sync.Mutex),
+		hostStatus:       map[string]*hostStatus{},
+		statusMutex:      new(sync.Mutex),
+		statusPanel:      extConfig.Shell.StatusPanel,
+		histIgnore:       compileHistIgnore(extConfig.Shell.HistIgnore),
+		guard:            newCommandGuard(extConfig.Guard),
+	}
+
+	// set default command timeout
+	if extConfig.Shell.Timeout != "" {
+		timeout, err := time.ParseDuration(extConfig.Shell.Timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid timeout `%s` in config\n", extConfig.Shell.Timeout)
+		} else {
+			s.Options.CommandTimeout = timeout
+		}
+	}
+
+	// set default pause of `%rolling`
+	if extConfig.Shell.RollingPause != "" {
+		pause, err := time.ParseDuration(extConfig.Shell.RollingPause)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid rollingpause `%s` in config\n", extConfig.Shell.RollingPause)
+		} else {
+			s.Options.RollingPause = pause
+		}
+	}
+
+	// create host status
+	for _, c := range s.getConnects() {
+		s.getHostStatus(c.Name)
 	}
 
+	// start port forwarding
+	s.startPortForwards(s.getConnects())
+
 	// set signal
 	// TODO: Windows対応
 	//   - 参考: https://cad-san.hatenablog.com/entry/2017/01/09/170213
 	signal.Notify(s.Signal, syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
+	notifyForwardSignals(s.Signal)
 
 	// old history list
-	var historyCommand []string
 	oldHistory, err := s.GetHistoryFromFile()
 	if err == nil {
 		for _, h := range oldHistory {
-			historyCommand = append(historyCommand, h.Command)
+			s.promptHistory = append(s.promptHistory, h.Command)
 		}
+		s.commandHistory = oldHistory
 	}
 
 	// check keepalive
@@ -193,19 +342,25 @@ func Shell(r *sshcmd.Run) (err error) {
 		}
 	}()
 
-	// create complete data
-	// TODO(blacknon): 定期的に裏で取得するよう処理を加える(v0.6.1)
-	s.GetCommandComplete()
+	// create complete data.
+	// remote complete data is read from cache, and refreshed in background.
+	s.startCompleteRefresher()
+
+	// start go-prompt
+	s.prompt = s.newPrompt()
+	s.prompt.Run()
+
+	return
+}
 
-	// create go-prompt
-	p := prompt.New(
-		s.Executor,
-		s.Completer,
-		prompt.OptionHistory(historyCommand),
+// newPrompt create go-prompt with history of s.
+func (s *shell) newPrompt() *prompt.Prompt {
+	opts := []prompt.Option{
+		prompt.OptionHistory(append([]string{}, s.promptHistory...)),
 		prompt.OptionLivePrefix(s.CreatePrompt),
 		prompt.OptionInputTextColor(prompt.Green),
 		prompt.OptionPrefixTextColor(prompt.Blue),
-		prompt.OptionCompletionWordSeparator("/: \\"), // test
+		prompt.OptionCompletionWordSeparator(" "), // path complete replaces the whole word
 		// Keybind
 		// Alt+Backspace
 		prompt.OptionAddASCIICodeBind(prompt.ASCIICodeBind{
@@ -232,17 +387,33 @@ func Shell(r *sshcmd.Run) (err error) {
 			ASCIICode: []byte{0x1b, 0x1b, 0x5B, 0x43},
 			Fn:        prompt.GoRightWord,
 		}),
+		// Ctrl+T (toggle status panel)
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlT,
+			Fn:  s.toggleStatusPanel,
+		}),
+		// Ctrl+O (host selector)
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlO,
+			Fn:  s.insertSelectCommand,
+		}),
+		// Ctrl+C (cancel multi-line input)
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlC,
+			Fn:  s.cancelContinuation,
+		}),
 		prompt.OptionSetExitCheckerOnInput(s.exitChecker),
-	)
+	}
 
-	// start go-prompt
-	p.Run()
+	// Ctrl+R (reverse history search)
+	opts = append(opts, s.historySearchKeyBinds()...)
 
-	return
+	return prompt.New(s.Executor, s.Completer, opts...)
 }
 
 // CreatePrompt is create shell prompt.
-// default value is `[${COUNT}] <<< `
+// default value is `[${COUNT}] <<< `.
+// While multi-line input, continuation prompt (PS2, default `> `) is used.
 func (s *shell) CreatePrompt() (p string, result bool) {
 	// set prompt templete (from conf)
 	p = s.PROMPT
@@ -250,6 +421,19 @@ func (s *shell) CreatePrompt() (p string, result bool) {
 		p = defaultPrompt
 	}
 
+	// reverse history search
+	if s.searching {
+		return s.historySearchPrompt(), true
+	}
+
+	// continuation prompt
+	if len(s.inputBuffer) > 0 {
+		p = s.ExtConfig.Shell.PS2
+		if p == "" {
+			p = defaultPS2
+		}
+	}
+
 	// Get env
 	hostname, _ := os.Hostname()
 	username := os.Getenv("USER")
@@ -261,6 +445,11 @@ func (s *shell) CreatePrompt() (p string, result bool) {
 	p = strings.Replace(p, "${USER}", username, -1)
 	p = strings.Replace(p, "${PWD}", pwd, -1)
 
+	// dry-run mode
+	if s.dryrun && len(s.inputBuffer) == 0 {
+		p = "(dryrun) " + p
+	}
+
 	return p, true
 }
 
@@ -269,7 +458,7 @@ func (s *shell) exitChecker(in string, breakline bool) bool {
 		s.checkKeepalive()
 	}
 
-	if len(s.Connects) == 0 {
+	if len(s.getConnects()) == 0 {
 		s.exit(1, "Error: No valid connections\n")
 
 		return true
@@ -293,3 +482,34 @@ func execLocalCommand(cmd string) {
 	out, _ := exec.Command("sh", "-c", cmd).CombinedOutput()
 	fmt.Printf(string(out))
 }
+
+// createConnect connect to server, and return sConnect with output prompt.
+func createConnect(r *sshcmd.Run, config conf.ShellConfig, server string) (psCon *sConnect, err error) {
+	// Create *sshlib.Connect
+	con, err := r.CreateSshConnect(server)
+	if err != nil {
+		return
+	}
+
+	// TTY enable
+	con.TTY = true
+
+	// Create Output
+	o := &output.Output{
+		Templete:   config.OPrompt,
+		ServerList: r.ServerList,
+		Conf:       r.Conf.Server[server],
+		AutoColor:  true,
+	}
+
+	// Create output prompt
+	o.Create(server)
+
+	psCon = &sConnect{
+		Name:    server,
+		Output:  o,
+		Connect: con,
+	}
+
+	return
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build !windows
+
+package shell
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// notifyForwardSignals relay signals forwarded to remote sessions to ch.
+// SIGTSTP and SIGQUIT do not stop lsshell, and are sent to remote while command is running.
+func notifyForwardSignals(ch chan os.Signal) {
+	signal.Notify(ch, syscall.SIGTSTP, syscall.SIGQUIT, syscall.SIGWINCH)
+}
+
+// getForwardSignal return remoteSignal of sig. ok is false if sig is not forwarded.
+func getForwardSignal(sig os.Signal) (rs remoteSignal, ok bool) {
+	switch sig {
+	case syscall.SIGTSTP:
+		return remoteSignal{name: ssh.Signal("TSTP"), ctrl: 0x1a}, true
+	case syscall.SIGQUIT:
+		return remoteSignal{name: ssh.SIGQUIT, ctrl: 0x1c}, true
+	case syscall.SIGWINCH:
+		return remoteSignal{resize: true}, true
+	}
+
+	return
+}
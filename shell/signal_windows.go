@@ -0,0 +1,19 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build windows
+
+package shell
+
+import (
+	"os"
+)
+
+// notifyForwardSignals do nothing on Windows.
+func notifyForwardSignals(ch chan os.Signal) {}
+
+// getForwardSignal return false on Windows (no signal is forwarded).
+func getForwardSignal(sig os.Signal) (rs remoteSignal, ok bool) {
+	return
+}
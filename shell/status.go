@@ -0,0 +1,338 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// hostStatus is status of host, shown by `%status` and status panel.
+type hostStatus struct {
+	Name      string
+	Connected bool
+	Disabled  bool
+	Running   bool
+	StartTime time.Time
+	EndTime   time.Time
+	Bytes     int64
+	ExitCode  int // -1 is unknown (not run, or killed)
+
+	// timeout of command. Detached is true if the command is detached to background.
+	TimedOut bool
+	Detached bool
+
+	// run is id of the last command started on host. Result of older command (detached by timeout) is ignored.
+	run int
+
+	mutex *sync.Mutex
+}
+
+// getHostStatus return status of host. If not exist, create it.
+func (s *shell) getHostStatus(name string) *hostStatus {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	st, ok := s.hostStatus[name]
+	if !ok {
+		st = newHostStatus(name)
+		s.hostStatus[name] = st
+	}
+
+	return st
+}
+
+// deleteHostStatus delete status of host removed by `%remove`.
+func (s *shell) deleteHostStatus(name string) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	delete(s.hostStatus, name)
+}
+
+// newHostStatus return new hostStatus of name.
+func newHostStatus(name string) *hostStatus {
+	return &hostStatus{
+		Name:      name,
+		Connected: true,
+		ExitCode:  -1,
+		mutex:     new(sync.Mutex),
+	}
+}
+
+// getHostStatusList return copy of all host status, sorted by running and name.
+func (s *shell) getHostStatusList() (list []hostStatus) {
+	s.statusMutex.Lock()
+	for _, st := range s.hostStatus {
+		st.mutex.Lock()
+		list = append(list, *st)
+		st.mutex.Unlock()
+	}
+	s.statusMutex.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Running != list[j].Running {
+			return list[i].Running
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return
+}
+
+// setConnected set connection state of host.
+func (st *hostStatus) setConnected(connected bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.Connected = connected
+}
+
+// setDisabled set `%disable` state of host.
+func (st *hostStatus) setDisabled(disabled bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.Disabled = disabled
+}
+
+// start set status of command start, and return run id of the command.
+func (st *hostStatus) start() (run int) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.run++
+	st.Running = true
+	st.StartTime = time.Now()
+	st.Bytes = 0
+	st.ExitCode = -1
+	st.TimedOut = false
+	st.Detached = false
+
+	return st.run
+}
+
+// setTimeout set status of command timeout.
+func (st *hostStatus) setTimeout(detach bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.TimedOut = true
+	st.Detached = detach
+}
+
+// finish set status of command finish. err is the result of session.Run.
+// If another command is started on host after run, it is ignored.
+func (st *hostStatus) finish(run int, err error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if run != st.run {
+		return
+	}
+
+	st.Running = false
+	st.EndTime = time.Now()
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		st.ExitCode = 0
+	case errors.As(err, &exitErr):
+		st.ExitCode = exitErr.ExitStatus()
+	default:
+		st.ExitCode = -1
+	}
+}
+
+// hostStatusWriter count output bytes of run to hostStatus.
+type hostStatusWriter struct {
+	status *hostStatus
+	run    int
+}
+
+// newWriter return writer counting output bytes of run. Output of older command is not counted.
+func (st *hostStatus) newWriter(run int) io.Writer {
+	return &hostStatusWriter{status: st, run: run}
+}
+
+// Write count output bytes, if run is the last command of host.
+func (w *hostStatusWriter) Write(p []byte) (n int, err error) {
+	w.status.mutex.Lock()
+	defer w.status.mutex.Unlock()
+
+	if w.run == w.status.run {
+		w.status.Bytes += int64(len(p))
+	}
+
+	return len(p), nil
+}
+
+// String return a row of status table.
+func (st hostStatus) String(nameWidth int) string {
+	state := "connected"
+	switch {
+	case !st.Connected:
+		state = "disconnected"
+	case st.Disabled:
+		state = "disabled"
+	}
+
+	running := "-"
+	var elapsed time.Duration
+	switch {
+	case st.Running && st.Detached:
+		running = "detached"
+		elapsed = time.Since(st.StartTime)
+	case st.Running:
+		running = "running"
+		elapsed = time.Since(st.StartTime)
+	case st.TimedOut && !st.Detached:
+		running = "timeout"
+		elapsed = st.EndTime.Sub(st.StartTime)
+	case !st.StartTime.IsZero():
+		running = "done"
+		elapsed = st.EndTime.Sub(st.StartTime)
+	}
+
+	exit := "-"
+	if !st.Running && st.ExitCode >= 0 {
+		exit = fmt.Sprintf("%d", st.ExitCode)
+	}
+
+	return fmt.Sprintf("%-*s  %-12s  %-8s  %8s  %10s  %4s",
+		nameWidth, st.Name, state, running, elapsed.Truncate(100*time.Millisecond), formatBytes(float64(st.Bytes)), exit)
+}
+
+// statusTable return status table lines. If max > 0, the rows are limited to max lines (include header).
+func (s *shell) statusTable(max int) (lines []string) {
+	list := s.getHostStatusList()
+
+	nameWidth := 4
+	for _, st := range list {
+		if len(st.Name) > nameWidth {
+			nameWidth = len(st.Name)
+		}
+	}
+
+	lines = append(lines, fmt.Sprintf("%-*s  %-12s  %-8s  %8s  %10s  %4s", nameWidth, "HOST", "STATE", "COMMAND", "ELAPSED", "OUTPUT", "EXIT"))
+	for i, st := range list {
+		if max > 0 && len(lines) == max-1 && i < len(list)-1 {
+			lines = append(lines, fmt.Sprintf("... and %d more hosts", len(list)-i))
+			break
+		}
+
+		lines = append(lines, st.String(nameWidth))
+	}
+
+	return
+}
+
+// buildin_status print status of all hosts.
+func (s *shell) buildin_status(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	for _, line := range s.statusTable(0) {
+		fmt.Fprintln(stdout, line)
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- true
+}
+
+// toggleStatusPanel switch status panel on/off, bind to Ctrl-T.
+func (s *shell) toggleStatusPanel(buf *prompt.Buffer) {
+	s.statusPanel = !s.statusPanel
+}
+
+// startStatusPanel draw status panel at the bottom of terminal, until returned stop function is called.
+// The output of commands scrolls in the region above the panel.
+func (s *shell) startStatusPanel() (stop func()) {
+	fd := int(os.Stdout.Fd())
+	width, height, err := term.GetSize(fd)
+	if err != nil || !term.IsTerminal(fd) {
+		return func() {}
+	}
+
+	// panel height (header + hosts), up to half of terminal
+	size := len(s.getHostStatusList()) + 1
+	if size > height/2 {
+		size = height / 2
+	}
+	if size < 2 {
+		return func() {}
+	}
+
+	// make space for panel, and set scroll region
+	fmt.Printf("%s\x1b[%dA\x1b7\x1b[1;%dr\x1b8", strings.Repeat("\n", size), size, height-size)
+
+	draw := func() {
+		buf := new(strings.Builder)
+		buf.WriteString("\x1b7")
+		for i, line := range s.statusTable(size) {
+			if len(line) > width {
+				line = line[:width]
+			}
+
+			// header is reversed
+			if i == 0 {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+			fmt.Fprintf(buf, "\x1b[%d;1H\x1b[2K%s", height-size+1+i, line)
+		}
+		buf.WriteString("\x1b8")
+
+		os.Stdout.WriteString(buf.String())
+	}
+
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		draw()
+		for {
+			select {
+			case <-ticker.C:
+				draw()
+			case <-done:
+				draw()
+				close(finished)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+
+		// reset scroll region, and clear panel
+		clear := new(strings.Builder)
+		clear.WriteString("\x1b7\x1b[r")
+		for i := 0; i < size; i++ {
+			fmt.Fprintf(clear, "\x1b[%d;1H\x1b[2K", height-size+1+i)
+		}
+		clear.WriteString("\x1b8")
+		os.Stdout.WriteString(clear.String())
+	}
+}
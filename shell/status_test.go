@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestHostStatusRun check that the result of older command (detached by timeout) does not overwrite the status of newer command.
+func TestHostStatusRun(t *testing.T) {
+	st := newHostStatus("web01")
+
+	// first command is detached, and second command is started
+	first := st.start()
+	w1 := st.newWriter(first)
+	w1.Write([]byte("abc"))
+
+	second := st.start()
+	w2 := st.newWriter(second)
+	w2.Write([]byte("12345"))
+
+	// output and finish of first command are ignored
+	w1.Write([]byte("ignored"))
+	st.finish(first, errors.New("killed"))
+
+	if !st.Running || st.Bytes != 5 || st.ExitCode != -1 {
+		t.Fatalf("after first finish: Running = %v, Bytes = %d, ExitCode = %d, want true, 5, -1", st.Running, st.Bytes, st.ExitCode)
+	}
+
+	st.finish(second, nil)
+	if st.Running || st.Bytes != 5 || st.ExitCode != 0 {
+		t.Fatalf("after second finish: Running = %v, Bytes = %d, ExitCode = %d, want false, 5, 0", st.Running, st.Bytes, st.ExitCode)
+	}
+}
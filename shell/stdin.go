@@ -0,0 +1,269 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Stdin fan-out mode. set per command with `%stdin <mode> command...`.
+//   - broadcast ... send all input to all hosts (default)
+//   - rr        ... send each line to hosts in round-robin
+//   - chunk     ... split input lines into contiguous chunks per host
+//   - key[:N]   ... send line to the host selected by hash of column N (whole line if N is omitted)
+const (
+	stdinModeBroadcast  = "broadcast"
+	stdinModeRoundRobin = "rr"
+	stdinModeChunk      = "chunk"
+	stdinModeKey        = "key"
+)
+
+// checkStdinMode return error if mode is not valid stdin mode.
+func checkStdinMode(mode string) error {
+	switch mode {
+	case stdinModeBroadcast, stdinModeRoundRobin, stdinModeChunk, stdinModeKey:
+		return nil
+	}
+
+	if strings.HasPrefix(mode, stdinModeKey+":") {
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, stdinModeKey+":"))
+		if err == nil && n > 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("lsshell: %%stdin: invalid mode `%s` (broadcast, rr, chunk, key[:N])", mode)
+}
+
+// stdinLineBuffer is the number of lines buffered per host in split mode.
+// A stalled host does not block the others, until its buffer is full.
+const stdinLineBuffer = 1024
+
+// pushInputSplit distribute lines of input to writers by mode, and close writers at the end of input.
+// Each writer is written in own goroutine (stdinLineWriter), so a slow host does not block the others.
+func pushInputSplit(mode string, writers []io.WriteCloser, input io.Reader) {
+	if len(writers) == 0 {
+		io.Copy(io.Discard, input)
+		return
+	}
+
+	var lws []*stdinLineWriter
+	for _, w := range writers {
+		lws = append(lws, newStdinLineWriter(w))
+	}
+	defer func() {
+		for _, lw := range lws {
+			lw.Close()
+		}
+		for _, lw := range lws {
+			lw.Wait()
+		}
+	}()
+
+	rd := bufio.NewReader(input)
+
+	// chunk need the number of lines before sending.
+	// input is spooled to temporary file (not memory), and read again.
+	if mode == stdinModeChunk {
+		spool, count, err := spoolInput(rd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		rd = bufio.NewReader(spool)
+		for i := 0; ; i++ {
+			line, err := rd.ReadString('\n')
+			if line != "" {
+				lws[stdinChunkIndex(i, count, len(lws))].WriteLine(line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	for i := 0; ; i++ {
+		line, err := rd.ReadString('\n')
+		if line != "" {
+			lws[stdinLineIndex(mode, i, line, len(lws))].WriteLine(line)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// stdinLineIndex return index of writer to send the i-th line, in rr or key mode.
+func stdinLineIndex(mode string, i int, line string, n int) int {
+	if strings.HasPrefix(mode, stdinModeKey) {
+		return int(hashStdinKey(mode, line) % uint32(n))
+	}
+
+	return i % n
+}
+
+// stdinChunkIndex return index of writer to send the i-th line of count lines, in chunk mode.
+func stdinChunkIndex(i, count, n int) int {
+	size := (count + n - 1) / n
+	if size == 0 {
+		return 0
+	}
+
+	return i / size
+}
+
+// spoolInput copy input to temporary file, and return the file (seeked to the start) and the number of lines.
+func spoolInput(input io.Reader) (file *os.File, count int, err error) {
+	file, err = os.CreateTemp("", "lsshell-stdin-")
+	if err != nil {
+		return
+	}
+
+	rd := bufio.NewReader(input)
+	wr := bufio.NewWriter(file)
+	for {
+		line, rerr := rd.ReadString('\n')
+		if line != "" {
+			count++
+			wr.WriteString(line)
+		}
+		if rerr != nil {
+			break
+		}
+	}
+
+	if err = wr.Flush(); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	return
+}
+
+// stdinLineWriter write lines to w in own goroutine, with buffer of stdinLineBuffer lines.
+// After write error (finished session), lines are discarded.
+type stdinLineWriter struct {
+	w     io.WriteCloser
+	lines chan string
+	done  chan bool
+}
+
+// newStdinLineWriter return stdinLineWriter of w, and start writing.
+func newStdinLineWriter(w io.WriteCloser) *stdinLineWriter {
+	lw := &stdinLineWriter{
+		w:     w,
+		lines: make(chan string, stdinLineBuffer),
+		done:  make(chan bool),
+	}
+
+	go func() {
+		defer close(lw.done)
+		defer lw.w.Close()
+
+		failed := false
+		for line := range lw.lines {
+			if failed {
+				continue
+			}
+
+			if _, err := io.WriteString(lw.w, line); err != nil {
+				failed = true
+			}
+		}
+	}()
+
+	return lw
+}
+
+// WriteLine add line to buffer. It blocks only when the buffer is full.
+func (lw *stdinLineWriter) WriteLine(line string) {
+	lw.lines <- line
+}
+
+// Close finish input. w is closed after the buffered lines are written.
+func (lw *stdinLineWriter) Close() {
+	close(lw.lines)
+}
+
+// Wait wait for the buffered lines are written, and w is closed.
+func (lw *stdinLineWriter) Wait() {
+	<-lw.done
+}
+
+// pushInputBroadcast copy input (pipe from the previous stage) to all writers, and close writers at the end of input.
+// Write error of a writer (finished session) does not stop the others.
+func pushInputBroadcast(writers []io.WriteCloser, input io.Reader) {
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
+
+	var ws []io.Writer
+	for _, w := range writers {
+		ws = append(ws, ignoreErrorWriter{w})
+	}
+
+	io.Copy(io.MultiWriter(ws...), input)
+}
+
+// ignoreErrorWriter is writer ignoring write error of w.
+type ignoreErrorWriter struct {
+	w io.Writer
+}
+
+// Write write p to w, and always return len(p) without error.
+func (w ignoreErrorWriter) Write(p []byte) (int, error) {
+	w.w.Write(p)
+	return len(p), nil
+}
+
+// hashStdinKey return hash of the key column of line.
+func hashStdinKey(mode, line string) uint32 {
+	key := strings.TrimRight(line, "\r\n")
+
+	if c := strings.TrimPrefix(mode, stdinModeKey+":"); c != mode {
+		col, _ := strconv.Atoi(c)
+
+		fields := strings.Fields(key)
+		key = ""
+		if col <= len(fields) {
+			key = fields[col-1]
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// buildin_stdin print usage of `%stdin`. It is called only when command is not specified.
+func (s *shell) buildin_stdin(out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	fmt.Fprintf(stdout, "usage: %%stdin <broadcast|rr|chunk|key[:N]> command...\n")
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- false
+}
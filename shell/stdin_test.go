@@ -0,0 +1,259 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bufferWriteCloser is io.WriteCloser to bytes.Buffer, for test.
+type bufferWriteCloser struct {
+	buf    bytes.Buffer
+	closed bool
+	mutex  sync.Mutex
+}
+
+func (b *bufferWriteCloser) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *bufferWriteCloser) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+func (b *bufferWriteCloser) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.String()
+}
+
+// TestHashStdinKey check that the same key column is hashed to the same value.
+func TestHashStdinKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{name: "whole line", mode: "key", a: "web01 a\n", b: "web01 a", equal: true},
+		{name: "whole line different", mode: "key", a: "web01 a\n", b: "web01 b\n", equal: false},
+		{name: "crlf", mode: "key", a: "web01\r\n", b: "web01", equal: true},
+		{name: "column 1", mode: "key:1", a: "web01 a\n", b: "web01 b\n", equal: true},
+		{name: "column 1 different", mode: "key:1", a: "web01 a\n", b: "web02 a\n", equal: false},
+		{name: "column 2", mode: "key:2", a: "x  user1  1\n", b: "y\tuser1\t2\n", equal: true},
+		{name: "missing column is empty key", mode: "key:3", a: "a b\n", b: "\n", equal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := hashStdinKey(tt.mode, tt.a), hashStdinKey(tt.mode, tt.b)
+			if (a == b) != tt.equal {
+				t.Errorf("hashStdinKey(%q, %q) = %d, hashStdinKey(%q, %q) = %d, want equal %v", tt.mode, tt.a, a, tt.mode, tt.b, b, tt.equal)
+			}
+		})
+	}
+}
+
+// TestCheckStdinMode check valid and invalid stdin modes.
+func TestCheckStdinMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{mode: "broadcast"},
+		{mode: "rr"},
+		{mode: "chunk"},
+		{mode: "key"},
+		{mode: "key:2"},
+		{mode: "key:0", wantErr: true},
+		{mode: "key:-1", wantErr: true},
+		{mode: "key:x", wantErr: true},
+		{mode: "key:", wantErr: true},
+		{mode: "roundrobin", wantErr: true},
+		{mode: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			if err := checkStdinMode(tt.mode); (err != nil) != tt.wantErr {
+				t.Errorf("checkStdinMode(%q) err = %v, wantErr %v", tt.mode, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestStdinChunkIndex check that lines are split into contiguous chunks.
+func TestStdinChunkIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		n     int
+		want  []int
+	}{
+		{name: "even", count: 4, n: 2, want: []int{0, 0, 1, 1}},
+		{name: "odd", count: 5, n: 2, want: []int{0, 0, 0, 1, 1}},
+		{name: "less lines than hosts", count: 2, n: 3, want: []int{0, 1}},
+		{name: "one host", count: 3, n: 1, want: []int{0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for i := 0; i < tt.count; i++ {
+				got = append(got, stdinChunkIndex(i, tt.count, tt.n))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stdinChunkIndex(*, %d, %d) = %v, want %v", tt.count, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPushInputSplit check distribution of lines to writers in each mode.
+func TestPushInputSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		input string
+		n     int
+		want  []string
+	}{
+		{name: "rr", mode: "rr", input: "1\n2\n3\n4\n5\n", n: 2, want: []string{"1\n3\n5\n", "2\n4\n"}},
+		{name: "rr without last newline", mode: "rr", input: "1\n2\n3", n: 2, want: []string{"1\n3", "2\n"}},
+		{name: "chunk", mode: "chunk", input: "1\n2\n3\n4\n5\n", n: 2, want: []string{"1\n2\n3\n", "4\n5\n"}},
+		{name: "chunk empty", mode: "chunk", input: "", n: 2, want: []string{"", ""}},
+		{name: "key same key", mode: "key:1", input: "a 1\nb 2\na 3\n", n: 1, want: []string{"a 1\nb 2\na 3\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bufs []*bufferWriteCloser
+			var writers []io.WriteCloser
+			for i := 0; i < tt.n; i++ {
+				b := new(bufferWriteCloser)
+				bufs = append(bufs, b)
+				writers = append(writers, b)
+			}
+
+			pushInputSplit(tt.mode, writers, strings.NewReader(tt.input))
+
+			for i, b := range bufs {
+				if !b.closed {
+					t.Errorf("writer %d is not closed", i)
+				}
+				if got := b.String(); got != tt.want[i] {
+					t.Errorf("writer %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestPushInputSplitKey check that lines with the same key are sent to the same writer.
+func TestPushInputSplitKey(t *testing.T) {
+	var bufs []*bufferWriteCloser
+	var writers []io.WriteCloser
+	for i := 0; i < 3; i++ {
+		b := new(bufferWriteCloser)
+		bufs = append(bufs, b)
+		writers = append(writers, b)
+	}
+
+	input := "a 1\nb 2\nc 3\na 4\nb 5\nc 6\n"
+	pushInputSplit("key:1", writers, strings.NewReader(input))
+
+	total := 0
+	for i, b := range bufs {
+		keys := map[string]bool{}
+		for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+			if line != "" {
+				keys[strings.Fields(line)[0]] = true
+				total++
+			}
+		}
+
+		for key := range keys {
+			want := int(hashStdinKey("key:1", key) % 3)
+			if want != i {
+				t.Errorf("key %q is sent to writer %d, want %d", key, i, want)
+			}
+		}
+	}
+
+	if total != 6 {
+		t.Errorf("total lines = %d, want 6", total)
+	}
+}
+
+// stallWriteCloser is io.WriteCloser blocking Write until release is closed.
+type stallWriteCloser struct {
+	release chan bool
+}
+
+func (w *stallWriteCloser) Write(p []byte) (int, error) {
+	<-w.release
+	return 0, errors.New("closed")
+}
+
+func (w *stallWriteCloser) Close() error {
+	return nil
+}
+
+// TestPushInputSplitStall check that a stalled writer does not block the others.
+func TestPushInputSplitStall(t *testing.T) {
+	stall := &stallWriteCloser{release: make(chan bool)}
+	other := new(bufferWriteCloser)
+
+	input := strings.Repeat("line\n", 20)
+	done := make(chan bool)
+	go func() {
+		pushInputSplit("rr", []io.WriteCloser{stall, other}, strings.NewReader(input))
+		close(done)
+	}()
+
+	// other writer get all lines and is closed, while stalled writer is blocked
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-deadline:
+			close(stall.release)
+			t.Fatalf("other writer is blocked by stalled writer")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		select {
+		case <-done:
+			t.Fatalf("pushInputSplit finished before stalled writer is released")
+		default:
+		}
+
+		other.mutex.Lock()
+		closed := other.closed
+		other.mutex.Unlock()
+		if closed && strings.Count(other.String(), "\n") == 10 {
+			break
+		}
+	}
+
+	close(stall.release)
+	<-done
+}
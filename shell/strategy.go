@@ -0,0 +1,403 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/syntax"
+)
+
+// Execution strategy.
+// Remote command is run on all hosts at once by default. With strategy prefix, it is run in batches.
+//   - %canary N command...                           ... run on N hosts first, and ask before continuing on the rest
+//   - %rolling [-p pause] [-c check] N command...    ... run in batches of N hosts. Between batches, check command
+//                                                       is run on hosts of the batch, and wait for pause
+//   - %serial command...                             ... run on one host at a time
+// The following batches are not run if command fails on any host (or check fails, or canary is not continued).
+// Output of all batches are recorded in the same history, with batch number.
+
+const (
+	strategyCanary  = "canary"
+	strategyRolling = "rolling"
+	strategySerial  = "serial"
+
+	// strategyCheckTimeout is timeout of health check command, if default command timeout is not set.
+	strategyCheckTimeout = 1 * time.Minute
+)
+
+// batchInfo is batch of execution strategy.
+type batchInfo struct {
+	Strategy string
+	No       int // 1 origin
+	Total    int
+}
+
+// String return batch as `<strategy> <no>/<total>`.
+func (b *batchInfo) String() string {
+	return fmt.Sprintf("%s %d/%d", b.Strategy, b.No, b.Total)
+}
+
+// getBatchNo return batch number of history h. 0 is not batch.
+func getBatchNo(h *shellHistory) int {
+	if h.Batch == nil {
+		return 0
+	}
+
+	return h.Batch.No
+}
+
+// isStrategyPrefix return true if word is execution strategy prefix.
+func isStrategyPrefix(word string) bool {
+	switch word {
+	case "%canary", "%rolling", "%serial":
+		return true
+	}
+
+	return false
+}
+
+// parseStrategyPrefix parse execution strategy prefix at the beginning of args, and set it to pLine.
+// It returns number of words of the prefix. 0 is without command (left as build-in command).
+func parseStrategyPrefix(pLine *pipeLine, args []*syntax.Word) (n int, err error) {
+	name := args[0].Lit()
+	strategy := strings.TrimPrefix(name, "%")
+
+	// %serial command...
+	if strategy == strategySerial {
+		if len(args) < 2 {
+			return 0, nil
+		}
+
+		pLine.Strategy = strategySerial
+		pLine.BatchSize = 1
+		return 1, nil
+	}
+
+	// %rolling options
+	var pause time.Duration
+	var check string
+	n = 1
+	for strategy == strategyRolling && n < len(args) && (args[n].Lit() == "-p" || args[n].Lit() == "-c") {
+		if n+1 >= len(args) {
+			return 0, nil
+		}
+
+		var value string
+		value, err = getWordValue(args[n+1])
+		if err != nil {
+			return 0, err
+		}
+
+		switch args[n].Lit() {
+		case "-p":
+			pause, err = time.ParseDuration(value)
+			if err != nil {
+				return 0, fmt.Errorf("lsshell: %s: invalid pause `%s`", name, value)
+			}
+
+			// `-p 0` is no pause
+			if pause <= 0 {
+				pause = -1
+			}
+
+		case "-c":
+			check = value
+		}
+
+		n += 2
+	}
+
+	// N and command
+	if n+1 >= len(args) {
+		return 0, nil
+	}
+
+	size, err := strconv.Atoi(args[n].Lit())
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("lsshell: %s: invalid number of hosts `%s`", name, args[n].Lit())
+	}
+
+	pLine.Strategy = strategy
+	pLine.BatchSize = size
+	pLine.BatchPause = pause
+	pLine.BatchCheck = check
+
+	return n + 1, nil
+}
+
+// strategyIndex return index of pipeLine with execution strategy in pline. -1 is not found.
+func strategyIndex(pline []pipeLine) int {
+	for i, p := range pline {
+		if p.Strategy != "" {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitBatches split connects to batches by execution strategy of p.
+func splitBatches(connects []*sConnect, p pipeLine) (batches [][]*sConnect) {
+	size := p.BatchSize
+	if size < 1 {
+		size = 1
+	}
+
+	// canary, and the rest at once
+	if p.Strategy == strategyCanary {
+		if size >= len(connects) {
+			return [][]*sConnect{connects}
+		}
+
+		return [][]*sConnect{connects[:size], connects[size:]}
+	}
+
+	for i := 0; i < len(connects); i += size {
+		end := i + size
+		if end > len(connects) {
+			end = len(connects)
+		}
+		batches = append(batches, connects[i:end])
+	}
+
+	return
+}
+
+// getConnectNames return names of connects.
+func getConnectNames(connects []*sConnect) (names []string) {
+	for _, c := range connects {
+		names = append(names, c.Name)
+	}
+
+	return
+}
+
+// runStrategy run pline in batches, by execution strategy of pline[index].
+// It returns true if command is successful on all hosts.
+func (s *shell) runStrategy(pline []pipeLine, index int) bool {
+	p := pline[index]
+	name := "%" + p.Strategy
+
+	connects, err := s.getTargetConnects(p.Targets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return false
+	}
+
+	// pause and check between batches of `%rolling`
+	pause, check := s.getBatchInterval(p)
+
+	// printout run command
+	fmt.Printf("[Command:%s ]\n", joinPipeLineSlice(pline))
+
+	batches := splitBatches(connects, p)
+	for i, batch := range batches {
+		info := &batchInfo{Strategy: p.Strategy, No: i + 1, Total: len(batches)}
+		names := getConnectNames(batch)
+
+		fmt.Printf("[Batch:%s %s ]\n", info, strings.Join(names, ","))
+
+		// run pline only on hosts of batch
+		bl := append([]pipeLine{}, pline...)
+		bl[index].Targets = names
+		bl[index].Batch = info
+
+		status := s.runForeground(bl)
+
+		// last batch
+		if i == len(batches)-1 {
+			return status
+		}
+
+		var rest []*sConnect
+		for _, b := range batches[i+1:] {
+			rest = append(rest, b...)
+		}
+		restNames := strings.Join(getConnectNames(rest), ",")
+
+		if !status {
+			fmt.Fprintf(os.Stderr, "Error: %s: failed in batch %s. not run on %d hosts: %s\n", name, info, len(rest), restNames)
+			return false
+		}
+
+		switch p.Strategy {
+		case strategyCanary:
+			fmt.Fprintf(os.Stderr, "Canary finished on %s. Continue on %d hosts (%s)? [y/N]: ", strings.Join(names, ","), len(rest), restNames)
+			line, ok := readTerminalLine()
+			if !ok || (line != "y" && line != "yes") {
+				fmt.Fprintf(os.Stderr, "Canceled. not run on %d hosts: %s\n", len(rest), restNames)
+				return false
+			}
+
+		case strategyRolling:
+			if check != "" && !s.runBatchCheck(batch, check) {
+				fmt.Fprintf(os.Stderr, "Error: %s: check failed in batch %s. not run on %d hosts: %s\n", name, info, len(rest), restNames)
+				return false
+			}
+
+			if pause > 0 && !s.pauseBatch(pause) {
+				fmt.Fprintf(os.Stderr, "Canceled. not run on %d hosts: %s\n", len(rest), restNames)
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// runBatchCheck run health check command on connects, and print the output of failed hosts.
+// It returns true if check is successful on all hosts.
+func (s *shell) runBatchCheck(connects []*sConnect, command string) bool {
+	fmt.Printf("[Check:%s ]\n", command)
+
+	timeout := s.Options.CommandTimeout
+	if timeout <= 0 {
+		timeout = strategyCheckTimeout
+	}
+
+	ok := true
+	m := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	for _, c := range connects {
+		wg.Add(1)
+		go func(c *sConnect) {
+			defer wg.Done()
+
+			data, err := runCheckCommand(c, c.remoteCommand(command), timeout)
+
+			m.Lock()
+			defer m.Unlock()
+
+			if err != nil {
+				ok = false
+				fmt.Fprintf(os.Stderr, "%s: check failed: %s\n", c.Name, err)
+				for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+					if line != "" {
+						fmt.Fprintf(os.Stderr, "%s: %s\n", c.Name, line)
+					}
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return ok
+}
+
+// runCheckCommand run command on c, and return stdout and stderr. err is not nil if command fails or timeout.
+func runCheckCommand(c *sConnect, command string, timeout time.Duration) (data []byte, err error) {
+	session, err := c.CreateSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	buf := new(bytes.Buffer)
+	session.Stdout = buf
+	session.Stderr = buf
+
+	exit := make(chan error, 1)
+	go func() {
+		exit <- session.Run(command)
+	}()
+
+	select {
+	case err = <-exit:
+		data = buf.Bytes()
+	case <-time.After(timeout):
+		err = fmt.Errorf("timeout (%s)", timeout)
+	}
+
+	return
+}
+
+// pauseBatch wait for d between batches. It returns false if interrupted (Ctrl-C or SIGTERM).
+// Forwarded signals (SIGWINCH, SIGTSTP, SIGQUIT) are ignored, because no command is running.
+func (s *shell) pauseBatch(d time.Duration) bool {
+	fmt.Printf("[Pause:%s ]\n", d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case sig := <-s.Signal:
+			if _, ok := getForwardSignal(sig); ok {
+				continue
+			}
+			return false
+		}
+	}
+}
+
+// getBatchInterval return pause and health check command between batches of p.
+// They are set only to `%rolling`, and the default is config.
+func (s *shell) getBatchInterval(p pipeLine) (pause time.Duration, check string) {
+	if p.Strategy != strategyRolling {
+		return
+	}
+
+	pause, check = p.BatchPause, p.BatchCheck
+	if pause == 0 {
+		pause = s.Options.RollingPause
+	}
+	if check == "" {
+		check = s.Options.RollingCheck
+	}
+
+	return
+}
+
+// strategyDescription return execution strategy of p, for dry-run.
+func (s *shell) strategyDescription(p pipeLine) string {
+	if p.Strategy == strategySerial {
+		return p.Strategy
+	}
+
+	result := fmt.Sprintf("%s %d", p.Strategy, p.BatchSize)
+
+	pause, check := s.getBatchInterval(p)
+	if pause > 0 {
+		result = result + " pause " + pause.String()
+	}
+	if check != "" {
+		result = result + " check `" + check + "`"
+	}
+
+	return result
+}
+
+// buildin_strategy print usage of `%canary`, `%rolling` and `%serial`. It is called only when command is not specified.
+func (s *shell) buildin_strategy(name string, out *io.PipeWriter, ch chan<- bool) {
+	stdout := setOutput(out)
+
+	switch name {
+	case "%canary":
+		fmt.Fprintf(stdout, "usage: %%canary N command...\n")
+	case "%rolling":
+		fmt.Fprintf(stdout, "usage: %%rolling [-p pause] [-c check] N command...\n")
+	case "%serial":
+		fmt.Fprintf(stdout, "usage: %%serial command...\n")
+	}
+
+	// close out
+	switch stdout.(type) {
+	case *io.PipeWriter:
+		out.CloseWithError(io.ErrClosedPipe)
+	}
+
+	// send exit
+	ch <- false
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/blacknon/lsshell/config"
+	"golang.org/x/term"
+)
+
+// Target host prefix.
+// `@host[,host...]: command...` run command only on the specified hosts.
+// Host expression can be used as host. ex.) `@web*:`, `@tag:role=web:`, `@group:web-prod:`
+// Remote stages with different targets are connected through lsshell, so data can be streamed between hosts.
+//   ex.) @db01: pg_dump mydb | @db02: psql mydb
+
+// getTargetConnects return connects of targets. If targets is empty, return all connects.
+// Host expression (glob, regexp, `tag:TAG`, `group:NAME`) can be used as target, and it matches enabled hosts.
+func (s *shell) getTargetConnects(targets []string) (connects []*sConnect, err error) {
+	if len(targets) == 0 {
+		return s.getActiveConnects(), nil
+	}
+
+	all := s.getConnects()
+
+	var names []string
+	for _, c := range all {
+		names = append(names, c.Name)
+	}
+
+	added := map[*sConnect]bool{}
+	for _, t := range targets {
+		// host expression
+		if config.IsHostPattern(t) {
+			matched, err := s.ExtConfig.MatchHosts(names, []string{t})
+			if err != nil {
+				return nil, fmt.Errorf("@%s: %s", t, err)
+			}
+
+			count := 0
+			for _, c := range all {
+				if indexOf(matched, c.Name) < 0 || c.Disabled {
+					continue
+				}
+
+				count++
+				if !added[c] {
+					added[c] = true
+					connects = append(connects, c)
+				}
+			}
+
+			if count == 0 {
+				return nil, fmt.Errorf("@%s: no enabled host matched", t)
+			}
+			continue
+		}
+
+		var con *sConnect
+		for _, c := range all {
+			if c.Name == t {
+				con = c
+				break
+			}
+		}
+
+		switch {
+		case con == nil:
+			return nil, fmt.Errorf("@%s: host is not connected", t)
+		case con.Disabled:
+			return nil, fmt.Errorf("@%s: host is disabled", t)
+		}
+
+		if !added[con] {
+			added[con] = true
+			connects = append(connects, con)
+		}
+	}
+
+	return
+}
+
+// transferCounterInterval is interval of transfer progress.
+const transferCounterInterval = 1 * time.Second
+
+// transferCounter count bytes sent from target hosts to the next stage of pipeline.
+// While the stage is running, progress is printed to stderr as `\r` line (throttled, only when stderr is terminal),
+// and the result is printed when the stage is finished.
+// If the status panel is shown, the progress is shown in the OUTPUT column of the panel instead.
+type transferCounter struct {
+	name     string
+	start    time.Time
+	bytes    int64
+	done     chan bool
+	finished chan bool
+}
+
+// newTransferCounter return new transferCounter. If progress is true, start progress printer.
+func newTransferCounter(targets []string, progress bool) *transferCounter {
+	c := &transferCounter{
+		name:     strings.Join(targets, ","),
+		start:    time.Now(),
+		done:     make(chan bool),
+		finished: make(chan bool),
+	}
+
+	if !progress || !term.IsTerminal(int(os.Stderr.Fd())) {
+		close(c.finished)
+		return c
+	}
+
+	go func() {
+		defer close(c.finished)
+
+		ticker := time.NewTicker(transferCounterInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(os.Stderr, "\r\x1b[K%s", c.String())
+			case <-c.done:
+				// erase progress line
+				fmt.Fprintf(os.Stderr, "\r\x1b[K")
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// Write count bytes.
+func (c *transferCounter) Write(p []byte) (n int, err error) {
+	atomic.AddInt64(&c.bytes, int64(len(p)))
+	return len(p), nil
+}
+
+// Stop stop progress printer, and print the result.
+func (c *transferCounter) Stop() {
+	close(c.done)
+	<-c.finished
+
+	fmt.Fprintf(os.Stderr, "%s\n", c.String())
+}
+
+// String return progress text. ex.) `[Transfer:db01] 12.3 MB (4.1 MB/s)`
+func (c *transferCounter) String() string {
+	b := atomic.LoadInt64(&c.bytes)
+
+	rate := float64(b)
+	if sec := time.Since(c.start).Seconds(); sec > 0 {
+		rate = rate / sec
+	}
+
+	return fmt.Sprintf("[Transfer:%s] %s (%s/s)", c.name, formatBytes(float64(b)), formatBytes(rate))
+}
+
+// formatBytes return human readable size.
+func formatBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b = b / 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
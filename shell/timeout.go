@@ -0,0 +1,211 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Command timeout.
+// `%timeout [-d] <duration> command...` or `timeout` in config set the timeout of remote command.
+// Hosts not finished in timeout get SIGINT, SIGKILL, and the session is closed.
+// With `-d` (or `timeoutdetach` in config), they are detached to background instead.
+
+const (
+	// timeoutKillGrace is wait time after SIGINT, before SIGKILL.
+	timeoutKillGrace = 2 * time.Second
+
+	// timeoutCloseGrace is wait time after SIGKILL, before close session.
+	timeoutCloseGrace = 1 * time.Second
+)
+
+// parseTimeout parse duration of `%timeout`. `0` is no timeout (returns negative value).
+func parseTimeout(str string) (timeout time.Duration, err error) {
+	timeout, err = time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("lsshell: %%timeout: invalid duration `%s`", str)
+	}
+
+	if timeout <= 0 {
+		timeout = -1
+	}
+
+	return
+}
+
+// sessionResult is result of remote session.
+type sessionResult struct {
+	index   int
+	success bool
+}
+
+// switchWriter is writer that the output can be stopped and restarted.
+// It is used to stop the output of detached hosts, and background jobs.
+type switchWriter struct {
+	w        io.Writer
+	detached bool
+	mutex    *sync.Mutex
+}
+
+// newSwitchWriter return new switchWriter of w.
+func newSwitchWriter(w io.Writer) *switchWriter {
+	return &switchWriter{
+		w:     w,
+		mutex: new(sync.Mutex),
+	}
+}
+
+// Write write p to w. If detached, p is discarded.
+func (sw *switchWriter) Write(p []byte) (n int, err error) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	if sw.detached {
+		return len(p), nil
+	}
+
+	return sw.w.Write(p)
+}
+
+// Detach discard the following output.
+func (sw *switchWriter) Detach() {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	sw.detached = true
+}
+
+// Attach restart the output to w.
+func (sw *switchWriter) Attach() {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	sw.detached = false
+}
+
+// IsDetached return true if detached.
+func (sw *switchWriter) IsDetached() bool {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	return sw.detached
+}
+
+// getTimeout return timeout and detach option of pline. If pline has no timeout, default (config) is used.
+func (s *shell) getTimeout(pline pipeLine) (timeout time.Duration, detach bool) {
+	switch {
+	case pline.Timeout > 0:
+		return pline.Timeout, pline.TimeoutDetach
+	case pline.Timeout < 0:
+		return 0, false
+	}
+
+	return s.Options.CommandTimeout, s.Options.CommandTimeoutDetach
+}
+
+// waitSessions wait for sessions, until timeout. If timeout is 0, wait for all sessions.
+// It returns true if all sessions are successful.
+// statuses is host status of each session (status of background job for job).
+func (s *shell) waitSessions(sessions []*ssh.Session, names []string, statuses []*hostStatus, outputs []*switchWriter, exit <-chan sessionResult, timeout time.Duration, detach bool) (status bool) {
+	status = true
+	done := make([]bool, len(sessions))
+
+	// receive results until all done, or d is elapsed (d == 0 is no limit).
+	receive := func(d time.Duration) {
+		var timer <-chan time.Time
+		if d > 0 {
+			timer = time.After(d)
+		}
+
+		for !allDone(done) {
+			select {
+			case r := <-exit:
+				done[r.index] = true
+				if !r.success {
+					status = false
+				}
+			case <-timer:
+				return
+			}
+		}
+	}
+
+	receive(timeout)
+	if allDone(done) {
+		return
+	}
+
+	// timeout
+	status = false
+	var stragglers []int
+	for i := range sessions {
+		if done[i] {
+			continue
+		}
+		stragglers = append(stragglers, i)
+
+		statuses[i].setTimeout(detach)
+	}
+
+	// detach to background
+	if detach {
+		for _, i := range stragglers {
+			fmt.Fprintf(outputs[i], "lsshell: timeout (%s), detached to background\n", timeout)
+			outputs[i].Detach()
+		}
+
+		// notify when detached command finished
+		go func() {
+			for range stragglers {
+				r := <-exit
+				fmt.Fprintf(os.Stderr, "[%s] detached command finished. (success: %t)\n", names[r.index], r.success)
+			}
+		}()
+
+		return
+	}
+
+	// SIGINT
+	for _, i := range stragglers {
+		fmt.Fprintf(outputs[i], "lsshell: timeout (%s), killed\n", timeout)
+		sessions[i].Signal(ssh.SIGINT)
+	}
+	receive(timeoutKillGrace)
+
+	// SIGKILL
+	for _, i := range stragglers {
+		if !done[i] {
+			sessions[i].Signal(ssh.SIGKILL)
+		}
+	}
+	receive(timeoutCloseGrace)
+
+	// close session
+	for _, i := range stragglers {
+		if !done[i] {
+			sessions[i].Close()
+		}
+	}
+	receive(0)
+
+	return false
+}
+
+// allDone return true if all element is true.
+func allDone(done []bool) bool {
+	for _, d := range done {
+		if !d {
+			return false
+		}
+	}
+
+	return true
+}
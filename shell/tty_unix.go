@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build !windows
+
+package shell
+
+import (
+	"io"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ttyReader is reader of terminal input, that can be stopped by Close.
+// os.Stdin can not stop blocking read, and the reading goroutine steals the input of the next prompt.
+// So /dev/tty is opened, and Read waits for it and the wakeup pipe with poll. Close writes to the wakeup pipe.
+type ttyReader struct {
+	fd     int
+	wakeR  int // read end of wakeup pipe
+	wakeW  int // write end of wakeup pipe
+	mutex  *sync.Mutex
+	once   *sync.Once
+	closed bool
+}
+
+// openTTYReader open /dev/tty as ttyReader.
+func openTTYReader() (r *ttyReader, err error) {
+	fd, err := syscall.Open("/dev/tty", syscall.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+
+	// non-blocking, not to block when poll returns without input
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		syscall.Close(fd)
+		return
+	}
+
+	wake := make([]int, 2)
+	err = unix.Pipe(wake)
+	if err != nil {
+		syscall.Close(fd)
+		return
+	}
+
+	r = &ttyReader{
+		fd:    fd,
+		wakeR: wake[0],
+		wakeW: wake[1],
+		mutex: new(sync.Mutex),
+		once:  new(sync.Once),
+	}
+
+	return
+}
+
+// Read read terminal input. It returns io.EOF after Close.
+// Close waits for the running Read, so the fd is not closed while it is used.
+func (r *ttyReader) Read(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for {
+		if r.closed {
+			return 0, io.EOF
+		}
+
+		fds := []unix.PollFd{
+			{Fd: int32(r.fd), Events: unix.POLLIN},
+			{Fd: int32(r.wakeR), Events: unix.POLLIN},
+		}
+		_, err = unix.Poll(fds, -1)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return 0, err
+		case fds[1].Revents != 0:
+			// woken up by Close
+			return 0, io.EOF
+		case fds[0].Revents == 0:
+			continue
+		}
+
+		n, err = syscall.Read(r.fd, p)
+		switch {
+		case err == syscall.EAGAIN || err == syscall.EINTR:
+			continue
+		case err != nil:
+			return 0, err
+		case n == 0:
+			return 0, io.EOF
+		}
+
+		return
+	}
+}
+
+// Close stop Read, and close /dev/tty after the running Read returns.
+func (r *ttyReader) Close() (err error) {
+	r.once.Do(func() {
+		// wake up Read
+		syscall.Write(r.wakeW, []byte{0})
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.closed = true
+		syscall.Close(r.wakeR)
+		syscall.Close(r.wakeW)
+		err = syscall.Close(r.fd)
+	})
+
+	return
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build windows
+
+package shell
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// ttyReader is reader of terminal input.
+// On Windows, os.Stdin is used. Read after Close returns io.EOF,
+// but the blocking read can not be stopped.
+type ttyReader struct {
+	done chan struct{}
+	once *sync.Once
+}
+
+// openTTYReader return ttyReader of os.Stdin.
+func openTTYReader() (r *ttyReader, err error) {
+	r = &ttyReader{
+		done: make(chan struct{}),
+		once: new(sync.Once),
+	}
+
+	return
+}
+
+// Read read terminal input. It returns io.EOF after Close.
+func (r *ttyReader) Read(p []byte) (n int, err error) {
+	select {
+	case <-r.done:
+		return 0, io.EOF
+	default:
+	}
+
+	return os.Stdin.Read(p)
+}
+
+// Close stop Read.
+func (r *ttyReader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+
+	return nil
+}
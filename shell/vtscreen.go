@@ -0,0 +1,435 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// vtScreen is a minimal vt100 terminal screen, used to render each host in `%sync`.
+// It supports cursor movement, erase, scroll and insert/delete. Colors and attributes are ignored.
+type vtScreen struct {
+	rows, cols int
+	cells      [][]rune
+
+	// cursor
+	x, y   int
+	sx, sy int // saved cursor
+
+	// scroll region
+	top, bottom int
+
+	// escape sequence parse state
+	state  int
+	params []byte
+	buf    []byte // incomplete utf-8
+
+	mutex *sync.Mutex
+}
+
+// vtScreen parse state
+const (
+	vtStateNormal = iota
+	vtStateEscape
+	vtStateCSI
+	vtStateOSC
+	vtStateCharset
+)
+
+// newVTScreen return new vtScreen of size.
+func newVTScreen(rows, cols int) *vtScreen {
+	v := &vtScreen{mutex: new(sync.Mutex)}
+	v.resize(rows, cols)
+
+	return v
+}
+
+// Resize change screen size. The content is kept as much as possible.
+func (v *vtScreen) Resize(rows, cols int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.resize(rows, cols)
+}
+
+func (v *vtScreen) resize(rows, cols int) {
+	if rows < 1 {
+		rows = 1
+	}
+	if cols < 1 {
+		cols = 1
+	}
+
+	// if rows is decreased, the top lines of old screen are dropped (as scroll).
+	// if rows is increased, the old lines are kept at the top.
+	offset := 0
+	if d := len(v.cells) - rows; d > 0 {
+		offset = d
+	}
+
+	cells := make([][]rune, rows)
+	for i := range cells {
+		cells[i] = make([]rune, cols)
+		for j := range cells[i] {
+			cells[i][j] = ' '
+		}
+
+		if old := offset + i; old < len(v.cells) {
+			copy(cells[i], v.cells[old])
+		}
+	}
+
+	v.y -= offset
+
+	v.rows, v.cols = rows, cols
+	v.cells = cells
+	v.top, v.bottom = 0, rows-1
+	v.clampCursor()
+}
+
+// Lines return copy of screen lines.
+func (v *vtScreen) Lines() (lines [][]rune, x, y int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, l := range v.cells {
+		lines = append(lines, append([]rune{}, l...))
+	}
+
+	return lines, v.x, v.y
+}
+
+// Write parse terminal output, and update screen.
+func (v *vtScreen) Write(p []byte) (n int, err error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	data := append(v.buf, p...)
+	v.buf = nil
+
+	for len(data) > 0 {
+		// incomplete utf-8 is kept for next write
+		if !utf8.FullRune(data) {
+			v.buf = append([]byte{}, data...)
+			break
+		}
+
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		v.put(r)
+	}
+
+	return len(p), nil
+}
+
+// put process one rune.
+func (v *vtScreen) put(r rune) {
+	switch v.state {
+	case vtStateEscape:
+		v.escape(r)
+		return
+
+	case vtStateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			v.csi(r)
+			v.state = vtStateNormal
+		} else {
+			v.params = append(v.params, byte(r))
+		}
+		return
+
+	case vtStateOSC:
+		// OSC ends with BEL or ST (ESC \)
+		switch r {
+		case 0x07:
+			v.state = vtStateNormal
+		case 0x1b:
+			v.state = vtStateEscape
+		}
+		return
+
+	case vtStateCharset:
+		v.state = vtStateNormal
+		return
+	}
+
+	switch r {
+	case 0x1b: // ESC
+		v.state = vtStateEscape
+	case '\r':
+		v.x = 0
+	case '\n', 0x0b, 0x0c:
+		v.lineFeed()
+	case '\b':
+		if v.x > 0 {
+			v.x--
+		}
+	case '\t':
+		v.x = (v.x/8 + 1) * 8
+		if v.x >= v.cols {
+			v.x = v.cols - 1
+		}
+	case 0x07, 0x00, 0x0e, 0x0f: // BEL, NUL, SO, SI
+	default:
+		if r < 0x20 {
+			return
+		}
+
+		if v.x >= v.cols {
+			v.x = 0
+			v.lineFeed()
+		}
+		v.cells[v.y][v.x] = r
+		v.x++
+	}
+}
+
+// escape process rune after ESC.
+func (v *vtScreen) escape(r rune) {
+	v.state = vtStateNormal
+
+	switch r {
+	case '[':
+		v.state = vtStateCSI
+		v.params = v.params[:0]
+	case ']':
+		v.state = vtStateOSC
+	case '(', ')', '*', '+':
+		v.state = vtStateCharset
+	case '7':
+		v.sx, v.sy = v.x, v.y
+	case '8':
+		v.x, v.y = v.sx, v.sy
+		v.clampCursor()
+	case 'D':
+		v.lineFeed()
+	case 'E':
+		v.x = 0
+		v.lineFeed()
+	case 'M': // reverse index
+		if v.y == v.top {
+			v.scrollDown(1)
+		} else if v.y > 0 {
+			v.y--
+		}
+	case 'c': // reset
+		v.clear(0, 0, v.rows-1, v.cols-1)
+		v.x, v.y = 0, 0
+		v.top, v.bottom = 0, v.rows-1
+	}
+}
+
+// csi process control sequence `ESC [ params final`.
+func (v *vtScreen) csi(final rune) {
+	private := strings.HasPrefix(string(v.params), "?")
+	ps := parseVTParams(strings.TrimLeft(string(v.params), "?>="))
+
+	// get param (default is def)
+	p := func(i, def int) int {
+		if i < len(ps) && ps[i] > 0 {
+			return ps[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		v.y -= p(0, 1)
+	case 'B', 'e':
+		v.y += p(0, 1)
+	case 'C', 'a':
+		v.x += p(0, 1)
+	case 'D':
+		v.x -= p(0, 1)
+	case 'E':
+		v.x = 0
+		v.y += p(0, 1)
+	case 'F':
+		v.x = 0
+		v.y -= p(0, 1)
+	case 'G', '`':
+		v.x = p(0, 1) - 1
+	case 'd':
+		v.y = p(0, 1) - 1
+	case 'H', 'f':
+		v.y = p(0, 1) - 1
+		v.x = p(1, 1) - 1
+
+	case 'J': // erase in display
+		switch p(0, 0) {
+		case 0:
+			v.clear(v.y, v.x, v.y, v.cols-1)
+			if v.y < v.rows-1 {
+				v.clear(v.y+1, 0, v.rows-1, v.cols-1)
+			}
+		case 1:
+			if v.y > 0 {
+				v.clear(0, 0, v.y-1, v.cols-1)
+			}
+			v.clear(v.y, 0, v.y, v.x)
+		default:
+			v.clear(0, 0, v.rows-1, v.cols-1)
+		}
+
+	case 'K': // erase in line
+		switch p(0, 0) {
+		case 0:
+			v.clear(v.y, v.x, v.y, v.cols-1)
+		case 1:
+			v.clear(v.y, 0, v.y, v.x)
+		default:
+			v.clear(v.y, 0, v.y, v.cols-1)
+		}
+
+	case 'X': // erase characters
+		v.clear(v.y, v.x, v.y, v.x+p(0, 1)-1)
+
+	case 'P': // delete characters
+		line := v.cells[v.y]
+		n := p(0, 1)
+		if v.x+n > v.cols {
+			n = v.cols - v.x
+		}
+		copy(line[v.x:], line[v.x+n:])
+		v.clear(v.y, v.cols-n, v.y, v.cols-1)
+
+	case '@': // insert characters
+		line := v.cells[v.y]
+		n := p(0, 1)
+		if v.x+n > v.cols {
+			n = v.cols - v.x
+		}
+		copy(line[v.x+n:], line[v.x:])
+		v.clear(v.y, v.x, v.y, v.x+n-1)
+
+	case 'L': // insert lines
+		if v.y >= v.top && v.y <= v.bottom {
+			top := v.top
+			v.top = v.y
+			v.scrollDown(p(0, 1))
+			v.top = top
+		}
+
+	case 'M': // delete lines
+		if v.y >= v.top && v.y <= v.bottom {
+			top := v.top
+			v.top = v.y
+			v.scrollUp(p(0, 1))
+			v.top = top
+		}
+
+	case 'S':
+		v.scrollUp(p(0, 1))
+	case 'T':
+		v.scrollDown(p(0, 1))
+
+	case 'r': // scroll region
+		v.top = p(0, 1) - 1
+		v.bottom = p(1, v.rows) - 1
+		if v.top < 0 || v.bottom >= v.rows || v.top >= v.bottom {
+			v.top, v.bottom = 0, v.rows-1
+		}
+		v.x, v.y = 0, 0
+
+	case 's':
+		v.sx, v.sy = v.x, v.y
+	case 'u':
+		v.x, v.y = v.sx, v.sy
+
+	case 'h', 'l': // mode
+		// alternate screen (clear screen at switch)
+		if private && (p(0, 0) == 1049 || p(0, 0) == 47 || p(0, 0) == 1047) {
+			v.clear(0, 0, v.rows-1, v.cols-1)
+			v.x, v.y = 0, 0
+		}
+	}
+
+	v.clampCursor()
+}
+
+// lineFeed move cursor to next line, and scroll if cursor is at the bottom of scroll region.
+func (v *vtScreen) lineFeed() {
+	if v.y == v.bottom {
+		v.scrollUp(1)
+		return
+	}
+
+	if v.y < v.rows-1 {
+		v.y++
+	}
+}
+
+// scrollUp scroll region up n lines.
+func (v *vtScreen) scrollUp(n int) {
+	for i := 0; i < n; i++ {
+		line := v.cells[v.top]
+		copy(v.cells[v.top:v.bottom], v.cells[v.top+1:v.bottom+1])
+		v.cells[v.bottom] = line
+		v.clear(v.bottom, 0, v.bottom, v.cols-1)
+	}
+}
+
+// scrollDown scroll region down n lines.
+func (v *vtScreen) scrollDown(n int) {
+	for i := 0; i < n; i++ {
+		line := v.cells[v.bottom]
+		copy(v.cells[v.top+1:v.bottom+1], v.cells[v.top:v.bottom])
+		v.cells[v.top] = line
+		v.clear(v.top, 0, v.top, v.cols-1)
+	}
+}
+
+// clear fill from (y1, x1) to (y2, x2) with space.
+func (v *vtScreen) clear(y1, x1, y2, x2 int) {
+	for y := y1; y <= y2 && y < v.rows; y++ {
+		start, end := 0, v.cols-1
+		if y == y1 {
+			start = x1
+		}
+		if y == y2 && x2 < end {
+			end = x2
+		}
+
+		for x := start; x <= end; x++ {
+			if y >= 0 && x >= 0 {
+				v.cells[y][x] = ' '
+			}
+		}
+	}
+}
+
+// clampCursor keep cursor in screen.
+func (v *vtScreen) clampCursor() {
+	if v.x < 0 {
+		v.x = 0
+	}
+	if v.x >= v.cols {
+		v.x = v.cols - 1
+	}
+	if v.y < 0 {
+		v.y = 0
+	}
+	if v.y >= v.rows {
+		v.y = v.rows - 1
+	}
+}
+
+// parseVTParams parse `n;n;n` of control sequence.
+func parseVTParams(s string) (ps []int) {
+	if s == "" {
+		return
+	}
+
+	for _, p := range strings.Split(s, ";") {
+		n, _ := strconv.Atoi(p)
+		ps = append(ps, n)
+	}
+
+	return
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Blacknon. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package shell
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// screenText return lines of v without trailing spaces, and cursor.
+func screenText(v *vtScreen) (lines []string, x, y int) {
+	cells, x, y := v.Lines()
+	for _, l := range cells {
+		lines = append(lines, strings.TrimRight(string(l), " "))
+	}
+
+	return
+}
+
+// TestVTScreen check screen and cursor after writing terminal output.
+func TestVTScreen(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows, cols int
+		input      string
+		want       []string
+		x, y       int
+	}{
+		// text
+		{name: "plain", rows: 3, cols: 5, input: "ab\r\ncd", want: []string{"ab", "cd", ""}, x: 2, y: 1},
+		{name: "wrap", rows: 3, cols: 5, input: "abcdefg", want: []string{"abcde", "fg", ""}, x: 2, y: 1},
+		{name: "backspace", rows: 3, cols: 5, input: "ab\bc", want: []string{"ac", "", ""}, x: 2, y: 0},
+		{name: "tab", rows: 1, cols: 10, input: "a\tb", want: []string{"a       b"}, x: 9, y: 0},
+		{name: "tab at right edge", rows: 1, cols: 5, input: "a\tb", want: []string{"a   b"}, x: 5, y: 0},
+		{name: "color is ignored", rows: 1, cols: 5, input: "\x1b[1;31mab\x1b[0m", want: []string{"ab"}, x: 2, y: 0},
+		{name: "title is ignored", rows: 1, cols: 5, input: "\x1b]0;title\x07ab", want: []string{"ab"}, x: 2, y: 0},
+		{name: "charset is ignored", rows: 1, cols: 5, input: "\x1b(Bab", want: []string{"ab"}, x: 2, y: 0},
+
+		// cursor movement
+		{name: "cursor position", rows: 3, cols: 5, input: "\x1b[2;3Hx", want: []string{"", "  x", ""}, x: 3, y: 1},
+		{name: "cursor home", rows: 3, cols: 5, input: "abc\r\nd\x1b[Hx", want: []string{"xbc", "d", ""}, x: 1, y: 0},
+		{name: "cursor up and back", rows: 3, cols: 5, input: "\x1b[3;5H\x1b[2A\x1b[3Dx", want: []string{" x", "", ""}, x: 2, y: 0},
+		{name: "cursor down and forward", rows: 3, cols: 5, input: "\x1b[B\x1b[2Cx", want: []string{"", "  x", ""}, x: 3, y: 1},
+		{name: "cursor column and line", rows: 3, cols: 5, input: "\x1b[3d\x1b[4Gx", want: []string{"", "", "   x"}, x: 4, y: 2},
+		{name: "cursor next and previous line", rows: 3, cols: 5, input: "ab\x1b[2Ec\x1b[Fd", want: []string{"ab", "d", "c"}, x: 1, y: 1},
+		{name: "cursor is clamped", rows: 3, cols: 5, input: "\x1b[10;10Hx\x1b[20A\x1b[20Dy", want: []string{"y", "", "    x"}, x: 1, y: 0},
+		{name: "save and restore (ESC 7/8)", rows: 3, cols: 5, input: "ab\x1b7\r\ncd\x1b8x", want: []string{"abx", "cd", ""}, x: 3, y: 0},
+		{name: "save and restore (CSI s/u)", rows: 3, cols: 5, input: "ab\x1b[s\r\ncd\x1b[ux", want: []string{"abx", "cd", ""}, x: 3, y: 0},
+
+		// scroll
+		{name: "scroll at bottom", rows: 3, cols: 5, input: "1\r\n2\r\n3\r\n4", want: []string{"2", "3", "4"}, x: 1, y: 2},
+		{name: "scroll up (CSI S)", rows: 3, cols: 5, input: "1\r\n2\r\n3\x1b[S", want: []string{"2", "3", ""}, x: 1, y: 2},
+		{name: "scroll down (CSI T)", rows: 3, cols: 5, input: "1\r\n2\x1b[T", want: []string{"", "1", "2"}, x: 1, y: 1},
+		{name: "scroll region", rows: 3, cols: 5, input: "\x1b[3;1Hz\x1b[1;2ra\r\nb\r\nc", want: []string{"b", "c", "z"}, x: 1, y: 1},
+		{name: "invalid scroll region is reset", rows: 3, cols: 5, input: "\x1b[2;1r1\r\n2\r\n3\r\n4", want: []string{"2", "3", "4"}, x: 1, y: 2},
+		{name: "reverse index", rows: 3, cols: 5, input: "a\r\nb\x1bM\x1bMx", want: []string{" x", "a", "b"}, x: 2, y: 0},
+		{name: "index (ESC D)", rows: 2, cols: 5, input: "a\x1bDb\x1bDc", want: []string{" b", "  c"}, x: 3, y: 1},
+		{name: "insert lines", rows: 3, cols: 5, input: "1\r\n2\r\n3\x1b[2;1H\x1b[L", want: []string{"1", "", "2"}, x: 0, y: 1},
+		{name: "delete lines", rows: 3, cols: 5, input: "1\r\n2\r\n3\x1b[1;1H\x1b[M", want: []string{"2", "3", ""}, x: 0, y: 0},
+
+		// erase
+		{name: "erase line to end", rows: 1, cols: 5, input: "abcde\x1b[3G\x1b[K", want: []string{"ab"}, x: 2, y: 0},
+		{name: "erase line to start", rows: 1, cols: 5, input: "abcde\x1b[3G\x1b[1K", want: []string{"   de"}, x: 2, y: 0},
+		{name: "erase whole line", rows: 1, cols: 5, input: "abcde\x1b[2G\x1b[2K", want: []string{""}, x: 1, y: 0},
+		{name: "erase display below", rows: 3, cols: 5, input: "abc\r\ndef\r\nghi\x1b[2;2H\x1b[J", want: []string{"abc", "d", ""}, x: 1, y: 1},
+		{name: "erase display above", rows: 3, cols: 5, input: "abc\r\ndef\r\nghi\x1b[2;2H\x1b[1J", want: []string{"", "  f", "ghi"}, x: 1, y: 1},
+		{name: "erase display all", rows: 3, cols: 5, input: "abc\r\ndef\r\nghi\x1b[2;2H\x1b[2J", want: []string{"", "", ""}, x: 1, y: 1},
+		{name: "erase characters", rows: 1, cols: 5, input: "abcde\x1b[2G\x1b[2X", want: []string{"a  de"}, x: 1, y: 0},
+		{name: "delete characters", rows: 1, cols: 5, input: "abcde\x1b[2G\x1b[2P", want: []string{"ade"}, x: 1, y: 0},
+		{name: "insert characters", rows: 1, cols: 5, input: "abcde\x1b[2G\x1b[2@", want: []string{"a  bc"}, x: 1, y: 0},
+		{name: "alternate screen", rows: 2, cols: 5, input: "ab\r\ncd\x1b[?1049h", want: []string{"", ""}, x: 0, y: 0},
+		{name: "reset", rows: 2, cols: 5, input: "ab\r\ncd\x1bc", want: []string{"", ""}, x: 0, y: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVTScreen(tt.rows, tt.cols)
+			v.Write([]byte(tt.input))
+
+			lines, x, y := screenText(v)
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("lines = %q, want %q", lines, tt.want)
+			}
+
+			if x != tt.x || y != tt.y {
+				t.Errorf("cursor = (%d, %d), want (%d, %d)", x, y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+// TestVTScreenSplitWrite check that escape sequence and utf-8 split between writes are parsed.
+func TestVTScreenSplitWrite(t *testing.T) {
+	v := newVTScreen(2, 5)
+
+	input := []byte("\x1b[2;2Hあ")
+	for _, b := range input {
+		v.Write([]byte{b})
+	}
+
+	lines, x, y := screenText(v)
+	want := []string{"", " あ"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+
+	if x != 2 || y != 1 {
+		t.Errorf("cursor = (%d, %d), want (2, 1)", x, y)
+	}
+}
+
+// TestVTScreenResize check that content and cursor are kept at resize.
+func TestVTScreenResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows, cols int
+		want       []string
+		x, y       int
+	}{
+		{name: "smaller drops top lines", rows: 2, cols: 5, want: []string{"2", "3x"}, x: 2, y: 1},
+		{name: "narrower truncates lines", rows: 3, cols: 1, want: []string{"1", "2", "3"}, x: 0, y: 2},
+		{name: "larger keeps lines at top", rows: 4, cols: 6, want: []string{"1", "2", "3x", ""}, x: 2, y: 2},
+		{name: "same size", rows: 3, cols: 5, want: []string{"1", "2", "3x"}, x: 2, y: 2},
+		{name: "invalid size is one cell", rows: 0, cols: 0, want: []string{"3"}, x: 0, y: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVTScreen(3, 5)
+			v.Write([]byte("1\r\n2\r\n3x"))
+			v.Resize(tt.rows, tt.cols)
+
+			lines, x, y := screenText(v)
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("lines = %q, want %q", lines, tt.want)
+			}
+
+			if x != tt.x || y != tt.y {
+				t.Errorf("cursor = (%d, %d), want (%d, %d)", x, y, tt.x, tt.y)
+			}
+		})
+	}
+}